@@ -1,63 +1,227 @@
 document.addEventListener('DOMContentLoaded', function () {
+    const server = 'http://localhost:8000';
     const form = document.getElementById('balanceForm');
     const resultDiv = document.getElementById('result');
+    const statusDiv = document.getElementById('status');
 
-    form.addEventListener('submit', async function (e) {
-        e.preventDefault();
+    // The date input gives yyyy-mm-dd, the API expects dd/mm/yyyy
+    function formatDate(value) {
+        const [year, month, day] = value.split('-');
+        return `${day}/${month}/${year}`;
+    }
+
+    function cellText(value) {
+        if (Array.isArray(value)) {
+            return value.join('; ');
+        }
+        if (value !== null && typeof value === 'object') {
+            return JSON.stringify(value);
+        }
+        return value;
+    }
+
+    function appendRow(table, values) {
+        const tr = document.createElement('tr');
+
+        values.forEach((elem) => {
+            const td = document.createElement('td');
+            td.innerText = cellText(elem);
+            tr.appendChild(td);
+        });
+
+        table.appendChild(tr);
+    }
+
+    function headerRow(cols) {
+        const thead = document.createElement('thead');
+        const tr = document.createElement('tr');
+
+        cols.forEach((item) => {
+            const th = document.createElement('th');
+            th.innerText = item;
+            tr.appendChild(th);
+        });
+
+        thead.appendChild(tr);
+        return thead;
+    }
+
+    function heading(title) {
+        const h2 = document.createElement('h2');
+        h2.innerText = title;
+        resultDiv.appendChild(h2);
+    }
+
+    // Render an array of objects as a table under a heading
+    function renderTable(title, data) {
+        heading(title);
 
-        const address = form.elements.address.value;
-        const chain = form.elements.chain.value;
-        const date = form.elements.date.value;
-        const timestamp = form.elements.timestamp.value;
+        if (data.length === 0) {
+            const empty = document.createElement('p');
+            empty.innerText = 'None held at the selected block.';
+            resultDiv.appendChild(empty);
+            return;
+        }
+
+        // Create a table element
+        const table = document.createElement('table');
+        const cols = Object.keys(data[0]);
+
+        table.appendChild(headerRow(cols));
+        data.forEach((item) => appendRow(table, cols.map((col) => item[col])));
+
+        resultDiv.appendChild(table);
+    }
+
+    function showStatus(text) {
+        statusDiv.innerText = text;
+    }
+
+    function showError(text) {
+        const p = document.createElement('p');
+        p.className = 'error';
+        p.innerText = text;
+        resultDiv.appendChild(p);
+    }
+
+    // Signs in with the API key, if one was entered, so the session cookie authenticates the requests that follow.
+    // The key is cleared from the page once the session is open.
+    async function signIn() {
+        const apiKey = form.elements.api_key.value.trim();
+        if (apiKey === '') {
+            return true;
+        }
 
-        const response = await fetch('http://localhost:8000/balances', {
+        const response = await fetch(`${server}/session`, {
             method: 'POST',
+            credentials: 'include',
             headers: {
                 'Content-Type': 'application/json'
             },
-            body: JSON.stringify({
-                address: address,
-                chain: chain,
-                date: date,
-                timestamp: timestamp,
-            }),
+            body: JSON.stringify({ api_key: apiKey }),
         });
 
-        if (response.ok) {
-            const data = await response.json();
-            // resultDiv.innerHTML = ''; // Clear any previous content
+        if (!response.ok) {
+            const { error } = await response.json()
+                .catch(() => ({ error: { message: response.statusText, code: response.status } }));
+            showError(`Error signing in: ${error.message} (${error.code})`);
+            return false;
+        }
+
+        form.elements.api_key.value = '';
+        return true;
+    }
+
+    // Streams the balances, adding each row to the table as the server completes it.
+    // Resolves once the server sends the final done event.
+    function streamBalances(params) {
+        return new Promise((resolve) => {
+            heading('Token balances');
 
-            // Create a table element
             const table = document.createElement('table');
-            const cols = Object.keys(data[0]);
-            const thead = document.createElement('thead');
-            const tr = document.createElement('tr');
+            resultDiv.appendChild(table);
+
+            let cols = null;
+            let rows = 0;
+
+            const source = new EventSource(`${server}/balances/stream?${params}`, { withCredentials: true });
+
+            const wallet = (event) => `${event.address} on ${event.chain} (${event.wallet} of ${event.wallets})`;
 
-            cols.forEach((item) => {
-                const th = document.createElement('th');
-                th.innerText = item;
-                tr.appendChild(th);
+            source.addEventListener('started', (e) => {
+                showStatus(`Resolving the block for ${wallet(JSON.parse(e.data))}...`);
             });
 
-            thead.appendChild(tr);
-            table.append(tr);
+            source.addEventListener('block', (e) => {
+                const event = JSON.parse(e.data);
+                showStatus(`Reading balances for ${wallet(event)} at block ${event.block_number}...`);
+            });
+
+            source.addEventListener('balances', (e) => {
+                const event = JSON.parse(e.data);
+                showStatus(`Checking ${event.tokens || 0} tokens for ${wallet(event)}...`);
+            });
 
-            data.forEach((item) => {
-                const tr = document.createElement('tr');
-                const vals = Object.values(item);
+            source.addEventListener('row', (e) => {
+                const event = JSON.parse(e.data);
 
-                vals.forEach((elem) => {
-                    const td = document.createElement('td');
-                    td.innerText = elem;
-                    tr.appendChild(td);
-                });
+                if (cols === null) {
+                    cols = Object.keys(event.row);
+                    table.appendChild(headerRow(cols));
+                }
 
-                table.appendChild(tr);
+                appendRow(table, cols.map((col) => event.row[col]));
+                rows++;
             });
 
-            resultDiv.appendChild(table);
+            source.addEventListener('completed', (e) => {
+                const event = JSON.parse(e.data);
+                showStatus(`${wallet(event)}: ${event.rows} rows, run ${event.run_id}`);
+            });
+
+            source.addEventListener('failed', (e) => {
+                const event = JSON.parse(e.data);
+                showError(`${event.address} on ${event.chain}: ${event.error.message} (${event.error.code})`);
+            });
+
+            // raised by the browser when the connection drops or the request is refused
+            source.addEventListener('error', () => {
+                source.close();
+                showError('Lost the connection to the server, or it refused the request. Sign in with an API key if the session has expired.');
+                resolve();
+            });
+
+            source.addEventListener('done', () => {
+                source.close();
+                showStatus('');
+
+                if (rows === 0) {
+                    const empty = document.createElement('p');
+                    empty.innerText = 'None held at the selected block.';
+                    resultDiv.appendChild(empty);
+                }
+
+                resolve();
+            });
+        });
+    }
+
+    form.addEventListener('submit', async function (e) {
+        e.preventDefault();
+
+        resultDiv.innerHTML = '';
+
+        if (!await signIn()) {
+            return;
+        }
+
+        const fields = {
+            address: form.elements.address.value,
+            chain: form.elements.chain.value,
+            date: formatDate(form.elements.date.value),
+            timestamp: form.elements.timestamp.value,
+        };
+
+        await streamBalances(new URLSearchParams(fields));
+
+        const request = {
+            method: 'POST',
+            credentials: 'include',
+            headers: {
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify(fields),
+        };
+
+        // NFT holdings are reported in their own section
+        const nftResponse = await fetch(`${server}/nfts`, request);
+
+        if (nftResponse.ok) {
+            renderTable('NFT holdings', await nftResponse.json());
         } else {
-            resultDiv.innerHTML = 'Error fetching data from the server. ';
+            const { error } = await nftResponse.json()
+                .catch(() => ({ error: { message: nftResponse.statusText, code: nftResponse.status } }));
+            showError(`Error fetching NFT holdings: ${error.message} (${error.code})`);
         }
     });
 });
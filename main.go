@@ -1,436 +1,592 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
-	"math"
-	"net/http"
 	"os"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/harrisandtrotter/proof-of-balance/backend/api"
-	"github.com/harrisandtrotter/proof-of-balance/backend/blocks"
-	"github.com/harrisandtrotter/proof-of-balance/backend/initialisers"
-	"github.com/harrisandtrotter/proof-of-balance/backend/prices"
-	"github.com/sqweek/dialog"
+	"github.com/harrisandtrotter/proof-of-balance/server/api"
+	"github.com/harrisandtrotter/proof-of-balance/server/auth"
+	"github.com/harrisandtrotter/proof-of-balance/server/blocks"
+	"github.com/harrisandtrotter/proof-of-balance/server/confirmation"
+	"github.com/harrisandtrotter/proof-of-balance/server/evidence"
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/reperform"
+	"github.com/harrisandtrotter/proof-of-balance/server/report"
+	"github.com/harrisandtrotter/proof-of-balance/server/runs"
+	"github.com/harrisandtrotter/proof-of-balance/server/scheduler"
 )
 
+// Exit codes
 const (
-	// Chain variables for Moralis API
-	Ethereum          = "eth"
-	Arbitrum          = "arbitrum"
-	Polygon           = "polygon"
-	BinanceSmartChain = "bsc"
-	Fantom            = "fantom"
-	Avalanche         = "avalanche"
-	Cronos            = "cronos"
-
-	// Token balance checker urls for revieiwing balance in CSV. Work-in-progress
-	EthereumTokenChecker   = "https://etherscan.io/tokencheck-tool"
-	ArbitrumTokenChecker   = "https://arbiscan.io/tokencheck-tool"
-	PolygonTokenChecker    = "https://polygonscan.com/tokencheck-tool"
-	BSCTokenChecker        = "https://bscscan.com/tokencheck-tool"
-	FantomTokenChecker     = "https://ftmscan.com/tokencheck-tool"
-	AvalancheTokenChecker  = "https://snowtrace.io/tokencheck-tool"
-	CronosTokenChecker     = "https://cronoscan.com/tokencheck-tool"
-	EthereumNativeChecker  = "https://etherscan.io/balancecheck-tool"
-	ArbitrumNativeChecker  = "https://arbiscan.io/balancecheck-tool"
-	PolygonNativeChecker   = "https://polygonscan.com/balancecheck-tool"
-	BSCNativeChecker       = "https://bscscan.com/balancecheck-tool"
-	FantomNativeChecker    = "https://ftmscan.com/balancecheck-tool"
-	AvalancheNativeChecker = "https://snowtrace.io/balancecheck-tool"
-	CronosNativeChecker    = "https://cronoscan.com/balancecheck-tool"
-
-	// Native tokens
-	ETH   = "ETH"
-	ARB   = "ETH"
-	BSC   = "BNB"
-	FTM   = "FTM"
-	MATIC = "MATIC"
-	AVAX  = "AVAX"
-	CRO   = "CRO"
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
 )
 
-var block blocks.Block
+const usage = `Usage: pob <command> [flags]
 
-// Data structure
-type TokenBalance struct {
-	TokenAdress string `json:"token_address"`
-	Name        string `json:"name"`
-	Symbol      string `json:"symbol"`
-	Logo        string `json:"logo"`
-	Thumbnail   string `json:"thumbnail"`
-	Decimals    int    `json:"decimals"`
-	Balance     string `json:"balance"`
-}
+Commands:
+  serve       Run the API server
+  prove       Perform proof of balance for the wallets in a CSV file
+  block       Resolve the block at a timestamp
+  confirm     Render a signed PDF balance confirmation from stored runs
+  verify      Check the integrity and signature of evidence packs and confirmations
+  reperform   Re-execute a stored run or evidence pack and diff the results
+  apikey      Issue an API key to a tenant
 
-type NativeBalance struct {
-	Balance string `json:"balance"`
-}
+Run "pob <command> -h" for the command's flags.
+`
+
+var block blocks.Block
 
+// Wallet listed in the input CSV: the address in column A and the chain in column B
 type TokenFile struct {
 	Address string
 	Chain   string
-	Block   string
-}
-
-type Block struct {
-	Block          int    `json:"block"`
-	Date           string `json:"date"`
-	Timestamp      int    `json:"timestamp"`
-	BlockTimestamp string `json:"block_timestamp"`
-	Hash           string `json:"hash"`
-	ParentHash     string `json:"parent_hash"`
 }
 
 func init() {
 	initialisers.LoadEnvironment()
 	initialisers.LoadAPIKey()
+	initialisers.LoadOverridesFile()
+	initialisers.LoadRPCEndpoints()
+	initialisers.LoadSpamListFile()
+	initialisers.LoadBeaconURL()
+	initialisers.LoadStakingEndpoints()
+	initialisers.LoadConsensus()
+	initialisers.LoadCompleteness()
+	initialisers.LoadEvidence()
+	initialisers.LoadRuns()
+	initialisers.LoadConfirmationTemplate()
+	initialisers.LoadScheduler()
+	initialisers.LoadAuth()
 }
 
 func main() {
-	// for {
-	// 	fmt.Println("\nWelcome to Proof-of-Balance. \nSee below for the available options. ")
-	// 	fmt.Println("1. Block number")
-	// 	fmt.Println("2. Retrieve balances")
-
-	// 	reader := bufio.NewReader(os.Stdin)
-	// 	fmt.Print("\nSelect the option you want to use today (e.g., 1 for receiving specific block number): \n")
-	// 	input, _ := reader.ReadString('\n')
-
-	// 	switch strings.TrimSpace(input) {
-	// 	case "1":
-	// 		fmt.Println("Coming soon.")
-	// 	case "2":
-	// 		fmt.Println("Please select the CSV file. \nThe format needs to be the wallet address in the column A and the relevant chain in column B. \n\nThe available chains and the required format are as follows: \neth\narbitrum\nfantom\nbsc\npolygon\navalanche\ncronos")
-	// 		time.Sleep(time.Second * 1)
-	// 		GetTokenBalance()
-	// 	default:
-	// 		fmt.Println("Invalid option selected.")
-	// 	}
-
-	// 	time.Sleep(time.Second * 3)
-	// }
-	api.Setup()
+	// no command keeps the original behaviour of running the API server
+	if len(os.Args) < 2 {
+		os.Exit(Serve(nil))
+	}
+
+	commands := map[string]func([]string) int{
+		"serve":     Serve,
+		"prove":     Prove,
+		"block":     Block,
+		"confirm":   Confirm,
+		"verify":    VerifyEvidence,
+		"reperform": ReperformRun,
+		"apikey":    IssueAPIKey,
+	}
+
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		if os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "--help" {
+			os.Exit(exitOK)
+		}
+
+		os.Exit(exitUsage)
+	}
 
+	os.Exit(command(os.Args[2:]))
 }
 
-// Main get balance function
-func GetTokenBalance() {
-	// Get user to select CSV input file
-	filename, err := dialog.File().Filter("CSV file", "csv").Load()
-	if err != nil {
-		fmt.Println("Error finding file:", &err)
+// Runs the API server.
+func Serve(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", ":8000", "address to listen on")
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
 	}
 
-	// Parse inputted CSV to accessible data structure
-	data := CsvToToken(filename)
+	api.Setup(*addr)
 
-	// Store user output filename in outputFile variable
-	outputFile, err := dialog.File().Filter("CSV file", "csv").Title("Save the output file to CSV with your desired name.").Save()
-	if err != nil {
-		fmt.Println("Error saving file:", &err)
+	return exitOK
+}
+
+// Performs proof of balance for every wallet in the input CSV at the cut-off and writes the combined report.
+// Wallets that fail are reported on stderr and the command exits non-zero once the others are written.
+func Prove(args []string) int {
+	flags := flag.NewFlagSet("prove", flag.ContinueOnError)
+	input := flags.String("input", "", "CSV of wallets: address in column A, chain in column B (required)")
+	at := flags.String("at", "", `cut-off as an RFC 3339 timestamp, e.g. "2024-03-31T23:59:59Z" (required)`)
+	out := flags.String("out", "retrieved-data.csv", "file to write the report to: CSV, or an XLSX workpaper when it ends in .xlsx")
+	withPrices := flags.Bool("prices", true, "value each token in USD")
+	spamChecks := flags.Bool("spam-checks", false, "run the network-backed spam checks")
+	decompose := flags.Bool("decompose", false, "decompose DeFi receipt tokens into underlying assets")
+	liabilities := flags.Bool("liabilities", false, "report lending protocol debt")
+	staking := flags.Bool("staking", false, "report native staking and delegations")
+	shares := flags.Bool("shares", false, "report shares of rebasing tokens")
+	quorum := flags.Bool("consensus", false, "check each balance against the configured providers")
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if *input == "" || *at == "" {
+		fmt.Fprintln(os.Stderr, "prove: --input and --at are required")
+		flags.Usage()
+		return exitUsage
 	}
 
-	// Create output CSV file
-	output, err := os.Create(outputFile + ".csv")
+	cutOff, err := time.Parse(time.RFC3339, *at)
 	if err != nil {
-		log.Fatalf("Error creating csv file: %v", err)
+		fmt.Fprintf(os.Stderr, "prove: invalid --at: %v\n", err)
+		return exitUsage
 	}
 
-	defer output.Close()
+	cutOff = cutOff.UTC()
 
-	writer := csv.NewWriter(output)
-	defer writer.Flush()
-
-	// Create and write headers to output CSV file
-	headers := []string{"Address", "Chain", "Token Name", "Token Symbol", "Token Address", "Balance", "Block number", "Token checker", "Usd rate", "Usd value"}
-	err = writer.Write(headers)
+	wallets, err := CsvToToken(*input)
 	if err != nil {
-		log.Fatalf("Error writing CSV headers: %v", err)
-	}
-
-	// Range over and access the data structure from "data" variable and assigned to "value" variable.
-	for _, value := range data {
-		// Used to store asset symbol for native tokens
-		var asset string
-		// Used to store balance checker url to print into the CSV
-		var nativeTokenChecker string
-		// Used to store native token name to print into the CSV
-		var tokenName string
-		// Used to access prices module to retrieve prices and calculate Usd value.
-		var price prices.Price
-		// Used to calculate USD value based on "price" variable
-		var Uvalue float64
-
-		// Get block number per chain per specified timestamp
-		// block := GetBlock(value.Chain, Timestamp("31/12/2022 23:59:59 UTC"))
-		blockNo := block.BlockNumber(value.Chain, block.TimestampToUnix("31/12/2022 23:59:59"))
-		// Retrieve balance for native token per specified chain
-		nativeResponse := getBalance(value.Address, value.Chain, strconv.Itoa(blockNo))
-
-		// Parse the response for native token to an accessible native token data strucuture
-		native := ResponseToNative(nativeResponse)
-
-		// Logic to print native asset and balance checker url to the output CSV file.
-		if value.Chain == Ethereum {
-			asset = ETH
-			nativeTokenChecker = EthereumNativeChecker
-			tokenName = "Ethereum"
-		} else if value.Chain == Arbitrum {
-			asset = ARB
-			nativeTokenChecker = ArbitrumNativeChecker
-			tokenName = "Ethereum"
-		} else if value.Chain == BinanceSmartChain {
-			asset = BSC
-			nativeTokenChecker = BSCNativeChecker
-			tokenName = "Binance Coin"
-		} else if value.Chain == Fantom {
-			asset = FTM
-			nativeTokenChecker = FantomNativeChecker
-			tokenName = "Fantom"
-		} else if value.Chain == Polygon {
-			asset = MATIC
-			nativeTokenChecker = PolygonNativeChecker
-			tokenName = "Polygon (MATIC)"
-		} else if value.Chain == Avalanche {
-			asset = AVAX
-			nativeTokenChecker = AvalancheNativeChecker
-			tokenName = "Avalanche"
-		} else if value.Chain == Cronos {
-			asset = CRO
-			nativeTokenChecker = CronosNativeChecker
-			tokenName = "Cronos"
+		fmt.Fprintf(os.Stderr, "prove: %v\n", err)
+		return exitUsage
+	}
+
+	proved := make([]*runs.Run, len(wallets))
+	failures := make([]*faults.Error, len(wallets))
+
+	var mu sync.Mutex
+
+	// wallets are proved concurrently; the report keeps them in the order of the input file
+	scheduler.Each(len(wallets), initialisers.WALLETWORKERS, func(i int) {
+		wallet := wallets[i]
+
+		request := models.Request{
+			Address:     wallet.Address,
+			Chain:       wallet.Chain,
+			Date:        cutOff.Format("02/01/2006"),
+			Timestamp:   cutOff.Format("15:04:05"),
+			Prices:      flagValue(*withPrices, "true"),
+			SpamChecks:  flagValue(*spamChecks, "full"),
+			Decompose:   flagValue(*decompose, "true"),
+			Liabilities: flagValue(*liabilities, "true"),
+			Staking:     flagValue(*staking, "true"),
+			Shares:      flagValue(*shares, "true"),
+			Consensus:   flagValue(*quorum, "true"),
 		}
 
-		// fmt.Printf("chain: %v, Asset: %v, Balance: %v, address: %v, block: %v\n", value.Chain, asset, native.Balance, value.Address, block.Block)
-		// Convert native token balance from string to number
-		nativeString, err := strconv.ParseFloat(native.Balance, 64)
+		run, err := api.Prove(context.Background(), "", request, nil)
+
+		mu.Lock()
+		defer mu.Unlock()
+
 		if err != nil {
-			fmt.Printf(`Error for address %v, when parsing asset %v, with a balance of "%v" on %v chain\n Error message below:\n.`, value.Address, asset, native.Balance, value.Chain)
-			log.Fatalf("Error parsing native balance token: %v", err)
+			failures[i] = faults.Wrap(faults.Internal, "", err).For(wallet.Address, wallet.Chain)
+			fmt.Fprintf(os.Stderr, "prove: %v on %v: %v\n", wallet.Address, wallet.Chain, failures[i])
+			return
 		}
 
-		// Convert native token balance from wei to ether
-		nativeToken := nativeString / math.Pow10(18)
+		proved[i] = run
 
-		// Store values and write values for native token data
-		nativeRecord := []string{value.Address, value.Chain, tokenName, asset, " ", fmt.Sprintf("%f", nativeToken), strconv.Itoa(blockNo), nativeTokenChecker, "", ""}
-		err = writer.Write(nativeRecord)
-		if err != nil {
-			log.Fatalf("Error writing to csv file: %v.", err)
+		fmt.Fprintf(os.Stderr, "%v on %v: %v rows at block %v (run %v)\n", wallet.Address, wallet.Chain, len(run.Rows), run.Block.Block, run.ID)
+	})
+
+	var completed []*runs.Run
+	failed := 0
+
+	for _, run := range proved {
+		if run == nil {
+			failed++
+			continue
 		}
 
-		// Retrieve balance for ERC20 tokens per specified chain and timestamp
-		tokenResponse := getTokenBalance(value.Address, value.Chain, strconv.Itoa(block.Block), "")
+		completed = append(completed, run)
+	}
 
-		// Parse the response for ERC20 token to an accessible ERC20 token data strucuture
-		tokenData := ResponseToToken(tokenResponse)
+	// the CSV reports failed wallets in its error columns; the workpaper only covers completed runs
+	if strings.HasSuffix(strings.ToLower(*out), ".xlsx") {
+		if len(completed) > 0 {
+			err = writeWorkpaper(*out, completed)
+		}
+	} else {
+		err = writeCSV(*out, proved, failures)
+	}
 
-		// Range over and access the data structure from "tokenData" variable and store in "token" variable
-		for _, token := range tokenData {
-			var erc20TokenChecker string
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "prove: error writing %v: %v\n", *out, err)
+		return exitFailure
+	}
 
-			// Convert ERC20 token balance from string to number
-			tokenString, err := strconv.ParseFloat(token.Balance, 64)
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "prove: %v of %v wallets failed\n", failed, len(wallets))
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+// Writes the runs' rows as one CSV, with the run each row came from. Wallets that failed are written as a row
+// carrying the error, in the same position as the wallet in the input.
+func writeCSV(path string, proved []*runs.Run, failures []*faults.Error) error {
+	output, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	defer output.Close()
+
+	writer := csv.NewWriter(output)
+
+	err = writer.Write(append(append([]string{}, report.Headers...), "Run ID"))
+	if err != nil {
+		return err
+	}
+
+	for i, run := range proved {
+		if run == nil {
+			err = writer.Write(append(report.FailureRow(failures[i]), ""))
 			if err != nil {
-				log.Fatalf("Error parsing token balances: %v", err)
+				return err
 			}
 
-			// Convert ERC20 token balance from specified decimals to no decimals
-			tokenBalance := tokenString / math.Pow10(token.Decimals)
-
-			// Retrieve price for ERC20 token
-			erc20Price := price.GetPrice(token.TokenAdress, value.Chain, block.Block)
-
-			// Calculate USD value
-			Uvalue = erc20Price * tokenBalance
-
-			// Logic for printing ERC20 token balance checker tool to output CSV
-			if value.Chain == Ethereum {
-				erc20TokenChecker = EthereumTokenChecker
-			} else if value.Chain == Polygon {
-				erc20TokenChecker = PolygonTokenChecker
-			} else if value.Chain == Arbitrum {
-				erc20TokenChecker = ArbitrumTokenChecker
-			} else if value.Chain == BinanceSmartChain {
-				erc20TokenChecker = BSCTokenChecker
-			} else if value.Chain == Fantom {
-				erc20TokenChecker = FantomTokenChecker
-			} else if value.Chain == Cronos {
-				erc20TokenChecker = CronosTokenChecker
-			} else if value.Chain == Avalanche {
-				erc20TokenChecker = AvalancheTokenChecker
-			}
+			continue
+		}
 
-			// Store and write values for ERC20 token data
-			tokenRecord := []string{value.Address, value.Chain, token.Name, token.Symbol, token.TokenAdress, fmt.Sprintf("%f", tokenBalance), strconv.Itoa(blockNo), erc20TokenChecker, strconv.FormatFloat(erc20Price, 'f', 6, 64), strconv.FormatFloat(Uvalue, 'f', 6, 64)}
-			err = writer.Write(tokenRecord)
+		for _, row := range report.Rows(run) {
+			err = writer.Write(append(row, run.ID))
 			if err != nil {
-				log.Fatalf("Error: %v.", err)
+				return err
 			}
-
 		}
 	}
+
+	writer.Flush()
+
+	return writer.Error()
 }
 
-// Used to get one ERC20 token balance for an address for specified chain.
-func getTokenBalance(address string, chain string, block string, tokens string) []byte {
-	resp, err := getRequest(ConstructTokenURL(address, chain, block, tokens))
+// Writes the runs as an XLSX workpaper.
+func writeWorkpaper(path string, completed []*runs.Run) error {
+	workpaper, err := report.Workpaper(completed)
 	if err != nil {
-		log.Fatalf("Error performing request to get balance from Moralis API: %v.", err)
+		return err
 	}
 
-	defer resp.Body.Close()
+	return os.WriteFile(path, workpaper, 0644)
+}
+
+// Resolves the blocks at one or more times on one or more chains and prints them as JSON.
+// Exits non-zero if any time could not be resolved.
+func Block(args []string) int {
+	flags := flag.NewFlagSet("block", flag.ContinueOnError)
+	chain := flags.String("chain", "", "comma separated chains, e.g. eth,polygon (required)")
+	at := flags.String("at", "", "comma separated RFC 3339 timestamps (required)")
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	if *chain == "" || *at == "" {
+		fmt.Fprintln(os.Stderr, "block: --chain and --at are required")
+		flags.Usage()
+		return exitUsage
+	}
+
+	chains := strings.Split(*chain, ",")
+	for i := range chains {
+		chains[i] = strings.TrimSpace(chains[i])
+
+		if _, err := models.DetermineChain(chains[i]); err != nil {
+			fmt.Fprintf(os.Stderr, "block: %v: %v\n", chains[i], err)
+			return exitUsage
+		}
+	}
+
+	times, err := blocks.ParseTimes(*at)
 	if err != nil {
-		log.Fatalf("Error parsing response body to get token balance from Moralis API: %v.", err)
+		fmt.Fprintf(os.Stderr, "block: %v\n", err)
+		return exitUsage
+	}
+
+	resolutions := block.Resolve(context.Background(), chains, times)
 
+	if code := printJSON(resolutions); code != exitOK {
+		return code
 	}
 
-	return body
+	for _, resolution := range resolutions {
+		if resolution.Error != nil {
+			return exitFailure
+		}
+	}
+
+	return exitOK
 }
 
-// Used to getBalance from an address on specified blockchain
-func getBalance(address string, chain string, block string) []byte {
-	resp, err := getRequest(ConstructNativeURL(address, chain, block))
-	if err != nil {
-		log.Fatalf("Error performing request to get balance from Moralis API: %v.", err)
+// Renders a signed PDF balance confirmation from stored runs: one run for a wallet, or every run of an engagement.
+func Confirm(args []string) int {
+	flags := flag.NewFlagSet("confirm", flag.ContinueOnError)
+	ids := flags.String("runs", "", "comma separated ids of the stored runs to confirm (required)")
+	client := flags.String("client", "", "client named in the confirmation (required)")
+	templateFile := flags.String("template", initialisers.CONFIRMATIONTEMPLATEFILE, "JSON file with the firm's wording")
+	out := flags.String("out", "confirmation.pdf", "PDF file to write the confirmation to")
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if *ids == "" || *client == "" {
+		fmt.Fprintln(os.Stderr, "confirm: --runs and --client are required")
+		flags.Usage()
+		return exitUsage
 	}
 
-	defer resp.Body.Close()
+	var stored []*runs.Run
 
-	body, err := ioutil.ReadAll(resp.Body)
+	for _, id := range strings.Split(*ids, ",") {
+		run, err := runs.Load(strings.TrimSpace(id))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "confirm: %v\n", err)
+			return exitFailure
+		}
+
+		stored = append(stored, run)
+	}
+
+	wording, err := confirmation.LoadTemplate(*templateFile)
 	if err != nil {
-		log.Fatalf("Error parsing response body for getBalance from Moralis API: %v.", err)
+		fmt.Fprintf(os.Stderr, "confirm: %v\n", err)
+		return exitFailure
 	}
 
-	return body
-}
+	data, err := confirmation.New(stored, *client, wording)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "confirm: %v\n", err)
+		return exitFailure
+	}
 
-// Helper function to perform HTTP GET requests
-func getRequest(url string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	key, err := evidence.SigningKey()
 	if err != nil {
-		log.Fatalf("Error creating HTTP GET request: %v.", err)
+		fmt.Fprintf(os.Stderr, "confirm: %v\n", err)
+		return exitFailure
 	}
 
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("X-API-Key", initialisers.APIKEY)
+	document, err := confirmation.Render(data, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "confirm: %v\n", err)
+		return exitFailure
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	err = os.WriteFile(*out, document, 0644)
 	if err != nil {
-		log.Fatalf("Error creating HTTP GET request: %v.", err)
+		fmt.Fprintf(os.Stderr, "confirm: error writing %v: %v\n", *out, err)
+		return exitFailure
 	}
 
-	return resp, nil
+	return exitOK
 }
 
-// Used to create the URL to query the balance of an address for the native chain token.
-func ConstructNativeURL(address string, chain string, block string) string {
-	url := fmt.Sprintf("https://deep-index.moralis.io/api/v2/%v/balance?chain=%v&to_block=%v", address, chain, block)
+// Checks the integrity and signature of evidence packs and PDF confirmations given as arguments.
+// Exits non-zero if any file fails.
+func VerifyEvidence(paths []string) int {
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: pob verify <evidence-pack.zip|confirmation.pdf>...")
+		return exitUsage
+	}
 
-	return url
-}
+	trusted, err := evidence.TrustedKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify: error reading EVIDENCE_PUBLIC_KEY: %v\n", err)
+		return exitFailure
+	}
+
+	failed := false
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Printf("%v: FAILED (%v)\n", path, err)
+			failed = true
+			continue
+		}
+
+		var summary, signer string
+
+		if strings.HasSuffix(strings.ToLower(path), ".pdf") {
+			var confirmed *confirmation.Data
+
+			confirmed, signer, err = confirmation.Verify(data, trusted)
+			if err == nil {
+				summary = fmt.Sprintf("confirmation for %v as at %v, %v runs", confirmed.Client, confirmed.PeriodEnd, len(confirmed.Runs))
+			}
+		} else {
+			var manifest *evidence.Manifest
+
+			manifest, err = evidence.Verify(data, trusted)
+			if err == nil {
+				summary, signer = fmt.Sprintf("run %v, %v files", manifest.RunID, len(manifest.Files)), manifest.PublicKey
+			}
+		}
+
+		if err != nil {
+			fmt.Printf("%v: FAILED (%v)\n", path, err)
+			failed = true
+			continue
+		}
+
+		fmt.Printf("%v: OK (%v)\n", path, summary)
+		if trusted == nil {
+			fmt.Printf("  signed by %v. No EVIDENCE_PUBLIC_KEY configured: check this is the firm's key.\n", signer)
+		}
+	}
+
+	if failed {
+		return exitFailure
+	}
 
-// Used to create the URL to query the balance of an address for ERC20 tokens.
-func ConstructTokenURL(address string, chain string, block string, tokens string) string {
-	url := fmt.Sprintf("https://deep-index.moralis.io/api/v2/%v/erc20?chain=%v&to_block=%v&token_adresses%%5B0%%5D=%v", address, chain, block, tokens)
-	// fmt.Println(url)
-	return url
+	return exitOK
 }
 
-// Used to parse token balance response data to readable data to use in the programme.
-func ResponseToToken(body []byte) []TokenBalance {
-	var response []TokenBalance
+// Re-executes a stored run (by id) or the run in an evidence pack (.zip) at the same block and prints the differences.
+// Exits non-zero when the re-performance does not match.
+func ReperformRun(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: pob reperform <run-id|evidence-pack.zip>")
+		return exitUsage
+	}
+
+	original, err := loadRun(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reperform: %v\n", err)
+		return exitFailure
+	}
 
-	err := json.Unmarshal(body, &response)
+	current, err := api.Reperform(context.Background(), original.Tenant, original)
 	if err != nil {
-		log.Fatalf("Error parsing token balance response data: %v.", err)
+		fmt.Fprintf(os.Stderr, "reperform: error re-performing run %v: %v\n", original.ID, err)
+		return exitFailure
+	}
+
+	diff := reperform.Compare(original, current)
+
+	if code := printJSON(diff); code != exitOK {
+		return code
+	}
+
+	if !diff.Matches {
+		return exitFailure
 	}
 
-	return response
+	return exitOK
 }
 
-// Used to parse native token balance response data to readable format to use in the programme.
-func ResponseToNative(body []byte) NativeBalance {
-	var response NativeBalance
+// Loads a stored run by id, or the run in an evidence pack once its integrity and signature are verified.
+func loadRun(arg string) (*runs.Run, error) {
+	if !strings.HasSuffix(arg, ".zip") {
+		return runs.Load(arg)
+	}
+
+	data, err := os.ReadFile(arg)
+	if err != nil {
+		return nil, err
+	}
 
-	err := json.Unmarshal(body, &response)
+	trusted, err := evidence.TrustedKey()
 	if err != nil {
-		log.Fatalf("Error unmarshalling JSON for native balance: %v.", err)
+		return nil, fmt.Errorf("error reading EVIDENCE_PUBLIC_KEY: %v", err)
 	}
 
-	return response
+	return evidence.Open(data, trusted)
 }
 
-// Parses the data in the input CSV file to an accessbile variable by the script.
-func CsvToToken(filename string) []TokenFile {
+// Parses the input CSV. A header row starting with "Address" is skipped.
+func CsvToToken(filename string) ([]TokenFile, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatalf("Error opening the file %v: %v.", filename, err)
+		return nil, fmt.Errorf("error opening the file %v: %v", filename, err)
 	}
 
 	defer file.Close()
 
 	reader := csv.NewReader(file)
-
-	records, err := reader.ReadAll()
-	if err != nil {
-		log.Fatalf("Error readint the file: %v.", err)
-	}
+	reader.FieldsPerRecord = -1
 
 	var data []TokenFile
-	var jData TokenFile
 
-	for _, record := range records {
-		jData.Address = record[0]
-		jData.Chain = record[1]
-		data = append(data, jData)
-	}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading the file %v: %v", filename, err)
+		}
 
-	return data
-}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "address") {
+			continue
+		}
 
-// Perform GET request to Moralis API in order to retrieve the block number at specifed timestamp.
-func GetBlock(chain string, timestamp string) Block {
-	url := fmt.Sprintf("https://deep-index.moralis.io/api/v2/dateToBlock?chain=%v&date=%v", chain, timestamp)
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %v of %v: expected an address and a chain", line, filename)
+		}
 
-	resp, err := getRequest(url)
-	if err != nil {
-		log.Fatalf("Error performing GET request to get the block number: %v.", err)
+		data = append(data, TokenFile{Address: strings.TrimSpace(record[0]), Chain: strings.TrimSpace(record[1])})
 	}
 
-	defer resp.Body.Close()
+	return data, nil
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalf("Error parsing block response body: %v.", err)
+// Returns the request value for an enabled flag, or empty when disabled.
+func flagValue(enabled bool, value string) string {
+	if enabled {
+		return value
 	}
 
-	var block Block
+	return ""
+}
 
-	err = json.Unmarshal(body, &block)
-	if err != nil {
-		log.Fatalf("Error parsing block JSON response: %v.", err)
+func printJSON(v interface{}) int {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(v); err != nil {
+		log.Printf("error encoding output: %v", err)
+		return exitFailure
 	}
 
-	return block
+	return exitOK
 }
 
-// Used to return the specified timestamp in unix format for use with the Moralis API.
-func Timestamp(date string) string {
-	utc, err := time.Parse("02/01/2006 15:04:05 UTC", date)
+// Issues an API key to a tenant and prints it. The keys file only stores the key's hash, so the key is shown once.
+func IssueAPIKey(args []string) int {
+	flags := flag.NewFlagSet("apikey", flag.ContinueOnError)
+	name := flags.String("name", "", "unique name of the key, used in logs and usage")
+	tenant := flags.String("tenant", "", "tenant the key's runs are stored under")
+	quota := flags.Int("quota", 0, "provider-backed requests allowed per UTC day, 0 for unlimited")
+	admin := flags.Bool("admin", false, "allow the key to change firm-wide settings such as price overrides")
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if *name == "" || *tenant == "" {
+		fmt.Fprintln(os.Stderr, "apikey: --name and --tenant are required")
+		flags.Usage()
+		return exitUsage
+	}
+
+	key, err := auth.Issue(*name, *tenant, *quota, *admin)
 	if err != nil {
-		log.Fatalf("Error parsing UTC timestamp: %v.", err)
+		fmt.Fprintf(os.Stderr, "apikey: %v\n", err)
+		return exitFailure
 	}
 
-	unixTime := utc.Unix()
+	fmt.Println(key)
 
-	return strconv.Itoa(int(unixTime))
+	return exitOK
 }
@@ -1,36 +1,93 @@
 package api
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"math"
-	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/harrisandtrotter/proof-of-balance/backend/blocks"
-	"github.com/harrisandtrotter/proof-of-balance/backend/initialisers"
-	"github.com/harrisandtrotter/proof-of-balance/backend/models"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/harrisandtrotter/proof-of-balance/server/auth"
+	"github.com/harrisandtrotter/proof-of-balance/server/beacon"
+	"github.com/harrisandtrotter/proof-of-balance/server/blocks"
+	"github.com/harrisandtrotter/proof-of-balance/server/completeness"
+	"github.com/harrisandtrotter/proof-of-balance/server/confirmation"
+	"github.com/harrisandtrotter/proof-of-balance/server/evidence"
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/moralis"
+	"github.com/harrisandtrotter/proof-of-balance/server/nfts"
+	"github.com/harrisandtrotter/proof-of-balance/server/overrides"
+	"github.com/harrisandtrotter/proof-of-balance/server/progress"
+	"github.com/harrisandtrotter/proof-of-balance/server/reperform"
+	"github.com/harrisandtrotter/proof-of-balance/server/report"
+	"github.com/harrisandtrotter/proof-of-balance/server/runs"
+	"github.com/harrisandtrotter/proof-of-balance/server/scheduler"
+	"github.com/harrisandtrotter/proof-of-balance/server/vesting"
 )
 
 var block blocks.Block
 
-func Setup() {
+// Registers the routes and serves the API on the address.
+func Setup(addr string) {
+	if !initialisers.AUTHDISABLED {
+		keys, err := auth.Keys()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if len(keys) == 0 {
+			log.Printf("no API keys in %v: every request will be refused until one is issued with \"pob apikey\"", initialisers.AUTHKEYSFILE)
+		}
+	}
+
 	router := fiber.New()
 
+	// a panic in one handler is returned as a 500 rather than stopping the server
+	router.Use(recover.New())
+
+	// credentials are only sent cross-origin to named origins, never to "*"
 	router.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowMethods: "GET,POST,PUT,DELETE",
+		AllowOrigins:     initialisers.CORSORIGINS,
+		AllowMethods:     "GET,POST,PUT,DELETE",
+		AllowHeaders:     "Content-Type,X-API-Key,Authorization",
+		ExposeHeaders:    "X-Run-ID,X-Quota-Remaining",
+		AllowCredentials: initialisers.CORSORIGINS != "*",
 	}))
 
-	router.Post("/balances", GetBalance)
-
-	log.Fatal(router.Listen(":8000"))
+	router.Post("/session", SignIn)
+	router.Delete("/session", SignOut)
+
+	router.Use(authenticate)
+
+	// provider-backed routes are charged to the caller's daily quota; the stream and /blocks charge per wallet and block
+	router.Post("/balances", jsonOnly, quota(1), GetBalance)
+	router.Get("/balances/stream", StreamBalances)
+	router.Post("/nfts", jsonOnly, quota(1), GetNFTs)
+	router.Get("/blocks", GetBlocks)
+	router.Post("/validators", jsonOnly, quota(1), GetValidators)
+	router.Post("/vesting", jsonOnly, quota(1), GetVesting)
+	router.Post("/completeness", jsonOnly, CheckCompleteness)
+	router.Post("/overrides", jsonOnly, adminOnly, SetOverride)
+	router.Get("/overrides", GetOverrides)
+	router.Get("/runs/:id", GetRun)
+	router.Get("/runs/:id/evidence", GetEvidence)
+	router.Get("/workpaper", GetWorkpaper)
+	router.Get("/confirmation", GetConfirmation)
+	router.Post("/runs/:id/reperform", quota(1), ReperformRun)
+	router.Post("/reperform", quota(1), ReperformEvidence)
+
+	log.Fatal(router.Listen(addr))
 }
 
 func GetBalance(c *fiber.Ctx) error {
@@ -40,12 +97,180 @@ func GetBalance(c *fiber.Ctx) error {
 
 	// parse request body into request struct
 	if err := c.BodyParser(&body); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "error with request. Please contact support (devops@harrisandtrotter.co.uk)",
-		})
+		return sendError(c, faults.Newf(faults.InvalidRequest, faults.StepRequest, "error with request. Please contact support (devops@harrisandtrotter.co.uk)"))
 	}
 
 	// assign request body values to request variable
+	request := balanceRequest(func(key string) string { return body[key] })
+
+	run, err := Prove(c.UserContext(), tenant(c), request, nil)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.Internal, "", err))
+	}
+
+	// the run id locates the stored run and its evidence pack
+	c.Set("X-Run-ID", run.ID)
+
+	return c.JSON(run.Rows)
+
+}
+
+// Streams a proof of balance as Server-Sent Events. "address" and "chain" take comma separated lists and every
+// address is proved on every chain, several wallets at a time; the other query parameters are those of /balances.
+// Each wallet sends started, block, balances, a row event per row and then completed or error. A final done event
+// closes the stream.
+func StreamBalances(c *fiber.Ctx) error {
+	var wallets []models.Request
+
+	for _, address := range strings.Split(c.Query("address"), ",") {
+		for _, chain := range strings.Split(c.Query("chain"), ",") {
+			request := balanceRequest(func(key string) string { return c.Query(key) })
+			request.Address = strings.TrimSpace(address)
+			request.Chain = strings.TrimSpace(chain)
+
+			if request.Address == "" || request.Chain == "" {
+				continue
+			}
+
+			if _, err := models.DetermineChain(request.Chain); err != nil {
+				return sendError(c, faults.Wrap(faults.InvalidChain, faults.StepRequest, err))
+			}
+
+			wallets = append(wallets, request)
+		}
+	}
+
+	if len(wallets) == 0 {
+		return sendError(c, faults.Newf(faults.InvalidRequest, faults.StepRequest, "address and chain are required"))
+	}
+
+	if err := spend(c, len(wallets)); err != nil {
+		return sendError(c, err)
+	}
+
+	owner, ctx := tenant(c), c.UserContext()
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// a failed write means the client has gone, so the remaining wallets are not started
+		closed := false
+
+		// wallets run concurrently, so their events are written one at a time
+		var mu sync.Mutex
+
+		isClosed := func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+
+			return closed
+		}
+
+		send := func(event progress.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if closed {
+				return
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("error encoding progress event: %v", err)
+				return
+			}
+
+			fmt.Fprintf(w, "event: %v\ndata: %s\n\n", event.Type, data)
+
+			if err := w.Flush(); err != nil {
+				closed = true
+			}
+		}
+
+		scheduler.Each(len(wallets), initialisers.WALLETWORKERS, func(i int) {
+			request := wallets[i]
+
+			if isClosed() {
+				return
+			}
+
+			wallet := progress.Event{Wallet: i + 1, Wallets: len(wallets), Address: request.Address, Chain: request.Chain}
+
+			started := wallet
+			started.Type = progress.Started
+			send(started)
+
+			run, err := Prove(ctx, owner, request, func(event progress.Event) {
+				event.Wallet, event.Wallets = wallet.Wallet, wallet.Wallets
+				send(event)
+			})
+
+			finished := wallet
+			if err != nil {
+				finished.Type = progress.Failed
+				finished.Error = faults.Wrap(faults.Internal, "", err)
+				faults.Log(finished.Error)
+			} else {
+				finished.Type = progress.Completed
+				finished.RunID = run.ID
+				finished.Rows = len(run.Rows)
+			}
+
+			send(finished)
+		})
+
+		send(progress.Event{Type: progress.Done, Wallets: len(wallets)})
+	})
+
+	return nil
+}
+
+// Resolves the blocks at one or more comma separated RFC 3339 times (at) on one or more comma separated chains.
+func GetBlocks(c *fiber.Ctx) error {
+	var chains []string
+
+	for _, chain := range strings.Split(c.Query("chain"), ",") {
+		chain = strings.TrimSpace(chain)
+		if chain == "" {
+			continue
+		}
+
+		if _, err := models.DetermineChain(chain); err != nil {
+			return sendError(c, faults.Wrap(faults.InvalidChain, faults.StepRequest, err))
+		}
+
+		chains = append(chains, chain)
+	}
+
+	if len(chains) == 0 {
+		return sendError(c, faults.Newf(faults.InvalidChain, faults.StepRequest, "no chain given"))
+	}
+
+	times, err := blocks.ParseTimes(c.Query("at"))
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidDate, faults.StepRequest, err))
+	}
+
+	if err := spend(c, len(chains)*len(times)); err != nil {
+		return sendError(c, err)
+	}
+
+	return c.JSON(block.Resolve(c.UserContext(), chains, times))
+}
+
+// Returns NFT holdings (ERC721 and ERC1155) at the block, separately from fungible balances.
+func GetNFTs(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+
+	var body map[string]string
+
+	if err := c.BodyParser(&body); err != nil {
+		return sendError(c, faults.Newf(faults.InvalidRequest, faults.StepRequest, "error with request. Please contact support (devops@harrisandtrotter.co.uk)"))
+	}
+
 	request := models.Request{
 		Address:   body["address"],
 		Chain:     body["chain"],
@@ -53,159 +278,506 @@ func GetBalance(c *fiber.Ctx) error {
 		Timestamp: body["timestamp"],
 	}
 
-	// chain for moralis API
 	chain, err := models.DetermineChain(request.Chain)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error determining chain": err.Error(),
-		})
+		return sendError(c, faults.Wrap(faults.InvalidChain, faults.StepRequest, err))
 	}
 
 	formatDate, err := formatDate(request.Date)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": err,
-		})
+		return sendError(c, faults.Wrap(faults.InvalidDate, faults.StepRequest, err))
 	}
-	// block number based on chain and timestamp
-	blockNo := block.BlockNumber(chain, formatDate+" "+request.Timestamp)
 
-	// relevant info to be returned to user
-	asset, url, name, tokenUrl, err := models.ReturnInfo(chain)
+	blockNo, err := block.BlockNumber(c.UserContext(), chain, formatDate+" "+request.Timestamp)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error with native information": err.Error(),
-		})
+		return sendError(c, faults.Wrap(faults.ProviderFailed, faults.StepBlock, err))
 	}
 
-	// get native balance
-	nativeBalanceResp, err := getNativeBalance(request.Address, chain, blockNo)
+	holdings, err := nfts.Holdings(c.UserContext(), request.Address, chain, blockNo)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error with json": err.Error(),
-		})
+		return sendError(c, faults.Wrap(faults.ProviderFailed, faults.StepNFTs, err))
+	}
+
+	return c.JSON(holdings)
+}
+
+// Returns consensus layer validator balances at the slot matching the cut-off for validators withdrawing to the address.
+// Address may hold several comma separated addresses.
+func GetValidators(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+
+	var body map[string]string
+
+	if err := c.BodyParser(&body); err != nil {
+		return sendError(c, faults.Newf(faults.InvalidRequest, faults.StepRequest, "error with request. Please contact support (devops@harrisandtrotter.co.uk)"))
+	}
+
+	request := models.Request{
+		Address:    body["address"],
+		Date:       body["date"],
+		Timestamp:  body["timestamp"],
+		Validators: body["validators"],
 	}
 
-	// convert type string to float64
-	balanceStr, err := strconv.ParseFloat(nativeBalanceResp.Balance, 64)
+	if strings.TrimSpace(request.Validators) == "" {
+		return sendError(c, faults.Newf(faults.InvalidRequest, faults.StepRequest, "validators must list the validator indices or pubkeys to read"))
+	}
+
+	formatDate, err := formatDate(request.Date)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error with native type conversion": err.Error(),
-		})
+		return sendError(c, faults.Wrap(faults.InvalidDate, faults.StepRequest, err))
 	}
 
-	// convert from wei to ether
-	balance := balanceStr / math.Pow10(18)
+	cutOff, err := time.Parse("2006-01-02 15:04:05", formatDate+" "+request.Timestamp)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidDate, faults.StepRequest, err))
+	}
 
-	tokenBalanceResp, err := getTokenBalance(request.Address, chain, blockNo)
+	slot, err := beacon.SlotAt(c.UserContext(), cutOff)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error with erc20 token balances": err.Error(),
-		})
+		return sendError(c, faults.Wrap(faults.ProviderFailed, faults.StepValidators, err))
+	}
+
+	ids := strings.Split(strings.ReplaceAll(request.Validators, " ", ""), ",")
+
+	validators, err := beacon.Validators(c.UserContext(), strings.Split(strings.ReplaceAll(request.Address, " ", ""), ","), ids, slot)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.ProviderFailed, faults.StepValidators, err))
+	}
+
+	return c.JSON(validators)
+}
+
+// Returns vesting schedules and token locks with the address as beneficiary, split into vested, unvested and claimable
+// amounts at the cut-off. Locked tokens are held by the vesting contract so they never appear in the wallet's balances.
+func GetVesting(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+
+	var body map[string]string
+
+	if err := c.BodyParser(&body); err != nil {
+		return sendError(c, faults.Newf(faults.InvalidRequest, faults.StepRequest, "error with request. Please contact support (devops@harrisandtrotter.co.uk)"))
+	}
+
+	request := models.Request{
+		Address:          body["address"],
+		Chain:            body["chain"],
+		Date:             body["date"],
+		Timestamp:        body["timestamp"],
+		VestingContracts: body["vesting_contracts"],
+	}
+
+	chain, err := models.DetermineChain(request.Chain)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidChain, faults.StepRequest, err))
+	}
+
+	formatDate, err := formatDate(request.Date)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidDate, faults.StepRequest, err))
+	}
+
+	cutOff, err := time.Parse("2006-01-02 15:04:05", formatDate+" "+request.Timestamp)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidDate, faults.StepRequest, err))
+	}
+
+	blockNo, err := block.BlockNumber(c.UserContext(), chain, formatDate+" "+request.Timestamp)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.ProviderFailed, faults.StepBlock, err))
+	}
+
+	var contracts []string
+	if request.VestingContracts != "" {
+		contracts = strings.Split(strings.ReplaceAll(request.VestingContracts, " ", ""), ",")
+	}
+
+	schedules, err := vesting.Find(c.UserContext(), request.Address, chain, cutOff, blockNo, contracts)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.ProviderFailed, faults.StepVesting, err))
+	}
+
+	return c.JSON(schedules)
+}
+
+// Independently checks the token list Moralis returns by scanning the address's transfer logs from from_block up to
+// the cut-off block. The scan costs one unit of quota for each 50,000 blocks.
+func CheckCompleteness(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+
+	var body map[string]string
+
+	if err := c.BodyParser(&body); err != nil {
+		return sendError(c, faults.Newf(faults.InvalidRequest, faults.StepRequest, "error with request. Please contact support (devops@harrisandtrotter.co.uk)"))
+	}
+
+	request := models.Request{
+		Address:   body["address"],
+		Chain:     body["chain"],
+		Date:      body["date"],
+		Timestamp: body["timestamp"],
+		FromBlock: body["from_block"],
 	}
-	var response []models.ClientResponse
-
-	response = append(response, models.ClientResponse{
-		Address:      request.Address,
-		Chain:        chain,
-		BlockNumber:  blockNo,
-		Asset:        asset,
-		AssetName:    name,
-		AssetAddress: "N/A",
-		Balance:      balance,
-		CheckerUrl:   url,
-		PossibleSpam: false,
-	})
 
+	chain, err := models.DetermineChain(request.Chain)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidChain, faults.StepRequest, err))
+	}
+
+	formatDate, err := formatDate(request.Date)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidDate, faults.StepRequest, err))
+	}
+
+	// scanning from genesis would take hours on a busy chain, so the caller names where the wallet's history starts
+	if request.FromBlock == "" {
+		return sendError(c, faults.Newf(faults.InvalidRequest, faults.StepRequest, "from_block is required: the first block of the wallet's history, such as the block it was funded in"))
+	}
+
+	fromBlock, err := strconv.Atoi(request.FromBlock)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidRequest, faults.StepRequest, err))
+	}
+
+	blockNo, err := block.BlockNumber(c.UserContext(), chain, formatDate+" "+request.Timestamp)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.ProviderFailed, faults.StepBlock, err))
+	}
+
+	units, err := completeness.Cost(fromBlock, blockNo)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidRequest, faults.StepRequest, err))
+	}
+
+	if err := spend(c, units); err != nil {
+		return sendError(c, err)
+	}
+
+	ctx := c.UserContext()
+	if initialisers.COMPLETENESSTIMEOUT > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, initialisers.COMPLETENESSTIMEOUT)
+		defer cancel()
+	}
+
+	// token list from the primary provider
+	tokenBalanceResp, _, err := getTokenBalance(ctx, request.Address, chain, blockNo)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.ProviderFailed, faults.StepTokenBalances, err))
+	}
+
+	nftResp, err := nfts.List(ctx, request.Address, chain, blockNo)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.ProviderFailed, faults.StepNFTs, err))
+	}
+
+	var known []string
 	for _, value := range tokenBalanceResp {
+		known = append(known, value.TokenAddress)
+	}
+	for _, value := range nftResp {
+		known = append(known, value.TokenAddress)
+	}
 
-		tokenStr, err := strconv.ParseFloat(value.Balance, 64)
+	report, err := completeness.Check(ctx, request.Address, chain, fromBlock, blockNo, known)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.ProviderFailed, faults.StepTransfers, err))
+	}
+
+	return c.JSON(report)
+}
+
+// Records a manual price override. Date is taken in the same dd/mm/yyyy format as balance requests.
+func SetOverride(c *fiber.Ctx) error {
+	c.Accepts("application/json")
+
+	var request models.OverrideRequest
+
+	if err := c.BodyParser(&request); err != nil {
+		return sendError(c, faults.Newf(faults.InvalidRequest, faults.StepRequest, "error with request. Please contact support (devops@harrisandtrotter.co.uk)"))
+	}
+
+	formatDate, err := formatDate(request.Date)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidDate, faults.StepRequest, err))
+	}
+
+	price, err := strconv.ParseFloat(request.UsdPrice, 64)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidRequest, faults.StepRequest, err))
+	}
+
+	override, err := overrides.Set(overrides.Override{
+		TokenAddress:  request.TokenAddress,
+		Chain:         request.Chain,
+		Date:          formatDate,
+		UsdPrice:      price,
+		Justification: request.Justification,
+		Author:        request.Author,
+	})
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidRequest, "", err))
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(override)
+}
+
+// Returns the history of manual price overrides, optionally filtered by token_address, chain and date query parameters.
+func GetOverrides(c *fiber.Ctx) error {
+	var date string
+
+	if c.Query("date") != "" {
+		formatDate, err := formatDate(c.Query("date"))
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error with token type conversion": err.Error(),
-			})
+			return sendError(c, faults.Wrap(faults.InvalidDate, faults.StepRequest, err))
 		}
 
-		tokenBalance := tokenStr / math.Pow10(value.Decimals)
-
-		response = append(response, models.ClientResponse{
-			Address:      request.Address,
-			Chain:        chain,
-			BlockNumber:  blockNo,
-			Asset:        value.Symbol,
-			AssetName:    value.Name,
-			AssetAddress: value.TokenAddress,
-			Balance:      tokenBalance,
-			CheckerUrl:   tokenUrl,
-			PossibleSpam: value.PossibleSpam,
-		})
+		date = formatDate
+	}
+
+	history, err := overrides.History(c.Query("token_address"), c.Query("chain"), date)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.StorageFailed, "", err))
+	}
+
+	return c.JSON(history)
+}
+
+// Returns a stored run: the request, the resolved block and the rows returned.
+func GetRun(c *fiber.Ctx) error {
+	run, err := runs.LoadFor(tenant(c), c.Params("id"))
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.NotFound, "", err))
+	}
+
+	return c.JSON(run)
+}
 
+// Returns the signed zip evidence pack for a stored run.
+func GetEvidence(c *fiber.Ctx) error {
+	run, err := runs.LoadFor(tenant(c), c.Params("id"))
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.NotFound, "", err))
+	}
+
+	key, err := evidence.SigningKey()
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.SigningFailed, "", err))
+	}
+
+	pack, err := evidence.Pack(run, key)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.Internal, "", err))
 	}
 
-	return c.JSON(response)
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"evidence-%v.zip\"", run.ID))
 
+	return c.Send(pack)
 }
 
-// Get ERC20 token balance
-func getTokenBalance(address, chain string, block int) ([]models.TokenBalance, error) {
-	url := fmt.Sprintf("https://deep-index.moralis.io/api/v2/%v/erc20?chain=%v&to_block=%v", address, chain, block)
+// Returns the XLSX working paper for the stored runs given as a comma separated "runs" query parameter.
+func GetWorkpaper(c *fiber.Ctx) error {
+	if c.Query("runs") == "" {
+		return sendError(c, faults.Newf(faults.InvalidRequest, faults.StepRequest, "runs is required"))
+	}
 
-	resp, err := get(url)
+	stored, err := loadRuns(tenant(c), c.Query("runs"))
 	if err != nil {
-		return []models.TokenBalance{}, err
+		return sendError(c, faults.Wrap(faults.NotFound, "", err))
 	}
 
-	var response *[]models.TokenBalance
+	workpaper, err := report.Workpaper(stored)
+	if errors.Is(err, report.ErrMixedCutOffs) {
+		return sendError(c, faults.Wrap(faults.InvalidRequest, faults.StepRequest, err))
+	}
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.Internal, "", err))
+	}
+
+	c.Set(fiber.HeaderContentType, report.XLSXContentType)
+	c.Set(fiber.HeaderContentDisposition, "attachment; filename=\"workpaper.xlsx\"")
+
+	return c.Send(workpaper)
+}
+
+// Returns the signed PDF balance confirmation for the stored runs given as a comma separated "runs" query parameter:
+// one run for a wallet, or every run of an engagement. "client" names the client in the report.
+func GetConfirmation(c *fiber.Ctx) error {
+	if c.Query("runs") == "" || c.Query("client") == "" {
+		return sendError(c, faults.Newf(faults.InvalidRequest, faults.StepRequest, "runs and client are required"))
+	}
+
+	stored, err := loadRuns(tenant(c), c.Query("runs"))
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.NotFound, "", err))
+	}
 
-	err = json.Unmarshal(resp, &response)
+	wording, err := confirmation.LoadTemplate(initialisers.CONFIRMATIONTEMPLATEFILE)
 	if err != nil {
-		return []models.TokenBalance{}, err
+		return sendError(c, faults.Wrap(faults.Internal, "", err))
 	}
 
-	return *response, nil
+	data, err := confirmation.New(stored, c.Query("client"), wording)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidRequest, "", err))
+	}
+
+	key, err := evidence.SigningKey()
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.SigningFailed, "", err))
+	}
+
+	document, err := confirmation.Render(data, key)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.Internal, "", err))
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, "attachment; filename=\"confirmation.pdf\"")
+
+	return c.Send(document)
 }
 
-// Get native token balance
-func getNativeBalance(address, chain string, block int) (models.NativeBalance, error) {
-	url := fmt.Sprintf("https://deep-index.moralis.io/api/v2/%v/balance?chain=%v&to_block=%v", address, chain, block)
+// Builds a balance request from the /balances body fields, read with get.
+func balanceRequest(get func(key string) string) models.Request {
+	return models.Request{
+		Address:        get("address"),
+		Chain:          get("chain"),
+		Date:           get("date"),
+		Timestamp:      get("timestamp"),
+		SpamChecks:     get("spam_checks"),
+		Decompose:      get("decompose"),
+		Liabilities:    get("liabilities"),
+		Staking:        get("staking"),
+		StakingAddress: get("staking_address"),
+		Shares:         get("shares"),
+		Consensus:      get("consensus"),
+		Prices:         get("prices"),
+	}
+}
+
+// Loads the tenant's stored runs in a comma separated list of ids.
+func loadRuns(tenant, ids string) ([]*runs.Run, error) {
+	var stored []*runs.Run
+
+	for _, id := range strings.Split(ids, ",") {
+		run, err := runs.LoadFor(tenant, strings.TrimSpace(id))
+		if err != nil {
+			return nil, err
+		}
+
+		stored = append(stored, run)
+	}
 
-	resp, err := get(url)
+	return stored, nil
+}
+
+// Re-executes a stored run at the same block and returns the differences from the stored result.
+func ReperformRun(c *fiber.Ctx) error {
+	original, err := runs.LoadFor(tenant(c), c.Params("id"))
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.NotFound, "", err))
+	}
+
+	return reperformAndCompare(c, original)
+}
+
+// Re-executes the run in an uploaded evidence pack (form field "evidence") once its integrity and signature are verified.
+func ReperformEvidence(c *fiber.Ctx) error {
+	upload, err := c.FormFile("evidence")
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidEvidence, "", err))
+	}
+
+	file, err := upload.Open()
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.InvalidEvidence, "", err))
+	}
+
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
 	if err != nil {
-		return models.NativeBalance{}, err
+		return sendError(c, faults.Wrap(faults.InvalidEvidence, "", err))
 	}
 
-	var response *models.NativeBalance
+	trusted, err := evidence.TrustedKey()
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.SigningFailed, "", err))
+	}
+
+	// without the firm's key any pack signed with a key of its own would pass
+	if trusted == nil {
+		return sendError(c, faults.Newf(faults.SigningFailed, "", "EVIDENCE_PUBLIC_KEY is not set, so uploaded evidence packs cannot be verified"))
+	}
 
-	err = json.Unmarshal(resp, &response)
+	original, err := evidence.Open(data, trusted)
 	if err != nil {
-		return models.NativeBalance{}, err
+		return sendError(c, faults.Wrap(faults.InvalidEvidence, "", err))
 	}
 
-	return *response, nil
+	return reperformAndCompare(c, original)
 }
 
-func get(url string) ([]byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func reperformAndCompare(c *fiber.Ctx, original *runs.Run) error {
+	current, err := Reperform(c.UserContext(), tenant(c), original)
 	if err != nil {
-		return nil, err
+		return sendError(c, faults.Wrap(faults.Internal, "", err))
 	}
 
-	req.Header.Add("X-API-Key", initialisers.APIKEY)
-	req.Header.Add("Accept", "application/json")
+	c.Set("X-Run-ID", current.ID)
 
-	resp, err := http.DefaultClient.Do(req)
+	return c.JSON(reperform.Compare(original, current))
+}
+
+// Returns the address's ERC20 balances at the block, read across every page, along with the responses as received.
+func getTokenBalance(ctx context.Context, address, chain string, block int) ([]models.TokenBalance, []byte, error) {
+	url := fmt.Sprintf("%v/%v/erc20?chain=%v&to_block=%v", moralis.API, address, chain, block)
+
+	items, raw, err := moralis.List(ctx, url)
 	if err != nil {
-		return nil, err
+		return []models.TokenBalance{}, nil, err
+	}
+
+	balances := make([]models.TokenBalance, 0, len(items))
+
+	for _, item := range items {
+		balance, err := moralis.TokenBalance(item)
+		if err != nil {
+			return []models.TokenBalance{}, nil, err
+		}
+
+		balances = append(balances, balance)
 	}
 
-	defer resp.Body.Close()
+	return balances, raw, nil
+}
+
+// Get native token balance along with the response as received
+func getNativeBalance(ctx context.Context, address, chain string, block int) (models.NativeBalance, []byte, error) {
+	url := fmt.Sprintf("%v/%v/balance?chain=%v&to_block=%v", moralis.API, address, chain, block)
+
+	resp, err := moralis.Get(ctx, url)
+	if err != nil {
+		return models.NativeBalance{}, nil, err
+	}
 
-	body, err := io.ReadAll(resp.Body)
+	balance, err := moralis.NativeBalance(resp)
 	if err != nil {
-		return nil, err
+		return models.NativeBalance{}, nil, err
 	}
 
-	return body, nil
+	return balance, resp, nil
+}
+
+// Logs the error and returns it as the response under "error", with the status its code maps to.
+func sendError(c *fiber.Ctx, err *faults.Error) error {
+	faults.Log(err)
+
+	return c.Status(err.Status).JSON(fiber.Map{
+		"error": err,
+	})
 }
 
 func formatDate(inputDate string) (string, error) {
@@ -214,7 +786,7 @@ func formatDate(inputDate string) (string, error) {
 
 	t, err := time.Parse(inputFormat, inputDate)
 	if err != nil {
-		return "", errors.New(fmt.Sprintf("error (code: 600000): %v", err))
+		return "", faults.Newf(faults.InvalidDate, faults.StepRequest, "date %q is not in dd/mm/yyyy format", inputDate)
 	}
 
 	formattedDate := t.Format(outputFormat)
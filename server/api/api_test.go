@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/moralis"
+	"github.com/harrisandtrotter/proof-of-balance/server/progress"
+)
+
+// Address Moralis fails to read balances for
+const unreadable = "0x2222222222222222222222222222222222222222"
+
+// Serves Moralis for the test: the block at any time, 1 ETH and one token for every address but unreadable.
+func fakeMoralis(t *testing.T) {
+	t.Helper()
+
+	saved := []interface{}{moralis.API, initialisers.RUNSDIR, initialisers.SPAMLISTFILE, initialisers.WALLETWORKERS}
+	t.Cleanup(func() {
+		moralis.API = saved[0].(string)
+		initialisers.RUNSDIR = saved[1].(string)
+		initialisers.SPAMLISTFILE = saved[2].(string)
+		initialisers.WALLETWORKERS = saved[3].(int)
+	})
+
+	dir := t.TempDir()
+	initialisers.RUNSDIR = filepath.Join(dir, "runs")
+	initialisers.SPAMLISTFILE = filepath.Join(dir, "spam-list.json")
+	initialisers.WALLETWORKERS = 2
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/dateToBlock"):
+			w.Write([]byte(`{"date":"2024-03-31T23:59:59Z","block":100,"timestamp":1711929599,"hash":"0xabc"}`))
+		case strings.HasPrefix(r.URL.Path, "/"+unreadable):
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"message":"Internal server error"}`))
+		case strings.HasSuffix(r.URL.Path, "/balance"):
+			w.Write([]byte(`{"balance":"1000000000000000000"}`))
+		case strings.HasSuffix(r.URL.Path, "/erc20"):
+			w.Write([]byte(`{"cursor":null,"result":[{"token_address":"0x` + strings.Repeat("3", 40) + `","symbol":"USDC","name":"USD Coin","decimals":6,"balance":"2500000"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	moralis.API = server.URL
+}
+
+// Returns the events of a Server-Sent Events body, checking each is named after its type.
+func events(t *testing.T, body string) []progress.Event {
+	t.Helper()
+
+	var sent []progress.Event
+
+	for _, message := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		lines := strings.SplitN(message, "\n", 2)
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "event: ") || !strings.HasPrefix(lines[1], "data: ") {
+			t.Fatalf("malformed event %q", message)
+		}
+
+		var event progress.Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &event); err != nil {
+			t.Fatal(err)
+		}
+
+		if name := strings.TrimPrefix(lines[0], "event: "); name != event.Type {
+			t.Errorf("event %v carries a %v", name, event.Type)
+		}
+
+		sent = append(sent, event)
+	}
+
+	return sent
+}
+
+func TestStreamBalances(t *testing.T) {
+	fakeMoralis(t)
+
+	app := fiber.New()
+	app.Get("/balances/stream", StreamBalances)
+
+	readable := "0x" + strings.Repeat("1", 40)
+
+	tests := []struct {
+		name    string
+		query   string
+		status  int
+		wallets [][]string
+	}{
+		{"one wallet", "address=" + readable + "&chain=eth", http.StatusOK, [][]string{
+			{progress.Started, progress.Block, progress.Balances, progress.Row, progress.Row, progress.Completed},
+		}},
+		{"wallet that fails", "address=" + readable + "," + unreadable + "&chain=eth", http.StatusOK, [][]string{
+			{progress.Started, progress.Block, progress.Balances, progress.Row, progress.Row, progress.Completed},
+			{progress.Started, progress.Block, progress.Failed},
+		}},
+		{"unsupported chain", "address=" + readable + "&chain=solana", http.StatusBadRequest, nil},
+		{"no wallets", "chain=eth", http.StatusBadRequest, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/balances/stream?"+test.query+"&date=31/03/2024&timestamp=23:59:59", nil)
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != test.status {
+				t.Fatalf("status = %v, want %v: %s", resp.StatusCode, test.status, body)
+			}
+
+			if test.wallets == nil {
+				return
+			}
+
+			if resp.Header.Get(fiber.HeaderContentType) != "text/event-stream" {
+				t.Errorf("content type = %v, want an event stream", resp.Header.Get(fiber.HeaderContentType))
+			}
+
+			sent := events(t, string(body))
+
+			// wallets run concurrently, so only each wallet's own events are in order
+			got := make([][]string, len(test.wallets))
+
+			for i, event := range sent {
+				if event.Type == progress.Done {
+					if i != len(sent)-1 || event.Wallets != len(test.wallets) {
+						t.Errorf("done event %+v is not last or miscounts the wallets", event)
+					}
+
+					continue
+				}
+
+				if event.Wallet < 1 || event.Wallet > len(test.wallets) || event.Wallets != len(test.wallets) {
+					t.Fatalf("event %+v names no wallet of %v", event, len(test.wallets))
+				}
+
+				got[event.Wallet-1] = append(got[event.Wallet-1], event.Type)
+
+				switch event.Type {
+				case progress.Completed:
+					if event.RunID == "" || event.Rows != 2 {
+						t.Errorf("completed event %+v, want the run id and 2 rows", event)
+					}
+				case progress.Failed:
+					if event.Error == nil || event.Error.Code != faults.ProviderUnavailable {
+						t.Errorf("failed event %+v, want the provider's error", event)
+					}
+				}
+			}
+
+			if len(sent) == 0 || sent[len(sent)-1].Type != progress.Done {
+				t.Errorf("stream did not end with done: %+v", sent)
+			}
+
+			for i := range test.wallets {
+				if strings.Join(got[i], ",") != strings.Join(test.wallets[i], ",") {
+					t.Errorf("wallet %v sent %v, want %v", i+1, got[i], test.wallets[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/harrisandtrotter/proof-of-balance/server/auth"
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+)
+
+// Cookie holding the session token of a browser that signed in with an API key
+const sessionCookie = "pob_session"
+
+// Name the authenticated key is stored under in the request's locals
+const principal = "principal"
+
+// Authenticates the request by its X-API-Key header, Authorization bearer token or session cookie, and stores the
+// key for the handlers. Every route registered after this middleware requires authentication unless AUTH_DISABLED.
+func authenticate(c *fiber.Ctx) error {
+	if initialisers.AUTHDISABLED || c.Method() == fiber.MethodOptions {
+		return c.Next()
+	}
+
+	key, err := credentials(c)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.Unauthorized, faults.StepRequest, err))
+	}
+
+	c.Locals(principal, key)
+
+	return c.Next()
+}
+
+// Returns the key the request was made with.
+func credentials(c *fiber.Ctx) (*auth.Key, error) {
+	if key := c.Get("X-API-Key"); key != "" {
+		return auth.Authenticate(key)
+	}
+
+	if bearer := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(bearer, "Bearer ") {
+		return auth.Authenticate(strings.TrimPrefix(bearer, "Bearer "))
+	}
+
+	if token := c.Cookies(sessionCookie); token != "" {
+		if !trustedOrigin(c) {
+			return nil, faults.Newf(faults.Forbidden, faults.StepRequest, "session requests must come from an allowed origin")
+		}
+
+		return auth.Resume(token)
+	}
+
+	return nil, auth.ErrUnauthorized
+}
+
+// Returns the key the request was authenticated with, nil when authentication is disabled.
+func caller(c *fiber.Ctx) *auth.Key {
+	key, _ := c.Locals(principal).(*auth.Key)
+
+	return key
+}
+
+// Returns the tenant the request was made for. Runs are stored and read within it.
+func tenant(c *fiber.Ctx) string {
+	if key := caller(c); key != nil {
+		return key.Tenant
+	}
+
+	return ""
+}
+
+// Counts units of provider-backed work against the caller's daily quota and reports what is left in the
+// X-Quota-Remaining header. Returns the error to send when the quota is used up.
+func spend(c *fiber.Ctx, units int) *faults.Error {
+	key := caller(c)
+	if key == nil {
+		return nil
+	}
+
+	remaining, err := auth.Spend(key, units)
+
+	switch {
+	case errors.Is(err, auth.ErrQuotaExceeded):
+		c.Set("X-Quota-Remaining", strconv.Itoa(remaining))
+		return faults.Wrap(faults.QuotaExceeded, faults.StepRequest, err)
+	case err != nil:
+		return faults.Wrap(faults.StorageFailed, faults.StepRequest, err)
+	case key.DailyQuota > 0:
+		c.Set("X-Quota-Remaining", strconv.Itoa(remaining))
+	}
+
+	return nil
+}
+
+// Middleware charging each request to the route a fixed number of units of the caller's quota.
+func quota(units int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := spend(c, units); err != nil {
+			return sendError(c, err)
+		}
+
+		return c.Next()
+	}
+}
+
+// Middleware limiting the route to admin keys.
+func adminOnly(c *fiber.Ctx) error {
+	if key := caller(c); key != nil && !key.Admin {
+		return sendError(c, faults.Newf(faults.Forbidden, faults.StepRequest, "key %v may not change firm-wide settings", key.Name))
+	}
+
+	return c.Next()
+}
+
+// Opens a browser session with the API key in the X-API-Key header or the "api_key" body field, and sets the session
+// cookie. Returns the key's name and tenant.
+func SignIn(c *fiber.Ctx) error {
+	if initialisers.AUTHDISABLED {
+		return c.JSON(fiber.Map{"auth": "disabled"})
+	}
+
+	var body map[string]string
+
+	// only JSON bodies are read, so a form on another site cannot sign the browser in to the attacker's key
+	plaintext := c.Get("X-API-Key")
+	if plaintext == "" && json.Unmarshal(c.Body(), &body) == nil {
+		plaintext = body["api_key"]
+	}
+
+	key, err := auth.Authenticate(plaintext)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.Unauthorized, faults.StepRequest, err))
+	}
+
+	token, expires, err := auth.Open(key)
+	if err != nil {
+		return sendError(c, faults.Wrap(faults.Internal, "", err))
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Expires:  expires,
+		HTTPOnly: true,
+		Secure:   c.Protocol() == "https",
+		// never sent with requests other sites trigger; the client must share a site with the API, as localhost does
+		SameSite: fiber.CookieSameSiteStrictMode,
+	})
+
+	return c.JSON(fiber.Map{"name": key.Name, "tenant": key.Tenant, "expires": expires})
+}
+
+// Ends the browser session and clears its cookie.
+func SignOut(c *fiber.Ctx) error {
+	if token := c.Cookies(sessionCookie); token != "" {
+		auth.Close(token)
+	}
+
+	c.ClearCookie(sessionCookie)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Reports whether a request authenticated by the session cookie came from the client's own pages: from an allowed
+// origin or the API's own. Browsers send cookies with requests that pages on other origins of the same site trigger
+// too, such as forms and images, and those either name their origin or are marked as not same-origin.
+func trustedOrigin(c *fiber.Ctx) bool {
+	origin := c.Get(fiber.HeaderOrigin)
+	if origin == "" {
+		return c.Get("Sec-Fetch-Site") == "same-origin"
+	}
+
+	if origin == c.BaseURL() {
+		return true
+	}
+
+	for _, allowed := range strings.Split(initialisers.CORSORIGINS, ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "*" && allowed == origin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Middleware refusing request bodies other than JSON, which HTML forms on other sites cannot send without the
+// browser asking the API's CORS policy first.
+func jsonOnly(c *fiber.Ctx) error {
+	if !strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) {
+		return sendError(c, faults.Newf(faults.InvalidRequest, faults.StepRequest, "request body must be %v", fiber.MIMEApplicationJSON))
+	}
+
+	return c.Next()
+}
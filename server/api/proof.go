@@ -0,0 +1,435 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/blocks"
+	"github.com/harrisandtrotter/proof-of-balance/server/consensus"
+	"github.com/harrisandtrotter/proof-of-balance/server/defi"
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/prices"
+	"github.com/harrisandtrotter/proof-of-balance/server/progress"
+	"github.com/harrisandtrotter/proof-of-balance/server/rebasing"
+	"github.com/harrisandtrotter/proof-of-balance/server/recorder"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+	"github.com/harrisandtrotter/proof-of-balance/server/runs"
+	"github.com/harrisandtrotter/proof-of-balance/server/scheduler"
+	"github.com/harrisandtrotter/proof-of-balance/server/spam"
+	"github.com/harrisandtrotter/proof-of-balance/server/staking"
+)
+
+var price prices.Price
+
+// Performs a proof of balance for the request and stores it as a run of the tenant. The block, balances and each row
+// are sent to the reporter as they complete.
+func Prove(ctx context.Context, tenant string, request models.Request, reporter progress.Reporter) (*runs.Run, error) {
+	run, err := prove(ctx, tenant, request, nil, reporter)
+	if err != nil {
+		return nil, err
+	}
+
+	return run, save(run)
+}
+
+// Re-executes a stored run against the current providers at the block the run resolved, and stores the result as a
+// new run of the tenant.
+func Reperform(ctx context.Context, tenant string, original *runs.Run) (*runs.Run, error) {
+	run, err := prove(ctx, tenant, original.Request, &original.Block, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	run.ReperformanceOf = original.ID
+
+	return run, save(run)
+}
+
+func save(run *runs.Run) error {
+	err := runs.Save(run)
+	if err != nil {
+		return faults.Wrap(faults.StorageFailed, faults.StepSave, err).For(run.Request.Address, run.Request.Chain)
+	}
+
+	return nil
+}
+
+// Performs the proof at the pinned block, or at the block Moralis resolves for the cut-off when pinned is nil.
+func prove(ctx context.Context, tenant string, request models.Request, pinned *blocks.Block, reporter progress.Reporter) (*runs.Run, error) {
+	run := runs.New(tenant, request)
+
+	// every provider exchange of the run is kept with it as evidence
+	ctx, transcript := recorder.WithTranscript(ctx)
+
+	// failures are reported against the wallet and the step that failed
+	fail := func(code, step string, err error) error {
+		return faults.Wrap(code, step, err).For(request.Address, request.Chain)
+	}
+
+	// chain for moralis API
+	chain, err := models.DetermineChain(request.Chain)
+	if err != nil {
+		return nil, fail(faults.InvalidChain, faults.StepRequest, err)
+	}
+
+	formatDate, err := formatDate(request.Date)
+	if err != nil {
+		return nil, fail(faults.InvalidDate, faults.StepRequest, err)
+	}
+	// block number based on chain and timestamp
+	var cutOffBlock blocks.Block
+	if pinned != nil {
+		cutOffBlock = *pinned
+	} else {
+		cutOff, err := time.Parse("2006-01-02 15:04:05", formatDate+" "+request.Timestamp)
+		if err != nil {
+			return nil, fail(faults.InvalidDate, faults.StepRequest, err)
+		}
+
+		cutOffBlock, err = block.Lookup(ctx, chain, cutOff)
+		if err != nil {
+			return nil, fail(faults.ProviderFailed, faults.StepBlock, err)
+		}
+	}
+	blockNo := cutOffBlock.Block
+
+	reporter.Send(progress.Event{Type: progress.Block, Address: request.Address, Chain: chain, BlockNumber: blockNo})
+
+	// relevant info to be returned to user
+	asset, url, name, tokenUrl, err := models.ReturnInfo(chain)
+	if err != nil {
+		return nil, fail(faults.InvalidChain, faults.StepRequest, err)
+	}
+
+	// get native balance
+	nativeBalanceResp, nativeRaw, err := getNativeBalance(ctx, request.Address, chain, blockNo)
+	if err != nil {
+		return nil, fail(faults.ProviderFailed, faults.StepNativeBalance, err)
+	}
+
+	// convert type string to float64
+	balanceStr, err := strconv.ParseFloat(nativeBalanceResp.Balance, 64)
+	if err != nil {
+		return nil, fail(faults.ProviderInvalidResponse, faults.StepNativeBalance, err)
+	}
+
+	// convert from wei to ether
+	balance := balanceStr / math.Pow10(18)
+
+	tokenBalanceResp, tokenRaw, err := getTokenBalance(ctx, request.Address, chain, blockNo)
+	if err != nil {
+		return nil, fail(faults.ProviderFailed, faults.StepTokenBalances, err)
+	}
+
+	reporter.Send(progress.Event{Type: progress.Balances, Address: request.Address, Chain: chain, BlockNumber: blockNo, Tokens: len(tokenBalanceResp)})
+
+	// local spam allow and deny lists
+	spamLists, err := spam.LoadLists()
+	if err != nil {
+		return nil, fail(faults.Internal, faults.StepSpamLists, err)
+	}
+
+	var response []models.ClientResponse
+
+	var mu sync.Mutex
+	completed := 0
+
+	// rows are reported as they complete so a streaming client can show them before the run finishes
+	report := func(row models.ClientResponse) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		completed++
+		reporter.Send(progress.Event{Type: progress.Row, Address: request.Address, Chain: chain, BlockNumber: blockNo, Rows: completed, Row: &row})
+	}
+
+	add := func(row models.ClientResponse) {
+		response = append(response, row)
+		report(row)
+	}
+
+	nativeRow := models.ClientResponse{
+		Address:       request.Address,
+		Chain:         chain,
+		BlockNumber:   blockNo,
+		Asset:         asset,
+		AssetName:     name,
+		AssetAddress:  "N/A",
+		Balance:       balance,
+		CheckerUrl:    models.CheckerLink(url, request.Address, "", blockNo),
+		VerifyCommand: rpc.VerifyCommand(chain, request.Address, "", blockNo),
+		PossibleSpam:  false,
+		SpamReasons:   []string{},
+	}
+
+	if request.Consensus == "true" {
+		applyConsensus(&nativeRow, consensus.Check(ctx, request.Address, chain, "", nativeBalanceResp.Balance, cutOffBlock.Hash, blockNo), 18)
+	}
+
+	// native stake delegated from the address, reported alongside the native balance. Stake that cannot be read is
+	// recorded on the native row rather than failing the run.
+	var delegations []staking.Delegation
+
+	if request.Staking == "true" {
+		cutOff, err := time.Parse("2006-01-02 15:04:05", formatDate+" "+request.Timestamp)
+		if err != nil {
+			return nil, fail(faults.InvalidDate, faults.StepRequest, err)
+		}
+
+		delegations, err = staking.Delegations(ctx, chain, request.Address, request.StakingAddress, cutOff, blockNo)
+		if err != nil {
+			nativeRow.Errors = append(nativeRow.Errors, rowFault(request.Address, chain, faults.StepStaking, "error reading native stake", err))
+		}
+	}
+
+	add(nativeRow)
+
+	for _, delegation := range delegations {
+		// no explorer link: the balance checker would show the address's balance of the staking contract as a token,
+		// not its stake, so the stake is checked with the verification command only
+		add(models.ClientResponse{
+			Address:          request.Address,
+			Chain:            chain,
+			BlockNumber:      delegation.BlockNumber,
+			ReadAt:           delegation.ReadAt,
+			Asset:            delegation.Asset,
+			AssetName:        "Staked " + name,
+			AssetAddress:     delegation.StakingContract,
+			Balance:          delegation.Principal,
+			VerifyCommand:    delegation.VerifyCommand,
+			SpamReasons:      []string{},
+			Protocol:         "native staking on " + delegation.StakedOn,
+			Validator:        delegation.Validator,
+			UnclaimedRewards: delegation.UnclaimedRewards,
+			Note:             delegation.Note,
+		})
+	}
+
+	tokenRow := func(value models.TokenBalance) (models.ClientResponse, error) {
+		tokenStr, err := strconv.ParseFloat(value.Balance, 64)
+		if err != nil {
+			return models.ClientResponse{}, err
+		}
+
+		tokenBalance := tokenStr / math.Pow10(value.Decimals)
+
+		// steps that fail for one token are recorded on its row rather than failing the run
+		var rowErrors []*faults.Error
+
+		note := func(step, context string, err error) {
+			rowErrors = append(rowErrors, rowFault(request.Address, chain, step, context, err))
+		}
+
+		signals := spam.Signals{Token: value, Chain: chain}
+		if request.SpamChecks == "full" {
+			var failures []spam.Failure
+			signals, failures = spam.Collect(ctx, request.Address, chain, blockNo, value)
+
+			for _, failure := range failures {
+				note(faults.StepSpamLists, "error checking "+failure.Signal+" for "+value.TokenAddress, failure.Err)
+			}
+		}
+
+		classification := spam.Classify(signals, spamLists)
+
+		// underlying assets of lending, LP and staking receipt tokens
+		var position *defi.Position
+		if request.Decompose == "true" {
+			position, err = defi.Decompose(ctx, chain, value.TokenAddress, value.Balance, blockNo)
+			if err != nil {
+				note(faults.StepDecomposition, "error decomposing "+value.TokenAddress, err)
+			}
+		}
+
+		row := models.ClientResponse{
+			Address:       request.Address,
+			Chain:         chain,
+			BlockNumber:   blockNo,
+			Asset:         value.Symbol,
+			AssetName:     value.Name,
+			AssetAddress:  value.TokenAddress,
+			Balance:       tokenBalance,
+			CheckerUrl:    models.CheckerLink(tokenUrl, request.Address, value.TokenAddress, blockNo),
+			VerifyCommand: rpc.VerifyCommand(chain, request.Address, value.TokenAddress, blockNo),
+			PossibleSpam:  classification.Spam,
+			SpamScore:     classification.Score,
+			SpamReasons:   classification.Reasons,
+		}
+
+		// debt tokens are rarely priced themselves, so debt is valued at the price of the asset owed
+		pricedBy := value.TokenAddress
+
+		if position != nil {
+			row.Protocol = position.Protocol + " " + position.Kind
+			row.Underlying = position.Underlying
+
+			// a debt token is owed whether or not liabilities were requested, so it is never counted as an asset
+			row.Liability = position.Kind == defi.Debt
+			if row.Liability && len(position.Underlying) > 0 {
+				pricedBy = position.Underlying[0].TokenAddress
+			}
+		}
+
+		if request.Liabilities == "true" {
+			liability, err := defi.AaveDebt(ctx, request.Address, chain, value.TokenAddress, value.Balance, blockNo)
+			if err != nil {
+				note(faults.StepLiabilities, "error reading debt token "+value.TokenAddress, err)
+			}
+
+			if liability != nil {
+				row.Protocol = liability.Protocol + " " + defi.Debt
+				row.Liability = true
+				row.Principal = liability.Principal
+				row.AccruedInterest = liability.AccruedInterest
+				row.HealthFactor = liability.HealthFactor
+				row.Note = liability.Note
+				pricedBy = liability.Asset.TokenAddress
+			}
+		}
+
+		if request.Shares == "true" {
+			shares, err := rebasing.Detect(ctx, request.Address, chain, value.TokenAddress, value.Balance, value.Decimals, blockNo)
+			if err != nil {
+				note(faults.StepShares, "error reading shares of "+value.TokenAddress, err)
+			}
+
+			if shares != nil {
+				row.ShareModel = shares.Model
+				row.Shares = shares.Shares
+				row.ShareRate = shares.ShareRate
+			}
+		}
+
+		if request.Consensus == "true" {
+			applyConsensus(&row, consensus.Check(ctx, request.Address, chain, value.TokenAddress, value.Balance, cutOffBlock.Hash, blockNo), value.Decimals)
+		}
+
+		if request.Prices == "true" {
+			// left unpriced so the gap is visible in the report
+			if err := valueRow(ctx, &row, pricedBy, chain, blockNo, formatDate); err != nil {
+				note(faults.StepPrice, "error pricing "+pricedBy, err)
+			}
+		}
+
+		row.Errors = rowErrors
+
+		return row, nil
+	}
+
+	// token balances are independent, so they are worked on concurrently and kept in the order Moralis listed them
+	tokenRows := make([]models.ClientResponse, len(tokenBalanceResp))
+	tokenErrs := make([]error, len(tokenBalanceResp))
+
+	scheduler.Each(len(tokenBalanceResp), initialisers.TOKENWORKERS, func(i int) {
+		tokenRows[i], tokenErrs[i] = tokenRow(tokenBalanceResp[i])
+		if tokenErrs[i] == nil {
+			report(tokenRows[i])
+		}
+	})
+
+	for _, err := range tokenErrs {
+		if err != nil {
+			return nil, fail(faults.ProviderInvalidResponse, faults.StepTokenBalances, err)
+		}
+	}
+
+	response = append(response, tokenRows...)
+
+	// compound debt is not held as a token, so it is read from the protocol directly
+	if request.Liabilities == "true" {
+		debts, err := defi.CompoundDebts(ctx, request.Address, chain, blockNo)
+		if err != nil {
+			return nil, fail(faults.ProviderFailed, faults.StepLiabilities, err)
+		}
+
+		// no explorer link: the balance checker would show the cToken or Comet balance, not the debt, so the debt is
+		// checked with the verification command only
+		for _, debt := range debts {
+			row := models.ClientResponse{
+				Address:         request.Address,
+				Chain:           chain,
+				BlockNumber:     blockNo,
+				Asset:           debt.Asset.Symbol,
+				AssetName:       debt.Asset.Symbol + " debt",
+				AssetAddress:    debt.Asset.TokenAddress,
+				Balance:         debt.Asset.Amount,
+				VerifyCommand:   rpc.VerifyCall(chain, debt.Market, debt.Method, blockNo, rpc.EncodeAddress(request.Address)),
+				SpamReasons:     []string{},
+				Protocol:        debt.Protocol + " " + defi.Debt,
+				Liability:       true,
+				Principal:       debt.Principal,
+				AccruedInterest: debt.AccruedInterest,
+				HealthFactor:    debt.HealthFactor,
+			}
+
+			if request.Prices == "true" {
+				if err := valueRow(ctx, &row, debt.Asset.TokenAddress, chain, blockNo, formatDate); err != nil {
+					row.Errors = append(row.Errors, rowFault(request.Address, chain, faults.StepPrice, "error pricing "+debt.Asset.Symbol+" debt", err))
+				}
+			}
+
+			add(row)
+		}
+	}
+
+	run.Block = cutOffBlock
+	run.Rows = response
+	run.Raw[runs.RawBlock], _ = json.Marshal(cutOffBlock)
+	run.Raw[runs.RawNativeBalance] = nativeRaw
+	run.Raw[runs.RawTokenBalances] = tokenRaw
+	run.Raw[runs.RawExchanges], _ = json.Marshal(transcript.Exchanges())
+
+	return run, nil
+}
+
+// Values the row at the USD price of the asset at pricedBy: the token itself, or for debt the asset owed.
+func valueRow(ctx context.Context, row *models.ClientResponse, pricedBy, chain string, block int, date string) error {
+	if pricedBy == "" || pricedBy == "N/A" {
+		return fmt.Errorf("%v has no contract to price it by", row.Asset)
+	}
+
+	usdPrice, source, err := price.Valuation(ctx, pricedBy, chain, block, date)
+	if err != nil {
+		return err
+	}
+
+	row.UsdPrice = usdPrice
+	row.UsdValue = usdPrice * row.Balance
+	row.PriceSource = source
+
+	return nil
+}
+
+// Returns the logged failure of a step for one row, which is recorded on the row rather than failing the run.
+func rowFault(address, chain, step, context string, err error) *faults.Error {
+	failure := faults.Wrap(faults.ProviderFailed, step, err).For(address, chain)
+	failure.Message = context + ": " + failure.Message
+	faults.Log(failure)
+
+	return failure
+}
+
+// Records the providers' balances on the row. When the quorum agrees on a balance other than Moralis's, the row
+// reports the agreed balance.
+func applyConsensus(row *models.ClientResponse, result consensus.Result, decimals int) {
+	row.Consensus = result.Status
+	row.ProviderBalances = result.Providers
+
+	if result.Balance == "" || result.Balance == result.Providers[0].Balance {
+		return
+	}
+
+	agreed, err := strconv.ParseFloat(result.Balance, 64)
+	if err != nil {
+		return
+	}
+
+	row.Balance = agreed / math.Pow10(decimals)
+}
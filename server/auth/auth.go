@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/files"
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+)
+
+// Prefix of generated API keys, so a leaked key is easy to recognise
+const keyPrefix = "pob_"
+
+// Tenant names are used as directory names, so they are limited to characters safe in paths
+var validTenant = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+// Returned when a request carries no API key or session, or one that is not recognised
+var ErrUnauthorized = errors.New("a valid API key or session is required")
+
+// API key issued to a tenant. Only the SHA-256 of the key is stored, so the keys file does not hold credentials.
+type Key struct {
+	Name   string `json:"name"`
+	Tenant string `json:"tenant"`
+	Hash   string `json:"key_sha256"`
+	// Provider-backed requests the key may make per UTC day, unlimited when zero
+	DailyQuota int `json:"daily_quota"`
+	// Admin keys may also change firm-wide settings such as price overrides
+	Admin bool `json:"admin,omitempty"`
+}
+
+// Guards the keys file and the keys read from it, along with the modification time and size of the file they were
+// read from, so keys issued or revoked while the server runs take effect on the next request
+var mu sync.Mutex
+var keys []Key
+var loadedFrom os.FileInfo
+
+// Returns the key the plaintext API key was issued as.
+func Authenticate(plaintext string) (*Key, error) {
+	plaintext = strings.TrimSpace(plaintext)
+	if plaintext == "" {
+		return nil, ErrUnauthorized
+	}
+
+	all, err := Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	return byHash(all, Hash(plaintext))
+}
+
+// Returns the key with the hash.
+func byHash(all []Key, hash string) (*Key, error) {
+	for i := range all {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(all[i].Hash)) == 1 {
+			return &all[i], nil
+		}
+	}
+
+	return nil, ErrUnauthorized
+}
+
+// Returns the keys in the keys file, read again whenever the file changes. A missing file means no keys have been
+// issued.
+func Keys() ([]Key, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	info, err := os.Stat(initialisers.AUTHKEYSFILE)
+	if errors.Is(err, os.ErrNotExist) {
+		keys, loadedFrom = nil, nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading keys file: %v", err)
+	}
+
+	if loadedFrom != nil && info.ModTime().Equal(loadedFrom.ModTime()) && info.Size() == loadedFrom.Size() {
+		return keys, nil
+	}
+
+	data, err := os.ReadFile(initialisers.AUTHKEYSFILE)
+	if err != nil {
+		return nil, fmt.Errorf("error reading keys file: %v", err)
+	}
+
+	var read []Key
+
+	err = json.Unmarshal(data, &read)
+	if err != nil {
+		return nil, fmt.Errorf("error reading keys file: %v", err)
+	}
+
+	err = validate(read)
+	if err != nil {
+		return nil, fmt.Errorf("error in keys file: %v", err)
+	}
+
+	keys, loadedFrom = read, info
+
+	return keys, nil
+}
+
+// Generates an API key for the tenant and adds it to the keys file. Returns the plaintext key, which is not stored
+// and cannot be recovered.
+func Issue(name, tenant string, dailyQuota int, admin bool) (string, error) {
+	existing, err := Keys()
+	if err != nil {
+		return "", err
+	}
+
+	secret := make([]byte, 32)
+
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext := keyPrefix + hex.EncodeToString(secret)
+
+	issued := append(append([]Key{}, existing...), Key{
+		Name:       strings.TrimSpace(name),
+		Tenant:     strings.TrimSpace(tenant),
+		Hash:       Hash(plaintext),
+		DailyQuota: dailyQuota,
+		Admin:      admin,
+	})
+
+	err = validate(issued)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(issued, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	err = files.Write(initialisers.AUTHKEYSFILE, data, 0600)
+	if err != nil {
+		return "", fmt.Errorf("error writing keys file: %v", err)
+	}
+
+	return plaintext, nil
+}
+
+// Returns the hex SHA-256 of a plaintext API key, as stored in the keys file.
+func Hash(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Reports whether the tenant name is usable.
+func ValidTenant(tenant string) bool {
+	return validTenant.MatchString(tenant)
+}
+
+// Checks every key has a unique name, a valid tenant, a hash and a quota that is not negative.
+func validate(all []Key) error {
+	names := map[string]bool{}
+
+	for _, key := range all {
+		switch {
+		case key.Name == "":
+			return errors.New("key name is required")
+		case names[key.Name]:
+			return fmt.Errorf("key name %q is used more than once", key.Name)
+		case !ValidTenant(key.Tenant):
+			return fmt.Errorf("key %q has invalid tenant %q: use letters, digits, - and _", key.Name, key.Tenant)
+		case len(key.Hash) != sha256.Size*2:
+			return fmt.Errorf("key %q has no valid key_sha256", key.Name)
+		case key.DailyQuota < 0:
+			return fmt.Errorf("key %q has a negative daily_quota", key.Name)
+		}
+
+		names[key.Name] = true
+	}
+
+	return nil
+}
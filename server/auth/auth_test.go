@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+)
+
+// Points the keys and usage files at a temporary directory for the test.
+func setup(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	initialisers.AUTHKEYSFILE = filepath.Join(dir, "api-keys.json")
+	initialisers.USAGEFILE = filepath.Join(dir, "api-usage.json")
+	initialisers.SESSIONTTL = time.Hour
+
+	mu.Lock()
+	keys, loadedFrom = nil, nil
+	mu.Unlock()
+
+	usageMu.Lock()
+	today = nil
+	usageMu.Unlock()
+
+	return dir
+}
+
+func TestHash(t *testing.T) {
+	// SHA-256 of "abc"
+	const want = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+
+	if got := Hash("abc"); got != want {
+		t.Errorf("Hash(abc) = %v, want %v", got, want)
+	}
+}
+
+func TestIssueAndAuthenticate(t *testing.T) {
+	setup(t)
+
+	plaintext, err := Issue("audit", "client-a", 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(plaintext, keyPrefix) {
+		t.Errorf("key %q does not start with %q", plaintext, keyPrefix)
+	}
+
+	data, _ := os.ReadFile(initialisers.AUTHKEYSFILE)
+	if strings.Contains(string(data), plaintext) {
+		t.Error("keys file holds the plaintext key")
+	}
+
+	key, err := Authenticate(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key.Name != "audit" || key.Tenant != "client-a" || key.DailyQuota != 10 {
+		t.Errorf("Authenticate() = %+v", key)
+	}
+
+	for _, wrong := range []string{"", " ", plaintext + "x", "pob_0"} {
+		if _, err := Authenticate(wrong); !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("Authenticate(%q) error = %v, want ErrUnauthorized", wrong, err)
+		}
+	}
+}
+
+func TestIssueValidation(t *testing.T) {
+	setup(t)
+
+	if _, err := Issue("audit", "client-a", 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, tenant string
+		quota        int
+	}{
+		{"audit", "client-b", 0},
+		{"", "client-b", 0},
+		{"other", "../client-b", 0},
+		{"other", "", 0},
+		{"other", "client-b", -1},
+	}
+
+	for _, test := range tests {
+		if _, err := Issue(test.name, test.tenant, test.quota, false); err == nil {
+			t.Errorf("Issue(%q, %q, %v) succeeded", test.name, test.tenant, test.quota)
+		}
+	}
+}
+
+func TestKeysReloadWhenFileChanges(t *testing.T) {
+	setup(t)
+
+	first, err := Issue("first", "client-a", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Authenticate(first); err != nil {
+		t.Fatal(err)
+	}
+
+	// issued by another process while the server runs
+	second, err := Issue("second", "client-b", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Authenticate(second); err != nil {
+		t.Errorf("key issued after the first lookup was refused: %v", err)
+	}
+
+	// revoked by removing the file
+	os.Remove(initialisers.AUTHKEYSFILE)
+
+	if _, err := Authenticate(first); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("revoked key error = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	setup(t)
+
+	plaintext, _ := Issue("audit", "client-a", 0, false)
+	key, _ := Authenticate(plaintext)
+
+	token, expires, err := Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Until(expires) <= 0 {
+		t.Errorf("session expires in the past: %v", expires)
+	}
+
+	resumed, err := Resume(token)
+	if err != nil || resumed.Name != "audit" {
+		t.Fatalf("Resume() = %+v, %v", resumed, err)
+	}
+
+	Close(token)
+
+	if _, err := Resume(token); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("closed session error = %v, want ErrUnauthorized", err)
+	}
+
+	initialisers.SESSIONTTL = -time.Second
+	expired, _, _ := Open(key)
+
+	if _, err := Resume(expired); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expired session error = %v, want ErrUnauthorized", err)
+	}
+
+	initialisers.SESSIONTTL = time.Hour
+	revoked, _, _ := Open(key)
+	os.Remove(initialisers.AUTHKEYSFILE)
+
+	if _, err := Resume(revoked); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("session of a revoked key error = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestSpend(t *testing.T) {
+	setup(t)
+
+	limited := &Key{Name: "limited", DailyQuota: 3}
+	unlimited := &Key{Name: "unlimited"}
+
+	tests := []struct {
+		key       *Key
+		units     int
+		remaining int
+		exceeded  bool
+	}{
+		{limited, 1, 2, false},
+		{limited, 2, 0, false},
+		{limited, 1, 0, true},
+		{unlimited, 100, -1, false},
+	}
+
+	for i, test := range tests {
+		remaining, err := Spend(test.key, test.units)
+		if errors.Is(err, ErrQuotaExceeded) != test.exceeded || (!test.exceeded && err != nil) {
+			t.Fatalf("spend %v: error = %v, want exceeded %v", i, err, test.exceeded)
+		}
+
+		if remaining != test.remaining {
+			t.Errorf("spend %v: remaining = %v, want %v", i, remaining, test.remaining)
+		}
+	}
+
+	// usage survives a restart
+	usageMu.Lock()
+	today = nil
+	usageMu.Unlock()
+
+	if _, err := Spend(limited, 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("spend after reload error = %v, want ErrQuotaExceeded", err)
+	}
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/files"
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+)
+
+// Date format usage is counted by
+const usageDateFormat = "2006-01-02"
+
+// Returned when a key has used its daily quota
+var ErrQuotaExceeded = errors.New("daily quota exceeded")
+
+// Requests made today by each key name, kept in the usage file so a restart does not reset quotas
+type usage struct {
+	Date string         `json:"date"`
+	Used map[string]int `json:"used"`
+}
+
+// Guards the usage file and the usage read from it
+var usageMu sync.Mutex
+var today *usage
+
+// Counts units of provider-backed work against the key's daily quota. Returns the units left today, or -1 when the
+// key is unlimited. Nothing is counted when the units would take the key over its quota.
+func Spend(key *Key, units int) (int, error) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	current, err := loadUsage()
+	if err != nil {
+		return 0, err
+	}
+
+	used := current.Used[key.Name]
+
+	if key.DailyQuota > 0 && used+units > key.DailyQuota {
+		return key.DailyQuota - used, fmt.Errorf("%w: %v of %v used today by key %v, %v more requested", ErrQuotaExceeded, used, key.DailyQuota, key.Name, units)
+	}
+
+	current.Used[key.Name] = used + units
+
+	err = saveUsage(current)
+	if err != nil {
+		return 0, err
+	}
+
+	if key.DailyQuota == 0 {
+		return -1, nil
+	}
+
+	return key.DailyQuota - used - units, nil
+}
+
+// Returns today's usage, starting afresh when the stored usage is for an earlier UTC day.
+func loadUsage() (*usage, error) {
+	date := time.Now().UTC().Format(usageDateFormat)
+
+	if today == nil {
+		today = &usage{}
+
+		data, err := os.ReadFile(initialisers.USAGEFILE)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			today = nil
+			return nil, fmt.Errorf("error reading usage file: %v", err)
+		}
+
+		if err == nil {
+			err = json.Unmarshal(data, today)
+			if err != nil {
+				today = nil
+				return nil, fmt.Errorf("error reading usage file: %v", err)
+			}
+		}
+	}
+
+	if today.Date != date || today.Used == nil {
+		today = &usage{Date: date, Used: map[string]int{}}
+	}
+
+	return today, nil
+}
+
+func saveUsage(current *usage) error {
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = files.Write(initialisers.USAGEFILE, data, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing usage file: %v", err)
+	}
+
+	return nil
+}
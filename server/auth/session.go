@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+)
+
+// Browser session opened with an API key, so the key is not kept in the page after signing in
+type session struct {
+	key     *Key
+	expires time.Time
+}
+
+// Sessions by token. Sessions are held in memory, so a restart signs everyone out.
+var sessions = map[string]session{}
+var sessionsMu sync.Mutex
+
+// Opens a session for the key. Returns the session token and when it expires.
+func Open(key *Key) (string, time.Time, error) {
+	secret := make([]byte, 32)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	token := hex.EncodeToString(secret)
+	expires := time.Now().Add(initialisers.SESSIONTTL)
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	// expired sessions are dropped as new ones open so the map does not grow without bound
+	for other, s := range sessions {
+		if time.Now().After(s.expires) {
+			delete(sessions, other)
+		}
+	}
+
+	sessions[token] = session{key: key, expires: expires}
+
+	return token, expires, nil
+}
+
+// Returns the key the session was opened with, as the keys file now has it, while the session has not expired and
+// the key has not been revoked.
+func Resume(token string) (*Key, error) {
+	sessionsMu.Lock()
+	s, ok := sessions[token]
+
+	if ok && time.Now().After(s.expires) {
+		delete(sessions, token)
+		ok = false
+	}
+
+	sessionsMu.Unlock()
+
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+
+	all, err := Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	return byHash(all, s.key.Hash)
+}
+
+// Ends the session.
+func Close(token string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	delete(sessions, token)
+}
@@ -0,0 +1,232 @@
+package beacon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/recorder"
+)
+
+const (
+	// Seconds per slot on Ethereum mainnet
+	SecondsPerSlot = 12
+
+	// Withdrawal credential prefixes pointing at an execution layer address
+	eth1Prefix        = "0x01"
+	compoundingPrefix = "0x02"
+
+	gweiPerEth = 1e9
+)
+
+// Validator at the slot matching the cut-off timestamp
+type Validator struct {
+	Index                 string  `json:"index"`
+	Pubkey                string  `json:"pubkey"`
+	WithdrawalAddress     string  `json:"withdrawal_address"`
+	Status                string  `json:"status"`
+	Balance               float64 `json:"balance"`
+	EffectiveBalance      float64 `json:"effective_balance"`
+	PendingWithdrawals    float64 `json:"pending_withdrawals"`
+	WithdrawableEpoch     string  `json:"withdrawable_epoch"`
+	Slot                  int     `json:"slot"`
+	StateRoot             string  `json:"state_root"`
+	WithdrawalCredentials string  `json:"withdrawal_credentials"`
+}
+
+// Validator as returned by /eth/v1/beacon/states/{state_id}/validators
+type validatorResponse struct {
+	Index     string `json:"index"`
+	Balance   string `json:"balance"`
+	Status    string `json:"status"`
+	Validator struct {
+		Pubkey                string `json:"pubkey"`
+		WithdrawalCredentials string `json:"withdrawal_credentials"`
+		EffectiveBalance      string `json:"effective_balance"`
+		WithdrawableEpoch     string `json:"withdrawable_epoch"`
+	} `json:"validator"`
+}
+
+// Returns the slot at or before the timestamp.
+func SlotAt(ctx context.Context, timestamp time.Time) (int, error) {
+	var genesis struct {
+		Data struct {
+			GenesisTime string `json:"genesis_time"`
+		} `json:"data"`
+	}
+
+	err := get(ctx, "/eth/v1/beacon/genesis", &genesis)
+	if err != nil {
+		return 0, err
+	}
+
+	genesisTime, err := strconv.ParseInt(genesis.Data.GenesisTime, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid genesis time %q", genesis.Data.GenesisTime)
+	}
+
+	if timestamp.Unix() < genesisTime {
+		return 0, errors.New("timestamp is before beacon chain genesis")
+	}
+
+	return int((timestamp.Unix() - genesisTime) / SecondsPerSlot), nil
+}
+
+// Returns the validators at the slot whose withdrawal credentials point at one of the addresses. Only the validators
+// in ids (indices or pubkeys) are read, as the full validator set is too large to download per request.
+func Validators(ctx context.Context, addresses []string, ids []string, slot int) ([]Validator, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("validator indices or pubkeys are required")
+	}
+
+	owners := map[string]bool{}
+	for _, address := range addresses {
+		owners[strings.ToLower(address)] = true
+	}
+
+	var stateRoot struct {
+		Data struct {
+			Root string `json:"root"`
+		} `json:"data"`
+	}
+
+	err := get(ctx, fmt.Sprintf("/eth/v1/beacon/states/%v/root", slot), &stateRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	path := fmt.Sprintf("/eth/v1/beacon/states/%v/validators?id=%v", slot, url.QueryEscape(strings.Join(ids, ",")))
+
+	var validators struct {
+		Data []validatorResponse `json:"data"`
+	}
+
+	err = get(ctx, path, &validators)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := pendingWithdrawals(ctx, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Validator{}
+
+	for _, v := range validators.Data {
+		credentials := strings.ToLower(v.Validator.WithdrawalCredentials)
+		if len(credentials) != 66 || (!strings.HasPrefix(credentials, eth1Prefix) && !strings.HasPrefix(credentials, compoundingPrefix)) {
+			// BLS (0x00) credentials cannot be tied to an execution address
+			continue
+		}
+
+		withdrawalAddress := "0x" + credentials[len(credentials)-40:]
+		if !owners[withdrawalAddress] {
+			continue
+		}
+
+		balance, err := strconv.ParseFloat(v.Balance, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid balance for validator %v: %v", v.Index, err)
+		}
+
+		effective, err := strconv.ParseFloat(v.Validator.EffectiveBalance, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid effective balance for validator %v: %v", v.Index, err)
+		}
+
+		results = append(results, Validator{
+			Index:                 v.Index,
+			Pubkey:                v.Validator.Pubkey,
+			WithdrawalAddress:     withdrawalAddress,
+			Status:                v.Status,
+			Balance:               balance / gweiPerEth,
+			EffectiveBalance:      effective / gweiPerEth,
+			PendingWithdrawals:    pending[v.Index] / gweiPerEth,
+			WithdrawableEpoch:     v.Validator.WithdrawableEpoch,
+			Slot:                  slot,
+			StateRoot:             stateRoot.Data.Root,
+			WithdrawalCredentials: v.Validator.WithdrawalCredentials,
+		})
+	}
+
+	return results, nil
+}
+
+// Returns queued partial withdrawals in gwei keyed by validator index. Empty before the Electra fork.
+func pendingWithdrawals(ctx context.Context, slot int) (map[string]float64, error) {
+	var withdrawals struct {
+		Data []struct {
+			ValidatorIndex string `json:"validator_index"`
+			Amount         string `json:"amount"`
+		} `json:"data"`
+	}
+
+	pending := map[string]float64{}
+
+	err := get(ctx, fmt.Sprintf("/eth/v1/beacon/states/%v/pending_partial_withdrawals", slot), &withdrawals)
+	if errors.Is(err, errNotFound) {
+		return pending, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, w := range withdrawals.Data {
+		amount, err := strconv.ParseFloat(w.Amount, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pending withdrawal for validator %v: %v", w.ValidatorIndex, err)
+		}
+
+		pending[w.ValidatorIndex] += amount
+	}
+
+	return pending, nil
+}
+
+var errNotFound = errors.New("not found")
+
+// Performs a GET request against the configured Beacon API and decodes the JSON response.
+func get(ctx context.Context, path string, v interface{}) error {
+	if initialisers.BEACONURL == "" {
+		return errors.New("no beacon api configured (BEACON_API_URL)")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimRight(initialisers.BEACONURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := recorder.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return faults.Response("beacon api", resp.StatusCode, body)
+	}
+
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,90 @@
+package beacon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+)
+
+// Serves a beacon node holding two validators, one withdrawing to 0x…aa and one with BLS credentials.
+func node(t *testing.T, queries *[]string) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/root"):
+			w.Write([]byte(`{"data":{"root":"0xroot"}}`))
+		case strings.HasSuffix(r.URL.Path, "/validators"):
+			*queries = append(*queries, r.URL.RawQuery)
+			w.Write([]byte(`{"data":[
+				{"index":"1","balance":"32000000000","status":"active_ongoing","validator":{"pubkey":"0x01","withdrawal_credentials":"0x010000000000000000000000aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa","effective_balance":"32000000000","withdrawable_epoch":"0"}},
+				{"index":"2","balance":"32000000000","status":"active_ongoing","validator":{"pubkey":"0x02","withdrawal_credentials":"0x00aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa","effective_balance":"32000000000","withdrawable_epoch":"0"}}
+			]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	initialisers.BEACONURL = server.URL
+}
+
+func TestValidators(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     []string
+		query   string
+		indices []string
+		err     string
+	}{
+		{"no ids", nil, "", nil, "are required"},
+		{"indices", []string{"1", "2"}, "id=1%2C2", []string{"1"}, ""},
+		{"ids escaped", []string{"1&id=3"}, "id=1%26id%3D3", []string{"1"}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var queries []string
+			node(t, &queries)
+
+			validators, err := Validators(context.Background(), []string{"0xAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}, test.ids, 100)
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Validators() error = %v, want %q", err, test.err)
+				}
+
+				if len(queries) != 0 {
+					t.Errorf("validators were read without ids: %v", queries)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(queries) != 1 || queries[0] != test.query {
+				t.Errorf("query = %v, want %q", queries, test.query)
+			}
+
+			var indices []string
+			for _, v := range validators {
+				indices = append(indices, v.Index)
+			}
+
+			if strings.Join(indices, ",") != strings.Join(test.indices, ",") {
+				t.Errorf("validators = %v, want %v", indices, test.indices)
+			}
+
+			if validators[0].Balance != 32 || validators[0].StateRoot != "0xroot" || validators[0].Slot != 100 {
+				t.Errorf("validator = %+v", validators[0])
+			}
+		})
+	}
+}
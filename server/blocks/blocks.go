@@ -1,16 +1,25 @@
 package blocks
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
 	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/moralis"
+	"github.com/harrisandtrotter/proof-of-balance/server/recorder"
 )
 
+// Provider blocks are resolved with
+const Provider = "moralis"
+
 type Block struct {
 	Date           string `json:"date"`
 	Block          int    `json:"block"`
@@ -21,77 +30,132 @@ type Block struct {
 }
 
 // Accesses the Block struct and returns the block number.
-func (b *Block) BlockNumber(chain, timestamp string) int {
-	block := b.RetrieveBlock(chain, timestamp)
+func (b *Block) BlockNumber(ctx context.Context, chain, timestamp string) (int, error) {
+	block, err := b.RetrieveBlock(ctx, chain, timestamp)
+	if err != nil {
+		return 0, err
+	}
 
-	return block.Block
+	return block.Block, nil
 }
 
 // Queries Moralis API to return Block struct. Takes "chain" and "timestamp" variable.
-func (b *Block) RetrieveBlock(chain string, timestamp string) Block {
-	var blockchain string
-	var url string
-
-	if chain == "eth" || chain == "ethereum" || chain == "Ethereum" || chain == "ETH" || chain == "Eth" {
-		blockchain = "eth"
-	} else if chain == "polygon" || chain == "matic" || chain == "Polygon" || chain == "MATIC" {
-		blockchain = "polygon"
-	} else if chain == "arbitrum" || chain == "Arbitrum" || chain == "arb" {
-		blockchain = "arbitrum"
-	} else if chain == "bsc" || chain == "binance" || chain == "binance smart chain" || chain == "bnb chain" || chain == "bnb" || chain == "BNB" || chain == "Binance Smart Chain" || chain == "BSC" {
-		blockchain = "bsc"
-	} else if chain == "ftm" || chain == "fantom" || chain == "FTM" || chain == "Fantom" {
-		blockchain = "fantom"
-	} else if chain == "cro" || chain == "CRO" || chain == "cronos" || chain == "Cronos" {
-		blockchain = "cronos"
-	} else if chain == "avax" || chain == "avalanche" || chain == "AVAX" {
-		blockchain = "avalanche"
-	} else {
-		fmt.Println("\nBlockchain not supported. Please use on the supported chains:\nEthereum\nArbitrum\nPolygon\nBinance Smart Chain\nFantom\nAvalanche")
-		time.Sleep(time.Second * 3)
+func (b *Block) RetrieveBlock(ctx context.Context, chain string, timestamp string) (Block, error) {
+	utc, err := time.Parse("2006-01-02 15:04:05", timestamp)
+	if err != nil {
+		return Block{}, fmt.Errorf("error parsing timestamp: %v", err)
 	}
 
-	url = fmt.Sprintf("https://deep-index.moralis.io/api/v2/dateToBlock?chain=%v&date=%v", blockchain, b.TimestampToUnix(timestamp))
+	return b.Lookup(ctx, chain, utc)
+}
+
+// Block resolved for a chain at a requested time
+type Resolution struct {
+	Chain          string        `json:"chain"`
+	At             string        `json:"at"`
+	Block          int           `json:"block"`
+	Hash           string        `json:"hash"`
+	BlockTimestamp string        `json:"block_timestamp"`
+	Provider       string        `json:"provider"`
+	Error          *faults.Error `json:"error,omitempty"`
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+// Returns the block at or before the time on the chain, with errors returned rather than printed.
+func (b *Block) Lookup(ctx context.Context, chain string, at time.Time) (Block, error) {
+	blockchain, err := models.DetermineChain(chain)
 	if err != nil {
-		fmt.Printf("Error creating GET request to retrieve block: %v.", err)
+		return Block{}, err
+	}
+
+	url := fmt.Sprintf("%v/dateToBlock?chain=%v&date=%v", moralis.API, blockchain, at.Unix())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return Block{}, err
 	}
 
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("X-API-Key", initialisers.APIKEY)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := recorder.Client.Do(req)
 	if err != nil {
-		fmt.Printf("Error performing GET request to retrieve block: %v.", err)
-
+		return Block{}, err
 	}
 
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("Error parsing retrieve block response body: %v.", err)
+		return Block{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return Block{}, faults.Response(Provider, resp.StatusCode, body)
 	}
 
 	var block Block
 
 	err = json.Unmarshal(body, &block)
 	if err != nil {
-		fmt.Printf("Error unmarshalling block body: %v", err)
+		return Block{}, faults.New(faults.ProviderInvalidResponse, faults.StepBlock, err)
+	}
+
+	if block.Block == 0 {
+		return Block{}, faults.Newf(faults.BlockNotFound, faults.StepBlock, "no block found on %v at %v", blockchain, at.Format(time.RFC3339))
 	}
 
-	return block
+	return block, nil
 }
 
-// Used to convert "timestamp" variable to unix format. Takes "timestamp" in "31/12/2022 23:00:00" format.
-func (b *Block) TimestampToUnix(timestamp string) string {
-	utc, err := time.Parse("2006-01-02 15:04:05 UTC", timestamp+" UTC")
-	if err != nil {
-		fmt.Printf("Error parsing UTC timestamp: %v", err)
+// Resolves every time on every chain. Failures are reported on the resolution rather than aborting the others.
+func (b *Block) Resolve(ctx context.Context, chains []string, ats []time.Time) []Resolution {
+	resolutions := []Resolution{}
+
+	for _, chain := range chains {
+		for _, at := range ats {
+			resolution := Resolution{Chain: chain, At: at.UTC().Format(time.RFC3339), Provider: Provider}
+
+			if blockchain, err := models.DetermineChain(chain); err == nil {
+				resolution.Chain = blockchain
+			}
+
+			block, err := b.Lookup(ctx, chain, at)
+			if err != nil {
+				resolution.Error = faults.Wrap(faults.ProviderFailed, faults.StepBlock, err).For("", chain)
+			} else {
+				resolution.Block = block.Block
+				resolution.Hash = block.Hash
+				resolution.BlockTimestamp = block.BlockTimestamp
+			}
+
+			resolutions = append(resolutions, resolution)
+		}
+	}
+
+	return resolutions
+}
+
+// Parses comma separated RFC 3339 timestamps.
+func ParseTimes(list string) ([]time.Time, error) {
+	var times []time.Time
+
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q: expected RFC 3339, e.g. 2024-03-31T23:59:59Z", value)
+		}
+
+		times = append(times, at)
 	}
 
-	unix := utc.Unix()
+	if len(times) == 0 {
+		return nil, errors.New("no timestamps given")
+	}
 
-	return strconv.Itoa(int(unix))
+	return times, nil
 }
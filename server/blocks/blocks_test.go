@@ -0,0 +1,76 @@
+package blocks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/moralis"
+)
+
+func TestResolve(t *testing.T) {
+	saved := moralis.API
+	t.Cleanup(func() { moralis.API = saved })
+
+	// eth resolves, bsc fails at Moralis and polygon has no block yet
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("chain") {
+		case "eth":
+			w.Write([]byte(`{"date":"2024-03-31T23:59:59Z","block":19550000,"timestamp":1711929599,"block_timestamp":"2024-03-31T23:59:47Z","hash":"0xabc"}`))
+		case "bsc":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"message":"Internal server error"}`))
+		default:
+			w.Write([]byte(`{"block":0}`))
+		}
+	}))
+	defer server.Close()
+	moralis.API = server.URL
+
+	ats := []time.Time{time.Date(2024, 3, 31, 23, 59, 59, 0, time.UTC), time.Date(2024, 4, 1, 1, 59, 59, 0, time.FixedZone("CEST", 2*60*60))}
+
+	var b Block
+	resolutions := b.Resolve(context.Background(), []string{"ethereum", "bsc", "polygon", "solana"}, ats)
+
+	tests := []struct {
+		chain string
+		block int
+		// code of the error reported on the resolution, empty when it resolves
+		code string
+	}{
+		{"eth", 19550000, ""},
+		{"bsc", 0, faults.ProviderUnavailable},
+		{"polygon", 0, faults.BlockNotFound},
+		// chains that are not supported keep the name they were asked for
+		{"solana", 0, faults.ProviderFailed},
+	}
+
+	if len(resolutions) != len(tests)*len(ats) {
+		t.Fatalf("Resolve() = %+v, want every time on every chain", resolutions)
+	}
+
+	for i, test := range tests {
+		for j, at := range ats {
+			resolution := resolutions[i*len(ats)+j]
+
+			if resolution.Chain != test.chain || resolution.At != at.UTC().Format(time.RFC3339) || resolution.Block != test.block || resolution.Provider != Provider {
+				t.Errorf("resolution = %+v, want block %v on %v at %v", resolution, test.block, test.chain, at.UTC())
+			}
+
+			if test.code == "" {
+				if resolution.Error != nil || resolution.Hash != "0xabc" || resolution.BlockTimestamp != "2024-03-31T23:59:47Z" {
+					t.Errorf("resolution = %+v, want the block's hash and timestamp", resolution)
+				}
+
+				continue
+			}
+
+			if resolution.Error == nil || resolution.Error.Code != test.code || resolution.Error.Chain != test.chain {
+				t.Errorf("resolution of %v = %+v, want a %v error", test.chain, resolution.Error, test.code)
+			}
+		}
+	}
+}
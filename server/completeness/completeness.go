@@ -0,0 +1,308 @@
+package completeness
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+const (
+	// Token standards identified from transfer logs
+	ERC20   = "erc20"
+	ERC721  = "erc721"
+	ERC1155 = "erc1155"
+
+	// Most blocks per eth_getLogs query. Halved whenever a node rejects a range as too large, and doubled back
+	// towards this after each range that succeeds.
+	DefaultRange = 50000
+)
+
+var (
+	transferTopic       = rpc.Topic("Transfer(address,address,uint256)")
+	transferSingleTopic = rpc.Topic("TransferSingle(address,address,address,uint256,uint256)")
+	transferBatchTopic  = rpc.Topic("TransferBatch(address,address,address,uint256[],uint256[])")
+)
+
+// Non-zero holding found by scanning transfer logs
+type Holding struct {
+	ContractAddress string `json:"contract_address"`
+	Standard        string `json:"standard"`
+	TokenID         string `json:"token_id,omitempty"`
+	Balance         string `json:"balance"`
+	Error           string `json:"error,omitempty"`
+}
+
+// Result of the independent completeness check
+type Report struct {
+	Address          string    `json:"account_address"`
+	Chain            string    `json:"chain"`
+	FromBlock        int       `json:"from_block"`
+	BlockNumber      int       `json:"block_number"`
+	ContractsScanned int       `json:"contracts_scanned"`
+	Holdings         []Holding `json:"holdings"`
+	Missed           []Holding `json:"missed"`
+	Unverified       []Holding `json:"unverified"`
+}
+
+// Contract interacted with, and for ERC1155 the token ids seen
+type contract struct {
+	standard string
+	ids      map[string]*big.Int
+}
+
+// Returns the quota units a scan from fromBlock to the cut-off block costs, one for each DefaultRange blocks, or an
+// error when the range is empty or longer than COMPLETENESS_MAX_BLOCKS.
+func Cost(fromBlock, block int) (int, error) {
+	if fromBlock < 0 || fromBlock > block {
+		return 0, fmt.Errorf("from_block must be between 0 and the cut-off block %v", block)
+	}
+
+	blocks := block - fromBlock + 1
+	if initialisers.COMPLETENESSMAXBLOCKS > 0 && blocks > initialisers.COMPLETENESSMAXBLOCKS {
+		return 0, fmt.Errorf("a completeness check scans at most %v blocks, from block %v to the cut-off block %v is %v", initialisers.COMPLETENESSMAXBLOCKS, fromBlock, block, blocks)
+	}
+
+	return (blocks + DefaultRange - 1) / DefaultRange, nil
+}
+
+// Scans every ERC20, ERC721 and ERC1155 transfer involving the address between fromBlock and the cut-off block,
+// queries the balance of each contract at the cut-off and reports non-zero holdings missing from known.
+// Known holds the contract addresses the primary provider reported. The check stops when ctx is done.
+func Check(ctx context.Context, address, chain string, fromBlock, block int, known []string) (Report, error) {
+	report := Report{
+		Address:     address,
+		Chain:       chain,
+		FromBlock:   fromBlock,
+		BlockNumber: block,
+		Holdings:    []Holding{},
+		Missed:      []Holding{},
+		Unverified:  []Holding{},
+	}
+
+	if _, err := Cost(fromBlock, block); err != nil {
+		return report, err
+	}
+
+	contracts, err := scan(ctx, address, chain, fromBlock, block)
+	if err != nil {
+		return report, err
+	}
+
+	report.ContractsScanned = len(contracts)
+
+	reported := map[string]bool{}
+	for _, k := range known {
+		reported[strings.ToLower(k)] = true
+	}
+
+	addresses := make([]string, 0, len(contracts))
+	for a := range contracts {
+		addresses = append(addresses, a)
+	}
+	sort.Strings(addresses)
+
+	for _, contractAddress := range addresses {
+		holdings, err := balances(ctx, address, chain, block, contractAddress, contracts[contractAddress])
+
+		// a read cut short says nothing about the contract
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+
+		if err != nil {
+			// e.g. a contract that reverts balanceOf or did not exist yet at the cut-off
+			report.Unverified = append(report.Unverified, Holding{
+				ContractAddress: contractAddress,
+				Standard:        contracts[contractAddress].standard,
+				Error:           err.Error(),
+			})
+			continue
+		}
+
+		for _, holding := range holdings {
+			report.Holdings = append(report.Holdings, holding)
+
+			if !reported[contractAddress] {
+				report.Missed = append(report.Missed, holding)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// Returns every contract that emitted a transfer to or from the address.
+func scan(ctx context.Context, address, chain string, fromBlock, toBlock int) (map[string]*contract, error) {
+	topic := rpc.AddressTopic(address)
+	batch := []string{transferSingleTopic, transferBatchTopic}
+
+	// Sender and recipient sit in different topic positions for ERC1155
+	filters := [][]interface{}{
+		{transferTopic, topic},
+		{transferTopic, nil, topic},
+		{batch, nil, topic},
+		{batch, nil, nil, topic},
+	}
+
+	contracts := map[string]*contract{}
+	step := DefaultRange
+
+	for start := fromBlock; start <= toBlock; {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		end := start + step - 1
+		if end > toBlock {
+			end = toBlock
+		}
+
+		var logs []rpc.Log
+		var err error
+
+		for _, topics := range filters {
+			var found []rpc.Log
+
+			found, err = rpc.GetLogs(ctx, chain, rpc.LogFilter{FromBlock: start, ToBlock: end, Topics: topics})
+			if err != nil {
+				break
+			}
+
+			logs = append(logs, found...)
+		}
+
+		// other failures have already been retried by the scheduler, so a smaller range would not help
+		if err != nil {
+			if !oversized(err) || step == 1 {
+				return nil, err
+			}
+
+			step /= 2
+			continue
+		}
+
+		for _, log := range logs {
+			record(contracts, log)
+		}
+
+		start = end + 1
+
+		// a busy stretch of blocks says nothing about the ones after it
+		if step < DefaultRange {
+			step *= 2
+			if step > DefaultRange {
+				step = DefaultRange
+			}
+		}
+	}
+
+	return contracts, nil
+}
+
+// Phrases nodes use when an eth_getLogs range spans too many blocks or matches too many logs
+var rangeLimits = []string{
+	"range too large", "range is too large", "range is too wide", "block range", "too many results",
+	"more than 10000 results", "response size exceeded", "log response size", "query timeout exceeded",
+}
+
+// Reports whether the node rejected a query for covering too much, rather than failing.
+func oversized(err error) bool {
+	message := strings.ToLower(err.Error())
+
+	for _, phrase := range rangeLimits {
+		if strings.Contains(message, phrase) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Adds the log's contract to the set, classifying the standard and collecting ERC1155 ids.
+func record(contracts map[string]*contract, log rpc.Log) {
+	contractAddress := strings.ToLower(log.Address)
+
+	c, ok := contracts[contractAddress]
+	if !ok {
+		c = &contract{ids: map[string]*big.Int{}}
+		contracts[contractAddress] = c
+	}
+
+	switch log.Topics[0] {
+	case transferTopic:
+		// ERC721 indexes the token id, ERC20 leaves the amount in data
+		if len(log.Topics) == 4 {
+			c.standard = ERC721
+		} else if c.standard == "" {
+			c.standard = ERC20
+		}
+	case transferSingleTopic:
+		c.standard = ERC1155
+		if id, err := rpc.DecodeUintAt(log.Data, 0); err == nil {
+			c.ids[id.String()] = id
+		}
+	case transferBatchTopic:
+		c.standard = ERC1155
+		if ids, err := rpc.DecodeUintArray(log.Data, 0); err == nil {
+			for _, id := range ids {
+				c.ids[id.String()] = id
+			}
+		}
+	}
+}
+
+// Queries the address's balance of the contract at the block and returns the non-zero holdings.
+func balances(ctx context.Context, address, chain string, block int, contractAddress string, c *contract) ([]Holding, error) {
+	var holdings []Holding
+
+	if c.standard == ERC1155 {
+		ids := make([]string, 0, len(c.ids))
+		for id := range c.ids {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			data := rpc.EncodeCall("balanceOf(address,uint256)", rpc.EncodeAddress(address), rpc.EncodeUint(c.ids[id]))
+
+			result, err := rpc.EthCall(ctx, chain, contractAddress, data, block)
+			if err != nil {
+				return nil, err
+			}
+
+			balance, err := rpc.DecodeUintAt(result, 0)
+			if err != nil {
+				return nil, err
+			}
+
+			if balance.Sign() > 0 {
+				holdings = append(holdings, Holding{ContractAddress: contractAddress, Standard: ERC1155, TokenID: id, Balance: balance.String()})
+			}
+		}
+
+		return holdings, nil
+	}
+
+	data := rpc.EncodeCall("balanceOf(address)", rpc.EncodeAddress(address))
+
+	result, err := rpc.EthCall(ctx, chain, contractAddress, data, block)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := rpc.DecodeUintAt(result, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if balance.Sign() > 0 {
+		holdings = append(holdings, Holding{ContractAddress: contractAddress, Standard: c.standard, Balance: balance.String()})
+	}
+
+	return holdings, nil
+}
@@ -0,0 +1,132 @@
+package completeness
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc/rpctest"
+)
+
+func TestCost(t *testing.T) {
+	saved := initialisers.COMPLETENESSMAXBLOCKS
+	t.Cleanup(func() { initialisers.COMPLETENESSMAXBLOCKS = saved })
+
+	tests := []struct {
+		name             string
+		max              int
+		fromBlock, block int
+		units            int
+		err              bool
+	}{
+		{"one block", 1000, 100, 100, 1, false},
+		{"one query", 100000, 0, DefaultRange - 1, 1, false},
+		{"two queries", 100000, 0, DefaultRange, 2, false},
+		{"at the cap", 100000, 1, 100000, 2, false},
+		{"over the cap", 100000, 0, 100000, 0, true},
+		{"no cap", 0, 0, 19999999, 400, false},
+		{"from after the cut-off", 1000, 101, 100, 0, true},
+		{"negative from", 1000, -1, 100, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			initialisers.COMPLETENESSMAXBLOCKS = test.max
+
+			units, err := Cost(test.fromBlock, test.block)
+
+			if test.err {
+				if err == nil {
+					t.Fatalf("Cost(%v, %v) = %v, want an error", test.fromBlock, test.block, units)
+				}
+
+				return
+			}
+
+			if err != nil || units != test.units {
+				t.Errorf("Cost(%v, %v) = %v, %v, want %v", test.fromBlock, test.block, units, err, test.units)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	holder := "0x" + strings.Repeat("1", 40)
+	fungible, collectible := "0x"+strings.Repeat("2", 40), "0x"+strings.Repeat("3", 40)
+	sender := rpc.AddressTopic("0x" + strings.Repeat("4", 40))
+
+	node := rpctest.New(t, "eth")
+
+	// every filter is answered with both transfers, as a contract is recorded once however often it is seen
+	node.Method("eth_getLogs", []rpc.Log{
+		{Address: fungible, Topics: []string{transferTopic, sender, rpc.AddressTopic(holder)}, Data: rpc.EncodeUint(big.NewInt(5))},
+		{Address: collectible, Topics: []string{transferTopic, sender, rpc.AddressTopic(holder), rpc.EncodeUint(big.NewInt(7))}},
+	})
+	node.Return(fungible, "balanceOf(address)", nil, rpc.EncodeUint(big.NewInt(5)))
+
+	tests := []struct {
+		name       string
+		known      []string
+		missed     int
+		unverified int
+	}{
+		{"token not reported", nil, 1, 1},
+		{"token reported", []string{fungible}, 0, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report, err := Check(context.Background(), holder, "eth", 100, 200, test.known)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if report.ContractsScanned != 2 || len(report.Holdings) != 1 || report.Holdings[0].Balance != "5" || report.Holdings[0].Standard != ERC20 {
+				t.Errorf("Check() = %+v, want one ERC20 holding of 5 from 2 contracts", report)
+			}
+
+			// the ERC721 contract reverts balanceOf on the node
+			if len(report.Missed) != test.missed || len(report.Unverified) != test.unverified || report.Unverified[0].Standard != ERC721 {
+				t.Errorf("Check() missed %+v and left %+v unverified, want %v and %v", report.Missed, report.Unverified, test.missed, test.unverified)
+			}
+		})
+	}
+}
+
+func TestCheckStops(t *testing.T) {
+	saved := initialisers.COMPLETENESSMAXBLOCKS
+	t.Cleanup(func() { initialisers.COMPLETENESSMAXBLOCKS = saved })
+	initialisers.COMPLETENESSMAXBLOCKS = 1000
+
+	holder := "0x" + strings.Repeat("1", 40)
+
+	node := rpctest.New(t, "eth")
+	node.Method("eth_getLogs", []rpc.Log{})
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name             string
+		ctx              context.Context
+		fromBlock, block int
+		err              error
+	}{
+		{"cancelled", cancelled, 100, 200, context.Canceled},
+		{"range over the cap", context.Background(), 0, 1000, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report, err := Check(test.ctx, holder, "eth", test.fromBlock, test.block, nil)
+
+			if err == nil || test.err != nil && !errors.Is(err, test.err) {
+				t.Errorf("Check() = %+v, %v, want an error", report, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,587 @@
+package confirmation
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/blocks"
+	"github.com/harrisandtrotter/proof-of-balance/server/runs"
+)
+
+// Name of the signed data attached to the PDF, and the signature algorithm
+const (
+	AttachmentName = "confirmation.json"
+	Algorithm      = "ed25519"
+)
+
+// Content of a confirmation. The PDF is rendered from this alone, so it can be re-rendered to check the pages.
+type Data struct {
+	Client      string    `json:"client"`
+	PeriodEnd   string    `json:"period_end"`
+	Prepared    time.Time `json:"prepared"`
+	Runs        []string  `json:"runs"`
+	Blocks      []Block   `json:"blocks"`
+	Assets      []Line    `json:"assets"`
+	Liabilities []Line    `json:"liabilities"`
+	Excluded    []Line    `json:"excluded"`
+	Template    Template  `json:"template"`
+}
+
+// Block a wallet's balances were read at
+type Block struct {
+	Chain     string `json:"chain"`
+	Wallet    string `json:"wallet"`
+	Number    int    `json:"block_number"`
+	Hash      string `json:"block_hash"`
+	Timestamp string `json:"block_timestamp"`
+	Provider  string `json:"provider"`
+	Run       string `json:"run_id"`
+}
+
+// Asset, liability or excluded item
+type Line struct {
+	Chain    string   `json:"chain"`
+	Wallet   string   `json:"wallet"`
+	Symbol   string   `json:"asset_symbol"`
+	Name     string   `json:"asset_name"`
+	Contract string   `json:"contract_address"`
+	Quantity float64  `json:"quantity"`
+	UsdPrice float64  `json:"usd_price,omitempty"`
+	UsdValue float64  `json:"usd_value,omitempty"`
+	Priced   bool     `json:"priced"`
+	Reasons  []string `json:"spam_reasons,omitempty"`
+}
+
+// Signed data as attached to the PDF
+type envelope struct {
+	Data      json.RawMessage `json:"data"`
+	Algorithm string          `json:"algorithm"`
+	PublicKey string          `json:"public_key"`
+	Signature string          `json:"signature"`
+}
+
+// Builds the confirmation content from stored runs. The runs must share a cut-off.
+func New(stored []*runs.Run, client string, wording Template) (*Data, error) {
+	if len(stored) == 0 {
+		return nil, errors.New("no runs to confirm")
+	}
+
+	if client == "" {
+		return nil, errors.New("client is required")
+	}
+
+	first := stored[0].Request
+
+	periodEnd, err := time.Parse("02/01/2006 15:04:05", first.Date+" "+first.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cut-off on run %v: %v", stored[0].ID, err)
+	}
+
+	data := &Data{
+		Client:      client,
+		PeriodEnd:   periodEnd.Format("2 January 2006 15:04:05") + " UTC",
+		Blocks:      []Block{},
+		Assets:      []Line{},
+		Liabilities: []Line{},
+		Excluded:    []Line{},
+		Template:    wording,
+	}
+
+	for _, run := range stored {
+		if run.Request.Date != first.Date || run.Request.Timestamp != first.Timestamp {
+			return nil, fmt.Errorf("run %v has a different cut-off from run %v", run.ID, stored[0].ID)
+		}
+
+		if run.CreatedAt.After(data.Prepared) {
+			data.Prepared = run.CreatedAt.UTC()
+		}
+
+		data.Runs = append(data.Runs, run.ID)
+
+		chain := run.Request.Chain
+		if len(run.Rows) > 0 {
+			chain = run.Rows[0].Chain
+		}
+
+		data.Blocks = append(data.Blocks, Block{
+			Chain:     chain,
+			Wallet:    run.Request.Address,
+			Number:    run.Block.Block,
+			Hash:      run.Block.Hash,
+			Timestamp: run.Block.BlockTimestamp,
+			Provider:  blocks.Provider,
+			Run:       run.ID,
+		})
+
+		for _, row := range run.Rows {
+			line := Line{
+				Chain:    row.Chain,
+				Wallet:   row.Address,
+				Symbol:   row.Asset,
+				Name:     row.AssetName,
+				Contract: row.AssetAddress,
+				Quantity: row.Balance,
+				UsdPrice: row.UsdPrice,
+				UsdValue: row.UsdValue,
+				Priced:   row.PriceSource != "",
+			}
+
+			switch {
+			case row.PossibleSpam:
+				line.Reasons = row.SpamReasons
+				data.Excluded = append(data.Excluded, line)
+			case row.Liability:
+				data.Liabilities = append(data.Liabilities, line)
+			default:
+				data.Assets = append(data.Assets, line)
+			}
+		}
+	}
+
+	for _, lines := range [][]Line{data.Assets, data.Liabilities, data.Excluded} {
+		sort.SliceStable(lines, func(i, j int) bool {
+			if lines[i].Chain != lines[j].Chain {
+				return lines[i].Chain < lines[j].Chain
+			}
+
+			return lines[i].UsdValue > lines[j].UsdValue
+		})
+	}
+
+	return data, nil
+}
+
+// Renders the confirmation as a PDF and signs the data it was rendered from with the key.
+func Render(data *Data, key ed25519.PrivateKey) ([]byte, error) {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := envelope{
+		Data:      content,
+		Algorithm: Algorithm,
+		PublicKey: hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Signature: hex.EncodeToString(ed25519.Sign(key, content)),
+	}
+
+	return render(data, signed)
+}
+
+// Checks the signature on the attached data and that the pages are exactly those the data renders to.
+// When trusted is nil the key in the attachment is used, so the caller must compare it with the firm's key.
+func Verify(document []byte, trusted ed25519.PublicKey) (*Data, string, error) {
+	attached, err := attachment(document)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var signed envelope
+
+	err = json.Unmarshal(attached, &signed)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading signed data: %v", err)
+	}
+
+	if signed.Algorithm != Algorithm {
+		return nil, "", fmt.Errorf("unsupported signature algorithm %q", signed.Algorithm)
+	}
+
+	key := trusted
+	if key == nil {
+		decoded, err := hex.DecodeString(signed.PublicKey)
+		if err != nil || len(decoded) != ed25519.PublicKeySize {
+			return nil, "", errors.New("invalid public key in signed data")
+		}
+
+		key = ed25519.PublicKey(decoded)
+	} else if signed.PublicKey != hex.EncodeToString(key) {
+		return nil, "", errors.New("confirmation was not signed with the trusted key")
+	}
+
+	signature, err := hex.DecodeString(signed.Signature)
+	if err != nil || !ed25519.Verify(key, signed.Data, signature) {
+		return nil, "", errors.New("signature is invalid")
+	}
+
+	var data Data
+
+	err = json.Unmarshal(signed.Data, &data)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading signed data: %v", err)
+	}
+
+	rendered, err := render(&data, signed)
+	if err != nil {
+		return nil, "", fmt.Errorf("error re-rendering confirmation: %v", err)
+	}
+
+	if !bytes.Equal(rendered, document) {
+		return nil, "", errors.New("pages do not match the signed data")
+	}
+
+	return &data, signed.PublicKey, nil
+}
+
+// Column of a table: its title, width in points and whether values are right aligned
+type column struct {
+	title string
+	width float64
+	right bool
+}
+
+// Table row: the cells and an optional second line in a fixed width font, e.g. a hash or contract address
+type tableRow struct {
+	cells  []string
+	detail string
+	bold   bool
+}
+
+// Page layout cursor: y is the baseline of the next line, from the bottom of the page
+type layout struct {
+	doc *pdf
+	y   float64
+}
+
+const (
+	bodySize   = 9.0
+	lineHeight = 12.0
+	footerSize = 7.5
+	// lowest baseline for content, leaving room for the footer
+	bottom = margin + 24
+)
+
+func (l *layout) newPage() {
+	l.doc.addPage()
+	l.y = pageHeight - margin
+}
+
+// Starts a new page unless there is room for the height.
+func (l *layout) ensure(height float64) {
+	if l.y-height < bottom {
+		l.newPage()
+	}
+}
+
+func (l *layout) paragraph(font string, size float64, text string) {
+	for _, line := range wrap(font, size, text, pageWidth-2*margin) {
+		l.ensure(size + 3)
+		l.doc.text(margin, l.y, font, size, line)
+		l.y -= size + 3
+	}
+}
+
+func (l *layout) heading(text string) {
+	// keep a heading with at least the first lines of what follows it
+	l.ensure(5 * lineHeight)
+	l.y -= 8
+	l.doc.text(margin, l.y, fontBold, 12, text)
+	l.y -= 6
+	l.doc.line(margin, l.y, pageWidth-margin, l.y, 0.5)
+	l.y -= lineHeight + 2
+}
+
+// Draws the table, repeating the header row on each page it continues onto.
+func (l *layout) table(columns []column, rows []tableRow) {
+	header := func() {
+		l.doc.shade(margin, l.y-3, pageWidth-2*margin, lineHeight+1)
+		l.cells(columns, titles(columns), fontBold)
+		l.y -= lineHeight + 2
+	}
+
+	l.ensure(3 * lineHeight)
+	header()
+
+	for _, row := range rows {
+		height := lineHeight
+		if row.detail != "" {
+			height += lineHeight - 2
+		}
+
+		if l.y-height < bottom {
+			l.newPage()
+			header()
+		}
+
+		font := fontRegular
+		if row.bold {
+			font = fontBold
+			l.doc.line(margin, l.y+lineHeight-3, pageWidth-margin, l.y+lineHeight-3, 0.5)
+		}
+
+		l.cells(columns, row.cells, font)
+		l.y -= lineHeight
+
+		if row.detail != "" {
+			l.doc.text(margin+columns[0].width, l.y+2, fontMono, 7, fit(fontMono, 7, row.detail, pageWidth-2*margin-columns[0].width))
+			l.y -= lineHeight - 2
+		}
+	}
+
+	l.y -= lineHeight / 2
+}
+
+func (l *layout) cells(columns []column, values []string, font string) {
+	x := margin
+
+	for i, c := range columns {
+		if i < len(values) {
+			value := fit(font, bodySize, values[i], c.width-4)
+			if c.right {
+				l.doc.textRight(x+c.width-2, l.y, font, bodySize, value)
+			} else {
+				l.doc.text(x, l.y, font, bodySize, value)
+			}
+		}
+
+		x += c.width
+	}
+}
+
+func titles(columns []column) []string {
+	var result []string
+	for _, c := range columns {
+		result = append(result, c.title)
+	}
+
+	return result
+}
+
+// Lays out every page from the data and the signature over it.
+func render(data *Data, signed envelope) ([]byte, error) {
+	wording := map[string]string{}
+
+	for name, text := range map[string]string{
+		"title":        data.Template.Title,
+		"introduction": data.Template.Introduction,
+		"sign_off":     data.Template.SignOff,
+		"footer":       data.Template.Footer,
+	} {
+		executed, err := execute(text, data)
+		if err != nil {
+			return nil, fmt.Errorf("error in template %v: %v", name, err)
+		}
+
+		wording[name] = executed
+	}
+
+	var methodology []string
+	for i, text := range data.Template.Methodology {
+		executed, err := execute(text, data)
+		if err != nil {
+			return nil, fmt.Errorf("error in template methodology %v: %v", i+1, err)
+		}
+
+		methodology = append(methodology, executed)
+	}
+
+	l := &layout{doc: &pdf{}}
+	l.newPage()
+
+	if data.Template.Firm != "" {
+		l.doc.text(margin, l.y, fontBold, 10, data.Template.Firm)
+		l.y -= 22
+	}
+
+	l.doc.text(margin, l.y, fontBold, 18, wording["title"])
+	l.y -= 26
+
+	for _, field := range [][2]string{
+		{"Client", data.Client},
+		{"Period end", data.PeriodEnd},
+		{"Prepared", data.Prepared.Format("2 January 2006 15:04:05") + " UTC"},
+		{"Runs", strings.Join(data.Runs, ", ")},
+	} {
+		l.doc.text(margin, l.y, fontBold, bodySize, field[0])
+		for i, line := range wrap(fontRegular, bodySize, field[1], pageWidth-2*margin-70) {
+			if i > 0 {
+				l.y -= lineHeight
+			}
+			l.doc.text(margin+70, l.y, fontRegular, bodySize, line)
+		}
+		l.y -= lineHeight
+	}
+
+	l.y -= 6
+	l.paragraph(fontRegular, bodySize, wording["introduction"])
+
+	l.heading("Block evidence")
+
+	var blockRows []tableRow
+	for _, block := range data.Blocks {
+		blockRows = append(blockRows, tableRow{
+			cells:  []string{block.Chain, shortAddress(block.Wallet), strconv.Itoa(block.Number), block.Timestamp, block.Provider},
+			detail: "Hash " + block.Hash,
+		})
+	}
+
+	l.table([]column{
+		{"Chain", 60, false}, {"Wallet", 100, false}, {"Block", 70, true}, {"Block timestamp", 185, false}, {"Provider", 80, false},
+	}, blockRows)
+
+	assetColumns := []column{
+		{"Chain", 60, false}, {"Wallet", 80, false}, {"Asset", 60, false}, {"Name", 105, false},
+		{"Quantity", 90, true}, {"USD price", 50, true}, {"USD value", 50, true},
+	}
+
+	assets := valuedTable(data.Assets, "Total assets")
+	liabilities := valuedTable(data.Liabilities, "Total liabilities")
+
+	l.heading("Assets")
+	l.table(assetColumns, assets.rows)
+
+	if len(data.Liabilities) > 0 {
+		l.heading("Liabilities")
+		l.table(assetColumns, liabilities.rows)
+
+		l.table([]column{{"", 445, false}, {"", 50, true}}, []tableRow{{cells: []string{"Net assets (USD)", usd(assets.total - liabilities.total)}, bold: true}})
+	}
+
+	if len(data.Excluded) > 0 {
+		l.heading("Excluded: possible spam")
+		l.paragraph(fontRegular, bodySize, "These items were held at the block but are flagged as possible spam and are excluded from the totals above.")
+		l.y -= 4
+
+		var excluded []tableRow
+		for _, line := range data.Excluded {
+			excluded = append(excluded, tableRow{
+				cells:  []string{line.Chain, shortAddress(line.Wallet), line.Symbol, line.Name, quantity(line.Quantity), strings.Join(line.Reasons, "; ")},
+				detail: contract(line.Contract),
+			})
+		}
+
+		l.table([]column{
+			{"Chain", 60, false}, {"Wallet", 80, false}, {"Asset", 60, false}, {"Name", 105, false}, {"Quantity", 90, true}, {"Reason", 100, false},
+		}, excluded)
+	}
+
+	if len(methodology) > 0 {
+		l.heading("Methodology")
+		for i, note := range methodology {
+			l.paragraph(fontRegular, bodySize, fmt.Sprintf("%d. %v", i+1, note))
+			l.y -= 3
+		}
+	}
+
+	digest := sha256.Sum256(signed.Data)
+
+	l.heading("Signature")
+	l.paragraph(fontRegular, bodySize, wording["sign_off"])
+	l.y -= 4
+
+	for _, field := range [][2]string{
+		{"Algorithm", "Ed25519"},
+		{"Data SHA-256", hex.EncodeToString(digest[:])},
+		{"Public key", signed.PublicKey},
+		{"Signature", signed.Signature[:len(signed.Signature)/2]},
+		{"", signed.Signature[len(signed.Signature)/2:]},
+	} {
+		l.ensure(lineHeight)
+		if field[0] != "" {
+			l.doc.text(margin, l.y, fontBold, bodySize, field[0])
+		}
+		l.doc.text(margin+80, l.y, fontMono, 7.5, field[1])
+		l.y -= lineHeight
+	}
+
+	// footers go on last, once the page count is known
+	for i := range l.doc.pages {
+		l.doc.page = i
+
+		l.doc.line(margin, margin+12, pageWidth-margin, margin+12, 0.5)
+		l.doc.text(margin, margin, fontRegular, footerSize, fit(fontRegular, footerSize, wording["footer"], pageWidth-2*margin-70))
+		l.doc.textRight(pageWidth-margin, margin, fontRegular, footerSize, fmt.Sprintf("Page %d of %d", i+1, len(l.doc.pages)))
+	}
+
+	attached, err := json.Marshal(signed)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.doc.bytes(wording["title"]+" - "+data.Client, AttachmentName, attached, data.Prepared)
+}
+
+// Table of valued lines with a total of the priced ones
+type valued struct {
+	rows  []tableRow
+	total float64
+}
+
+func valuedTable(lines []Line, totalLabel string) valued {
+	var result valued
+
+	for _, line := range lines {
+		price, value := "", ""
+		if line.Priced {
+			price, value = usd(line.UsdPrice), usd(line.UsdValue)
+			result.total += line.UsdValue
+		}
+
+		result.rows = append(result.rows, tableRow{
+			cells:  []string{line.Chain, shortAddress(line.Wallet), line.Symbol, line.Name, quantity(line.Quantity), price, value},
+			detail: contract(line.Contract),
+		})
+	}
+
+	result.rows = append(result.rows, tableRow{cells: []string{totalLabel + " (USD)", "", "", "", "", "", usd(result.total)}, bold: true})
+
+	return result
+}
+
+func contract(address string) string {
+	if !strings.HasPrefix(address, "0x") {
+		return ""
+	}
+
+	return "Contract " + address
+}
+
+func shortAddress(address string) string {
+	if len(address) <= 14 {
+		return address
+	}
+
+	return address[:8] + ".." + address[len(address)-4:]
+}
+
+func quantity(v float64) string {
+	return group(strconv.FormatFloat(v, 'f', 8, 64))
+}
+
+func usd(v float64) string {
+	return group(strconv.FormatFloat(v, 'f', 2, 64))
+}
+
+// Adds thousands separators to a formatted number.
+func group(formatted string) string {
+	sign := ""
+	if strings.HasPrefix(formatted, "-") {
+		sign, formatted = "-", formatted[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(formatted, ".")
+
+	var grouped []string
+	for len(whole) > 3 {
+		grouped = append([]string{whole[len(whole)-3:]}, grouped...)
+		whole = whole[:len(whole)-3]
+	}
+
+	grouped = append([]string{whole}, grouped...)
+
+	result := sign + strings.Join(grouped, ",")
+	if fraction != "" {
+		result += "." + fraction
+	}
+
+	return result
+}
@@ -0,0 +1,199 @@
+package confirmation
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/blocks"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/runs"
+)
+
+func testKey(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+}
+
+func testRun(id, date string) *runs.Run {
+	return &runs.Run{
+		ID:        id,
+		CreatedAt: time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC),
+		Request:   models.Request{Address: "0x57", Chain: "eth", Date: date, Timestamp: "23:59:59"},
+		Block:     blocks.Block{Block: 100, Hash: "0xabc"},
+		Rows: []models.ClientResponse{
+			{Address: "0x57", Chain: "eth", Asset: "ETH", AssetName: "Ethereum", AssetAddress: "N/A", Balance: 2, UsdPrice: 3000, UsdValue: 6000, PriceSource: "moralis"},
+			{Address: "0x57", Chain: "eth", Asset: "USDC", AssetName: "USD Coin", AssetAddress: "0xa0b8", Balance: 9000, UsdPrice: 1, UsdValue: 9000, PriceSource: "moralis"},
+			{Address: "0x57", Chain: "eth", Asset: "USDC", AssetName: "USDC debt", AssetAddress: "0xa0b8", Balance: 500, Liability: true},
+			{Address: "0x57", Chain: "eth", Asset: "FREE", AssetName: "Claim at free.xyz", AssetAddress: "0xbad", Balance: 1, PossibleSpam: true, SpamReasons: []string{"phishing"}},
+		},
+	}
+}
+
+func TestNew(t *testing.T) {
+	data, err := New([]*runs.Run{testRun("a", "31/03/2024")}, "Client Ltd", Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.PeriodEnd != "31 March 2024 23:59:59 UTC" || !data.Prepared.Equal(time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("period end %q, prepared %v", data.PeriodEnd, data.Prepared)
+	}
+
+	// assets are ordered by value within a chain
+	if len(data.Assets) != 2 || data.Assets[0].Symbol != "USDC" || data.Assets[1].Symbol != "ETH" {
+		t.Errorf("assets = %+v", data.Assets)
+	}
+
+	if len(data.Liabilities) != 1 || data.Liabilities[0].Priced {
+		t.Errorf("liabilities = %+v", data.Liabilities)
+	}
+
+	if len(data.Excluded) != 1 || data.Excluded[0].Reasons[0] != "phishing" {
+		t.Errorf("excluded = %+v", data.Excluded)
+	}
+
+	tests := []struct {
+		name   string
+		runs   []*runs.Run
+		client string
+		err    string
+	}{
+		{"no runs", nil, "Client Ltd", "no runs"},
+		{"no client", []*runs.Run{testRun("a", "31/03/2024")}, "", "client is required"},
+		{"different cut-offs", []*runs.Run{testRun("a", "31/03/2024"), testRun("b", "30/03/2024")}, "Client Ltd", "different cut-off"},
+		{"invalid cut-off", []*runs.Run{testRun("a", "2024-03-31")}, "Client Ltd", "invalid cut-off"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New(test.runs, test.client, Default)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("New() error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestRenderAndVerify(t *testing.T) {
+	key := testKey(1)
+
+	data, err := New([]*runs.Run{testRun("a", "31/03/2024")}, "Client Ltd", Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document, err := Render(data, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, _ := Render(data, key)
+	if !bytes.Equal(document, again) {
+		t.Error("renders of the same data differ")
+	}
+
+	if !bytes.HasPrefix(document, []byte("%PDF-1.7")) || !bytes.HasSuffix(document, []byte("%%EOF\n")) {
+		t.Error("document is not framed as a PDF")
+	}
+
+	forged, _ := Render(data, testKey(2))
+
+	tests := []struct {
+		name     string
+		document []byte
+		trusted  ed25519.PublicKey
+		err      string
+	}{
+		{"trusted key", document, key.Public().(ed25519.PublicKey), ""},
+		{"embedded key", document, nil, ""},
+		{"other key", forged, key.Public().(ed25519.PublicKey), "not signed with the trusted key"},
+		// the attached data is changed without re-signing it
+		{"altered data", bytes.Replace(document, []byte(`"client":"Client Ltd"`), []byte(`"client":"Client Ltc"`), 1), nil, "signature is invalid"},
+		// the pages are changed outside the attachment
+		{"altered pages", bytes.Replace(document, []byte("/Producer (proof-of-balance)"), []byte("/Producer (proof-of-balancf)"), 1), nil, "pages do not match"},
+		{"not a confirmation", []byte("%PDF-1.7\n"), nil, "no signed confirmation data"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verified, publicKey, err := Verify(test.document, test.trusted)
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Verify() error = %v, want %q", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if verified.Client != "Client Ltd" || len(verified.Assets) != 2 || !strings.HasPrefix(publicKey, "8a88e3dd") {
+				t.Errorf("Verify() = %+v, %v", verified, publicKey)
+			}
+		})
+	}
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name, got, want string
+	}{
+		{"encode escapes", encode(`a(b)c\`), `a\(b\)c\\`},
+		{"encode latin-1", encode("£5 café"), `\2435 caf\351`},
+		{"encode other", encode("Ξ→"), "??"},
+		{"group", group("1234567.50"), "1,234,567.50"},
+		{"group negative", group("-1234"), "-1,234"},
+		{"group small", group("999"), "999"},
+		{"usd", usd(1234.5), "1,234.50"},
+		{"quantity", quantity(0.5), "0.50000000"},
+		{"number", number(12.50), "12.5"},
+		{"number whole", number(595.0), "595"},
+		{"short address", shortAddress("0x574977cc4291Be87CabA68a767D542C16FA7c0DD"), "0x574977..c0DD"},
+		{"contract", contract("N/A"), ""},
+	}
+
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%v = %q, want %q", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	const width = 100
+
+	text := "The balances were read at the last block at or before the period end " + strings.Repeat("x", 60)
+
+	lines := wrap(fontRegular, bodySize, text, width)
+
+	if len(lines) < 3 {
+		t.Fatalf("wrap() = %q, want several lines", lines)
+	}
+
+	for _, line := range lines {
+		if textWidth(fontRegular, bodySize, line) > width {
+			t.Errorf("line %q is wider than %v", line, width)
+		}
+	}
+
+	if strings.ReplaceAll(strings.Join(lines, ""), " ", "") != strings.ReplaceAll(text, " ", "") {
+		t.Errorf("wrap() lost text: %q", lines)
+	}
+
+	if got := wrap(fontRegular, bodySize, "one\ntwo", width); len(got) != 2 {
+		t.Errorf("wrap() with a newline = %q, want two lines", got)
+	}
+
+	if got := fit(fontRegular, bodySize, strings.Repeat("W", 40), width); !strings.HasSuffix(got, "..") || textWidth(fontRegular, bodySize, got) > width {
+		t.Errorf("fit() = %q", got)
+	}
+
+	if got := fit(fontRegular, bodySize, "ETH", width); got != "ETH" {
+		t.Errorf("fit() = %q, want the text unchanged", got)
+	}
+}
@@ -0,0 +1,300 @@
+package confirmation
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A4 portrait, in points
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+	margin     = 50.0
+)
+
+// Font resource names. The standard fonts need no embedding.
+const (
+	fontRegular = "F1"
+	fontBold    = "F2"
+	fontMono    = "F3"
+)
+
+var baseFonts = []struct{ name, base string }{
+	{fontRegular, "Helvetica"},
+	{fontBold, "Helvetica-Bold"},
+	{fontMono, "Courier"},
+}
+
+// Widths in thousandths of the font size of the printable ASCII characters, from space to tilde
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+// Start of the dictionary of the attached file the signed data is written to
+const attachmentMarker = "/Type /EmbeddedFile /Subtype /application#2Fjson /Length "
+
+// Minimal PDF writer: pages of text, lines and shaded boxes in the standard fonts, plus one attached file
+// Drawing goes to the page at index page.
+type pdf struct {
+	pages []*bytes.Buffer
+	page  int
+}
+
+func (p *pdf) addPage() {
+	p.pages = append(p.pages, &bytes.Buffer{})
+	p.page = len(p.pages) - 1
+}
+
+func (p *pdf) current() *bytes.Buffer {
+	return p.pages[p.page]
+}
+
+// Writes the text with its baseline at (x, y), measured from the bottom left of the page.
+func (p *pdf) text(x, y float64, font string, size float64, s string) {
+	fmt.Fprintf(p.current(), "BT /%s %s Tf %s %s Td (%s) Tj ET\n", font, number(size), number(x), number(y), encode(s))
+}
+
+// Writes the text right aligned to x.
+func (p *pdf) textRight(x, y float64, font string, size float64, s string) {
+	p.text(x-textWidth(font, size, s), y, font, size, s)
+}
+
+func (p *pdf) line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(p.current(), "%s w %s %s m %s %s l S\n", number(width), number(x1), number(y1), number(x2), number(y2))
+}
+
+// Fills a light grey box with its bottom left corner at (x, y).
+func (p *pdf) shade(x, y, w, h float64) {
+	fmt.Fprintf(p.current(), "q 0.9 g %s %s %s %s re f Q\n", number(x), number(y), number(w), number(h))
+}
+
+// Serialises the document with the attachment embedded under the name. The creation date is fixed by the caller
+// so the same content always gives the same file.
+func (p *pdf) bytes(title, attachmentName string, attachment []byte, created time.Time) ([]byte, error) {
+	var out bytes.Buffer
+	var offsets []int
+
+	object := func(body string) int {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+
+		return len(offsets)
+	}
+
+	stream := func(dictionary string, data []byte) int {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n<< %s%d >>\nstream\n", len(offsets), dictionary, len(data))
+		out.Write(data)
+		out.WriteString("\nendstream\nendobj\n")
+
+		return len(offsets)
+	}
+
+	out.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+
+	// catalog and page tree are objects 1 and 2, the pages follow the fonts
+	fonts := 3
+	firstPage := fonts + len(baseFonts)
+	attachmentObject := firstPage + 2*len(p.pages)
+
+	object(fmt.Sprintf("<< /Type /Catalog /Pages 2 0 R /Names << /EmbeddedFiles << /Names [%s %d 0 R] >> >> /PageMode /UseAttachments >>",
+		literal(attachmentName), attachmentObject+1))
+
+	var kids []string
+	for i := range p.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPage+2*i))
+	}
+
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+
+	var fontRefs []string
+	for i, font := range baseFonts {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", font.base))
+		fontRefs = append(fontRefs, fmt.Sprintf("/%s %d 0 R", font.name, fonts+i))
+	}
+
+	for i, page := range p.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			number(pageWidth), number(pageHeight), strings.Join(fontRefs, " "), firstPage+2*i+1))
+
+		var compressed bytes.Buffer
+
+		writer := zlib.NewWriter(&compressed)
+		if _, err := writer.Write(page.Bytes()); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+
+		stream("/Filter /FlateDecode /Length ", compressed.Bytes())
+	}
+
+	// the attachment is left uncompressed so it can be read back without a PDF library
+	stream(attachmentMarker, attachment)
+	object(fmt.Sprintf("<< /Type /Filespec /F %s /UF %s /EF << /F %d 0 R >> >>", literal(attachmentName), literal(attachmentName), attachmentObject))
+
+	date := literal("D:" + created.UTC().Format("20060102150405") + "Z")
+	info := object(fmt.Sprintf("<< /Title %s /Producer (proof-of-balance) /CreationDate %s /ModDate %s >>", literal(title), date, date))
+
+	xref := out.Len()
+
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, info, xref)
+
+	return out.Bytes(), nil
+}
+
+// Returns the attached file written by bytes.
+func attachment(document []byte) ([]byte, error) {
+	start := bytes.Index(document, []byte(attachmentMarker))
+	if start < 0 {
+		return nil, errors.New("no signed confirmation data attached")
+	}
+
+	var length int
+
+	_, err := fmt.Sscanf(string(document[start+len(attachmentMarker):]), "%d", &length)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attachment length: %v", err)
+	}
+
+	offset := bytes.Index(document[start:], []byte("stream\n"))
+	if offset < 0 {
+		return nil, errors.New("attachment has no stream")
+	}
+
+	begin := start + offset + len("stream\n")
+	if length < 0 || begin+length > len(document) {
+		return nil, errors.New("attachment is truncated")
+	}
+
+	return document[begin : begin+length], nil
+}
+
+// Returns the width of the text in points.
+func textWidth(font string, size float64, s string) float64 {
+	total := 0
+
+	for _, r := range s {
+		switch {
+		case font == fontMono:
+			total += 600
+		case r >= ' ' && r <= '~' && font == fontBold:
+			total += helveticaBoldWidths[r-' ']
+		case r >= ' ' && r <= '~':
+			total += helveticaWidths[r-' ']
+		default:
+			total += 556
+		}
+	}
+
+	return float64(total) * size / 1000
+}
+
+// Shortens the text with ".." so it fits in the width.
+func fit(font string, size float64, s string, width float64) string {
+	if textWidth(font, size, s) <= width {
+		return s
+	}
+
+	runes := []rune(s)
+	for len(runes) > 0 && textWidth(font, size, string(runes)+"..") > width {
+		runes = runes[:len(runes)-1]
+	}
+
+	return string(runes) + ".."
+}
+
+// Breaks the text into lines no wider than the width, splitting words only when a word alone is too wide.
+func wrap(font string, size float64, s string, width float64) []string {
+	var lines []string
+
+	for _, paragraph := range strings.Split(s, "\n") {
+		line := ""
+
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+
+			if textWidth(font, size, candidate) <= width {
+				line = candidate
+				continue
+			}
+
+			if line != "" {
+				lines = append(lines, line)
+			}
+
+			runes := []rune(word)
+			for textWidth(font, size, string(runes)) > width {
+				cut := len(runes)
+				for cut > 1 && textWidth(font, size, string(runes[:cut])) > width {
+					cut--
+				}
+
+				lines = append(lines, string(runes[:cut]))
+				runes = runes[cut:]
+			}
+
+			line = string(runes)
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// Returns the text as a PDF string in WinAnsi encoding. Characters outside Latin-1 are replaced with "?".
+func encode(s string) string {
+	var buffer strings.Builder
+
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			buffer.WriteByte('\\')
+			buffer.WriteRune(r)
+		case r >= ' ' && r <= '~':
+			buffer.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&buffer, "\\%03o", r)
+		default:
+			buffer.WriteByte('?')
+		}
+	}
+
+	return buffer.String()
+}
+
+func literal(s string) string {
+	return "(" + encode(s) + ")"
+}
+
+func number(v float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", v), "0"), ".")
+}
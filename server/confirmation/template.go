@@ -0,0 +1,88 @@
+package confirmation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/template"
+)
+
+// Wording of a confirmation. Each field is a Go text/template executed with the confirmation's Data, e.g.
+// {{.Client}} or {{.PeriodEnd}}, so firms can reword the report without changing the figures it shows.
+type Template struct {
+	Firm         string   `json:"firm"`
+	Title        string   `json:"title"`
+	Introduction string   `json:"introduction"`
+	Methodology  []string `json:"methodology"`
+	SignOff      string   `json:"sign_off"`
+	Footer       string   `json:"footer"`
+}
+
+// Wording used for any field the firm's template leaves out
+var Default = Template{
+	Title:        "Balance confirmation",
+	Introduction: "This confirmation reports the digital assets held at the addresses below for {{.Client}} as at {{.PeriodEnd}}. Balances were read at the last block produced at or before the period end on each chain.",
+	Methodology: []string{
+		"For each chain the block is the last one with a timestamp at or before the period end. Its number and hash are listed under Block evidence and can be checked on any node or block explorer.",
+		"Native and token balances were read at that block. Quantities are whole units after applying each token's decimals.",
+		"Values are the quantity multiplied by the USD price at the same block, taken from a liquid on-chain pool or a manual override where one was recorded. Items without a price are shown without a value and are not included in the totals.",
+		"Items flagged as possible spam are listed separately and excluded from the totals.",
+		"Liabilities are amounts owed to lending protocols by the addresses and are shown separately from the assets.",
+		"The data this report was rendered from is attached to this PDF as confirmation.json and signed with the Ed25519 key below. Run \"pob verify\" on this file to check the signature and that the pages match the signed data.",
+	},
+	SignOff: "{{if .Template.Firm}}Prepared by {{.Template.Firm}}{{else}}Prepared with proof-of-balance{{end}} on {{.Prepared.Format \"2 January 2006\"}}.",
+	Footer:  "{{.Client}} - balance confirmation as at {{.PeriodEnd}}",
+}
+
+// Reads the template from the file, falling back to the default wording for fields it leaves out. A missing file
+// means the default template.
+func LoadTemplate(path string) (Template, error) {
+	result := Default
+	result.Methodology = nil
+
+	body, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || path == "" {
+		return Default, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("error reading confirmation template: %v", err)
+	}
+
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return result, fmt.Errorf("error parsing confirmation template: %v", err)
+	}
+
+	if result.Methodology == nil {
+		result.Methodology = Default.Methodology
+	}
+
+	for _, field := range [][]string{{result.Title, result.Introduction, result.SignOff, result.Footer}, result.Methodology} {
+		for _, text := range field {
+			if _, err := template.New("").Parse(text); err != nil {
+				return result, fmt.Errorf("error in confirmation template: %v", err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Executes one field of the template with the data.
+func execute(text string, data *Data) (string, error) {
+	parsed, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+
+	err = parsed.Execute(&buffer, data)
+	if err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
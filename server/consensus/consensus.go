@@ -0,0 +1,164 @@
+package consensus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+// Outcome of comparing the providers' balances
+const (
+	Unanimous = "unanimous"
+	// Enough providers agree but at least one reported a different balance or block
+	QuorumMet = "quorum met with disagreement"
+	NoQuorum  = "no quorum"
+)
+
+// Name recorded against balances read from Moralis
+const Moralis = "moralis"
+
+// Balances reported by each provider and the balance the quorum agreed on
+type Result struct {
+	Status string
+	// Raw balance agreed by the quorum, empty when no quorum was reached
+	Balance   string
+	Providers []models.ProviderBalance
+}
+
+// Compares Moralis's balance with the balance read from each configured JSON-RPC endpoint at the block.
+// Providers only agree when they report the same raw balance at the same block hash. An empty token address
+// means the chain's native balance.
+func Check(ctx context.Context, address, chain, tokenAddress, moralisBalance, moralisHash string, block int) Result {
+	providers := []models.ProviderBalance{{
+		Provider:  Moralis,
+		Balance:   moralisBalance,
+		BlockHash: strings.ToLower(moralisHash),
+	}}
+
+	var endpoints []string
+	if primary := initialisers.RPCURLS[chain]; primary != "" {
+		endpoints = append(endpoints, primary)
+	}
+
+	endpoints = append(endpoints, initialisers.CONSENSUSRPCURLS[chain]...)
+
+	for _, endpoint := range endpoints {
+		providers = append(providers, read(ctx, endpoint, address, tokenAddress, block))
+	}
+
+	return tally(providers)
+}
+
+// Reads the balance and the hash of the block it was read at from a single endpoint.
+func read(ctx context.Context, endpoint, address, tokenAddress string, block int) models.ProviderBalance {
+	provider := models.ProviderBalance{Provider: "rpc " + host(endpoint)}
+
+	var header struct {
+		Hash string `json:"hash"`
+	}
+
+	raw, err := rpc.CallURL(ctx, endpoint, "eth_getBlockByNumber", rpc.BlockTag(block), false)
+	if err == nil {
+		err = json.Unmarshal(raw, &header)
+	}
+	if err != nil {
+		provider.Error = fmt.Sprintf("error reading block %v: %v", block, err)
+		return provider
+	}
+
+	provider.BlockHash = strings.ToLower(header.Hash)
+
+	// pin the read to the block hash so a reorg between the two calls cannot mix blocks
+	at := map[string]string{"blockHash": header.Hash}
+
+	var result string
+
+	if tokenAddress == "" {
+		raw, err = rpc.CallURL(ctx, endpoint, "eth_getBalance", address, at)
+	} else {
+		call := map[string]string{"to": tokenAddress, "data": rpc.EncodeCall("balanceOf(address)", rpc.EncodeAddress(address))}
+		raw, err = rpc.CallURL(ctx, endpoint, "eth_call", call, at)
+	}
+	if err == nil {
+		err = json.Unmarshal(raw, &result)
+	}
+	if err != nil {
+		provider.Error = fmt.Sprintf("error reading balance: %v", err)
+		return provider
+	}
+
+	// calls to an address without code return no data
+	digits := strings.TrimPrefix(result, "0x")
+	if digits == "" {
+		digits = "0"
+	}
+
+	balance, ok := new(big.Int).SetString(digits, 16)
+	if !ok {
+		provider.Error = fmt.Sprintf("invalid balance %q", result)
+		return provider
+	}
+
+	provider.Balance = balance.String()
+
+	return provider
+}
+
+// Groups the providers that answered by balance and block hash and applies the quorum rule.
+func tally(providers []models.ProviderBalance) Result {
+	result := Result{Status: NoQuorum, Providers: providers}
+
+	votes := map[string]int{}
+	answered := 0
+	best := ""
+
+	for _, provider := range providers {
+		if provider.Error != "" {
+			continue
+		}
+
+		answered++
+		key := provider.Balance + "@" + provider.BlockHash
+		votes[key]++
+
+		if votes[key] > votes[best] {
+			best = key
+		}
+	}
+
+	if votes[best] < initialisers.QUORUM {
+		return result
+	}
+
+	// two groups of equal size reaching quorum is still a disagreement
+	for key, count := range votes {
+		if key != best && count == votes[best] {
+			return result
+		}
+	}
+
+	result.Balance = strings.SplitN(best, "@", 2)[0]
+	result.Status = QuorumMet
+	if votes[best] == answered && answered == len(providers) {
+		result.Status = Unanimous
+	}
+
+	return result
+}
+
+// Returns the endpoint's host so API keys in the path are not reported.
+func host(endpoint string) string {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Host == "" {
+		return "endpoint"
+	}
+
+	return parsed.Host
+}
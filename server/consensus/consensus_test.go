@@ -0,0 +1,71 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+)
+
+func TestTally(t *testing.T) {
+	saved := initialisers.QUORUM
+	t.Cleanup(func() { initialisers.QUORUM = saved })
+
+	agree := func(provider string) models.ProviderBalance {
+		return models.ProviderBalance{Provider: provider, Balance: "100", BlockHash: "0xaa"}
+	}
+
+	other := models.ProviderBalance{Provider: "rpc c", Balance: "99", BlockHash: "0xaa"}
+	reorged := models.ProviderBalance{Provider: "rpc c", Balance: "100", BlockHash: "0xbb"}
+	failed := models.ProviderBalance{Provider: "rpc c", Error: "timeout"}
+
+	tests := []struct {
+		name      string
+		quorum    int
+		providers []models.ProviderBalance
+		status    string
+		balance   string
+	}{
+		{"unanimous", 2, []models.ProviderBalance{agree(Moralis), agree("rpc a"), agree("rpc b")}, Unanimous, "100"},
+		{"one disagrees", 2, []models.ProviderBalance{agree(Moralis), agree("rpc a"), other}, QuorumMet, "100"},
+		{"same balance at another block", 2, []models.ProviderBalance{agree(Moralis), agree("rpc a"), reorged}, QuorumMet, "100"},
+		{"one failed", 2, []models.ProviderBalance{agree(Moralis), agree("rpc a"), failed}, QuorumMet, "100"},
+		{"quorum not reached", 3, []models.ProviderBalance{agree(Moralis), agree("rpc a"), other}, NoQuorum, ""},
+		{"tied groups", 1, []models.ProviderBalance{agree(Moralis), other}, NoQuorum, ""},
+		{"all failed", 1, []models.ProviderBalance{failed}, NoQuorum, ""},
+		{"moralis outvoted", 2, []models.ProviderBalance{{Provider: Moralis, Balance: "1", BlockHash: "0xaa"}, agree("rpc a"), agree("rpc b")}, QuorumMet, "100"},
+		{"moralis alone", 1, []models.ProviderBalance{agree(Moralis)}, Unanimous, "100"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			initialisers.QUORUM = test.quorum
+
+			result := tally(test.providers)
+
+			if result.Status != test.status || result.Balance != test.balance {
+				t.Errorf("tally() = %v, %q, want %v, %q", result.Status, result.Balance, test.status, test.balance)
+			}
+
+			if len(result.Providers) != len(test.providers) {
+				t.Errorf("tally() kept %v providers, want %v", len(result.Providers), len(test.providers))
+			}
+		})
+	}
+}
+
+func TestHost(t *testing.T) {
+	tests := []struct {
+		endpoint, want string
+	}{
+		{"https://eth-mainnet.example.com/v2/secret-key", "eth-mainnet.example.com"},
+		{"http://localhost:8545", "localhost:8545"},
+		{"not a url", "endpoint"},
+	}
+
+	for _, test := range tests {
+		if got := host(test.endpoint); got != test.want {
+			t.Errorf("host(%q) = %q, want %q", test.endpoint, got, test.want)
+		}
+	}
+}
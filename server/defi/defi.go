@@ -0,0 +1,115 @@
+package defi
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+// Kinds of position a receipt token can represent
+const (
+	Supply  = "supply"
+	Debt    = "debt"
+	LP      = "liquidity pool"
+	Staking = "liquid staking"
+)
+
+// 1e18 fixed point scale used by Compound exchange rates and liquid staking rates
+var wad = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+
+// Decomposition of a receipt token balance into the assets it is a claim to (or owes)
+type Position struct {
+	Protocol   string
+	Kind       string
+	Underlying []models.UnderlyingAsset
+}
+
+// Protocol adapter. Decompose returns nil when the token is not one the adapter understands.
+type Adapter interface {
+	Decompose(ctx context.Context, chain, tokenAddress string, balance *big.Int, block int) (*Position, error)
+}
+
+// Adapters in the order they are tried. Cheap, specific checks come before the generic probes.
+var adapters = []Adapter{
+	liquidStaking{},
+	compound{},
+	aave{},
+	uniswapV2{},
+	curve{},
+}
+
+// Decomposes the token balance at the block using the first adapter that recognises the token.
+// Returns nil when the token is a plain ERC20.
+func Decompose(ctx context.Context, chain, tokenAddress, balance string, block int) (*Position, error) {
+	if _, err := rpc.Endpoint(chain); err != nil {
+		return nil, err
+	}
+
+	amount, ok := new(big.Int).SetString(balance, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid balance %q", balance)
+	}
+
+	for _, adapter := range adapters {
+		position, err := adapter.Decompose(ctx, chain, strings.ToLower(tokenAddress), amount, block)
+		if err != nil {
+			return nil, err
+		}
+
+		if position != nil {
+			return position, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Builds an underlying asset, reading symbol and decimals from the token. An empty address means the chain's native asset.
+func asset(ctx context.Context, chain, tokenAddress string, amount *big.Int, block int, liability bool) (models.UnderlyingAsset, error) {
+	underlying := models.UnderlyingAsset{
+		TokenAddress: tokenAddress,
+		RawAmount:    amount.String(),
+		Liability:    liability,
+	}
+
+	if tokenAddress == "" {
+		symbol, _, _, _, err := models.ReturnInfo(chain)
+		if err != nil {
+			return underlying, err
+		}
+
+		underlying.TokenAddress = "N/A"
+		underlying.Symbol = symbol
+		underlying.Decimals = 18
+	} else {
+		decimals, err := rpc.CallUint(ctx, chain, tokenAddress, "decimals()", block)
+		if err != nil {
+			return underlying, fmt.Errorf("error reading decimals of %v: %v", tokenAddress, err)
+		}
+
+		result, err := rpc.EthCall(ctx, chain, tokenAddress, rpc.EncodeCall("symbol()"), block)
+		if err == nil {
+			underlying.Symbol = rpc.DecodeString(result)
+		}
+
+		underlying.Decimals = int(decimals.Int64())
+	}
+
+	underlying.Amount = rpc.ToDecimal(amount, underlying.Decimals)
+
+	return underlying, nil
+}
+
+// Returns the result of a probe that failed: no position when the token reverted, as it is not one the adapter
+// understands, and the error when the token could not be read.
+func probe(err error) (*Position, error) {
+	if rpc.Reverted(err) {
+		return nil, nil
+	}
+
+	return nil, err
+}
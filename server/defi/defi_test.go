@@ -0,0 +1,174 @@
+package defi
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc/rpctest"
+)
+
+func word(n int64) string {
+	return rpc.EncodeUint(big.NewInt(n))
+}
+
+// Returns the address made of the character repeated.
+func address(c string) string {
+	return "0x" + strings.Repeat(c, 40)
+}
+
+// Returns the ABI encoding of a string return value.
+func text(s string) []string {
+	return []string{word(32), word(int64(len(s))), hex.EncodeToString([]byte(s)) + strings.Repeat("0", 64-2*len(s))}
+}
+
+// Sets the decimals and symbol of an ERC-20 on the node.
+func erc20(node *rpctest.Node, token, symbol string, decimals int64) {
+	node.Return(token, "decimals()", nil, word(decimals))
+	node.Return(token, "symbol()", nil, text(symbol)...)
+}
+
+func TestDecompose(t *testing.T) {
+	token, underlying, other, pool := address("1"), address("2"), address("3"), address("4")
+	spark := "0xc13e21b648a5ee794902342038ff3adab66be987"
+
+	tests := []struct {
+		name  string
+		setup func(node *rpctest.Node)
+		// protocol, kind and underlying amounts expected, or no position when protocol is empty
+		protocol string
+		kind     string
+		amounts  []float64
+		err      bool
+	}{
+		{"plain token", func(node *rpctest.Node) {}, "", "", nil, false},
+		{"aave aToken", func(node *rpctest.Node) {
+			node.Return(token, "UNDERLYING_ASSET_ADDRESS()", nil, rpc.EncodeAddress(underlying))
+			node.Return(token, "POOL()", nil, rpc.EncodeAddress(pool))
+			erc20(node, underlying, "USDC", 6)
+		}, "aave", Supply, []float64{2.5}, false},
+		{"spark debt token", func(node *rpctest.Node) {
+			node.Return(token, "UNDERLYING_ASSET_ADDRESS()", nil, rpc.EncodeAddress(underlying))
+			node.Return(token, "borrowAllowance(address,address)", nil, word(0))
+			node.Return(token, "POOL()", nil, rpc.EncodeAddress(spark))
+			erc20(node, underlying, "DAI", 6)
+		}, "spark", Debt, []float64{2.5}, false},
+		{"aave probe fails at the node", func(node *rpctest.Node) {
+			node.Fail(token, "UNDERLYING_ASSET_ADDRESS()")
+		}, "", "", nil, true},
+		{"aave pool fails at the node", func(node *rpctest.Node) {
+			node.Return(token, "UNDERLYING_ASSET_ADDRESS()", nil, rpc.EncodeAddress(underlying))
+			node.Fail(token, "POOL()")
+			erc20(node, underlying, "USDC", 6)
+		}, "", "", nil, true},
+		{"compound cToken", func(node *rpctest.Node) {
+			node.Return(token, "isCToken()", nil, word(1))
+			// 0.02 underlying per cToken, scaled by 1e18
+			node.Return(token, "exchangeRateStored()", nil, word(2e16))
+			node.Return(token, "underlying()", nil, rpc.EncodeAddress(underlying))
+			erc20(node, underlying, "USDC", 6)
+		}, "compound", Supply, []float64{0.05}, false},
+		{"compound native market", func(node *rpctest.Node) {
+			node.Return(token, "isCToken()", nil, word(1))
+			node.Return(token, "exchangeRateStored()", nil, word(2e16))
+		}, "compound", Supply, []float64{0.00000000000005}, false},
+		{"compound probe fails at the node", func(node *rpctest.Node) {
+			node.Fail(token, "isCToken()")
+		}, "", "", nil, true},
+		{"uniswap v2 pair", func(node *rpctest.Node) {
+			node.Return(token, "token0()", nil, rpc.EncodeAddress(underlying))
+			node.Return(token, "token1()", nil, rpc.EncodeAddress(other))
+			node.Return(token, "getReserves()", nil, word(1e7), word(4e7), word(0))
+			node.Return(token, "totalSupply()", nil, word(1e7))
+			erc20(node, underlying, "USDC", 6)
+			erc20(node, other, "USDT", 6)
+		}, "uniswap v2", LP, []float64{2.5, 10}, false},
+		{"uniswap v3 pool", func(node *rpctest.Node) {
+			node.Return(token, "token0()", nil, rpc.EncodeAddress(underlying))
+			node.Return(token, "token1()", nil, rpc.EncodeAddress(other))
+		}, "", "", nil, false},
+		{"uniswap reserves fail at the node", func(node *rpctest.Node) {
+			node.Return(token, "token0()", nil, rpc.EncodeAddress(underlying))
+			node.Return(token, "token1()", nil, rpc.EncodeAddress(other))
+			node.Fail(token, "getReserves()")
+		}, "", "", nil, true},
+		{"curve pool", func(node *rpctest.Node) {
+			node.Return(token, "minter()", nil, rpc.EncodeAddress(pool))
+			node.Return(token, "totalSupply()", nil, word(1e7))
+			node.Return(pool, "coins(uint256)", []string{word(0)}, rpc.EncodeAddress(underlying))
+			node.Return(pool, "coins(uint256)", []string{word(1)}, rpc.EncodeAddress("0xeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"))
+			node.Return(pool, "balances(uint256)", []string{word(0)}, word(2e7))
+			node.Return(pool, "balances(uint256)", []string{word(1)}, word(1e7))
+			erc20(node, underlying, "USDC", 6)
+		}, "curve", LP, []float64{5, 0.0000000000025}, false},
+		{"curve coin fails at the node", func(node *rpctest.Node) {
+			node.Return(token, "minter()", nil, rpc.EncodeAddress(pool))
+			node.Return(token, "totalSupply()", nil, word(1e7))
+			node.Return(pool, "coins(uint256)", []string{word(0)}, rpc.EncodeAddress(underlying))
+			node.Return(pool, "balances(uint256)", []string{word(0)}, word(2e7))
+			node.Fail(pool, "coins(uint256)", word(1))
+			erc20(node, underlying, "USDC", 6)
+		}, "", "", nil, true},
+		{"total supply fails at the node", func(node *rpctest.Node) {
+			node.Fail(token, "totalSupply()")
+		}, "", "", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := rpctest.New(t, "eth")
+			test.setup(node)
+
+			position, err := Decompose(context.Background(), "eth", token, "2500000", 100)
+
+			if test.err {
+				if err == nil {
+					t.Fatalf("Decompose() = %+v, want an error", position)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.protocol == "" {
+				if position != nil {
+					t.Fatalf("Decompose() = %+v, want no position", position)
+				}
+
+				return
+			}
+
+			if position == nil || position.Protocol != test.protocol || position.Kind != test.kind || len(position.Underlying) != len(test.amounts) {
+				t.Fatalf("Decompose() = %+v, want %v %v with %v assets", position, test.protocol, test.kind, len(test.amounts))
+			}
+
+			for i, amount := range test.amounts {
+				if got := position.Underlying[i].Amount; got != amount {
+					t.Errorf("underlying %v amount = %v, want %v", i, got, amount)
+				}
+			}
+		})
+	}
+}
+
+func TestLiquidStaking(t *testing.T) {
+	node := rpctest.New(t, "eth")
+
+	wstETH := "0x7f39c581f595b53c5cb19bd0b3f8da6c935e2ca0"
+	node.Return(wstETH, "stEthPerToken()", nil, word(1.5e18))
+
+	position, err := Decompose(context.Background(), "eth", wstETH, "2000000000000000000", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if position.Kind != Staking || position.Underlying[0].Symbol != "ETH" || position.Underlying[0].Amount != 3 {
+		t.Errorf("Decompose() = %+v", position)
+	}
+}
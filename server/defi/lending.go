@@ -0,0 +1,91 @@
+package defi
+
+import (
+	"context"
+	"math/big"
+	"strings"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+// Aave forks sharing its token interface, keyed by chain and then by pool address
+var aaveForks = map[string]map[string]string{
+	"eth": {"0xc13e21b648a5ee794902342038ff3adab66be987": "spark"},
+}
+
+// Aave V2/V3 aTokens and debt tokens, and forks sharing the interface such as Spark.
+// Balances already include accrued interest and are denominated 1:1 in the underlying.
+type aave struct{}
+
+func (aave) Decompose(ctx context.Context, chain, tokenAddress string, balance *big.Int, block int) (*Position, error) {
+	underlying, err := rpc.CallAddress(ctx, chain, tokenAddress, "UNDERLYING_ASSET_ADDRESS()", block)
+	if err != nil {
+		return probe(err)
+	}
+
+	// Only debt tokens track borrow delegation
+	zero := rpc.EncodeAddress("0x0")
+	_, err = rpc.CallUint(ctx, chain, tokenAddress, "borrowAllowance(address,address)", block, zero, zero)
+	if err != nil && !rpc.Reverted(err) {
+		return nil, err
+	}
+	debt := err == nil
+
+	component, err := asset(ctx, chain, underlying, balance, block, debt)
+	if err != nil {
+		return nil, err
+	}
+
+	kind := Supply
+	if debt {
+		kind = Debt
+	}
+
+	// forks are told apart by the pool the token belongs to
+	protocol := "aave"
+	pool, err := rpc.CallAddress(ctx, chain, tokenAddress, "POOL()", block)
+	if err != nil && !rpc.Reverted(err) {
+		return nil, err
+	}
+	if fork, ok := aaveForks[chain][strings.ToLower(pool)]; ok {
+		protocol = fork
+	}
+
+	return &Position{Protocol: protocol, Kind: kind, Underlying: []models.UnderlyingAsset{component}}, nil
+}
+
+// Compound V2 cTokens and forks. The underlying amount is the balance at the stored exchange rate.
+type compound struct{}
+
+func (compound) Decompose(ctx context.Context, chain, tokenAddress string, balance *big.Int, block int) (*Position, error) {
+	isCToken, err := rpc.CallUint(ctx, chain, tokenAddress, "isCToken()", block)
+	if err != nil {
+		return probe(err)
+	}
+
+	if isCToken.Sign() == 0 {
+		return nil, nil
+	}
+
+	rate, err := rpc.CallUint(ctx, chain, tokenAddress, "exchangeRateStored()", block)
+	if err != nil {
+		return nil, err
+	}
+
+	// cETH and other native markets have no underlying()
+	underlying, err := rpc.CallAddress(ctx, chain, tokenAddress, "underlying()", block)
+	if err != nil && !rpc.Reverted(err) {
+		return nil, err
+	}
+	if err != nil {
+		underlying = ""
+	}
+
+	component, err := asset(ctx, chain, underlying, rpc.Share(balance, rate, wad), block, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Position{Protocol: "compound", Kind: Supply, Underlying: []models.UnderlyingAsset{component}}, nil
+}
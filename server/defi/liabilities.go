@@ -0,0 +1,246 @@
+package defi
+
+import (
+	"context"
+	"math/big"
+	"strings"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+// Aave indexes are ray (1e27) fixed point
+var ray = new(big.Int).Exp(big.NewInt(10), big.NewInt(27), nil)
+
+// Compound V2 comptrollers keyed by chain
+var comptrollers = map[string]string{
+	"eth": "0x3d9819210a31b4961b30ef54be2aed79b9c9cd3b",
+}
+
+// Compound V3 (Comet) markets keyed by chain
+var comets = map[string][]string{
+	"eth": {
+		"0xc3d688b66703497daa19211eedff47f25384cdc3",
+		"0xa17581a9e3356d9a858b789d68b4d866e593ae94",
+	},
+	"polygon":  {"0xf25212e676d1f7f89cd72ffee66158f541246445"},
+	"arbitrum": {"0xa5edbdd9646f8dff606d7448e414884c7d905dca"},
+}
+
+// Amount owed to a lending protocol at the block
+type Liability struct {
+	Protocol string
+	// Debt asset and the amount owed including accrued interest
+	Asset           models.UnderlyingAsset
+	Principal       float64
+	AccruedInterest float64
+	// Zero when the protocol does not expose a health factor
+	HealthFactor float64
+	// Why principal and accrued interest are not reported, when they cannot be read
+	Note string
+	// Contract the amount owed is read from and the function reading it, for debt not held as a token
+	Market string
+	Method string
+}
+
+// Returns the liability represented by an Aave (or Spark) debt token balance, or nil when the token is not a debt token.
+// Principal is the stable debt principal, or for variable debt the balance as of the borrower's last interaction with the
+// pool. Tokens exposing neither, such as Aave V2 variable debt, report no principal or accrued interest and say so in Note.
+func AaveDebt(ctx context.Context, address, chain, tokenAddress string, balance string, block int) (*Liability, error) {
+	if _, err := rpc.Endpoint(chain); err != nil {
+		return nil, err
+	}
+
+	tokenAddress = strings.ToLower(tokenAddress)
+
+	amount, ok := new(big.Int).SetString(balance, 10)
+	if !ok {
+		return nil, nil
+	}
+
+	position, err := aave{}.Decompose(ctx, chain, tokenAddress, amount, block)
+	if err != nil || position == nil || position.Kind != Debt {
+		return nil, err
+	}
+
+	liability := &Liability{Protocol: position.Protocol, Asset: position.Underlying[0]}
+	user := rpc.EncodeAddress(address)
+
+	principal, err := rpc.CallUint(ctx, chain, tokenAddress, "principalBalanceOf(address)", block, user)
+	if err != nil && !rpc.Reverted(err) {
+		return nil, err
+	}
+
+	if err != nil {
+		// variable debt: scaled balance at the index of the borrower's last interaction
+		scaled, scaledErr := rpc.CallUint(ctx, chain, tokenAddress, "scaledBalanceOf(address)", block, user)
+		index, indexErr := rpc.CallUint(ctx, chain, tokenAddress, "getPreviousIndex(address)", block, user)
+
+		for _, err := range []error{scaledErr, indexErr} {
+			if err != nil && !rpc.Reverted(err) {
+				return nil, err
+			}
+		}
+
+		if scaledErr == nil && indexErr == nil {
+			principal = rpc.Share(scaled, index, ray)
+		} else {
+			principal = nil
+		}
+	}
+
+	if principal != nil {
+		liability.Principal = rpc.ToDecimal(principal, liability.Asset.Decimals)
+		liability.AccruedInterest = rpc.ToDecimal(new(big.Int).Sub(amount, principal), liability.Asset.Decimals)
+	} else {
+		// reporting the whole balance as principal would claim no interest has accrued
+		liability.Note = "principal and accrued interest unknown: the debt token exposes neither principalBalanceOf nor getPreviousIndex"
+	}
+
+	pool, err := rpc.CallAddress(ctx, chain, tokenAddress, "POOL()", block)
+	if err == nil {
+		result, err := rpc.EthCall(ctx, chain, pool, rpc.EncodeCall("getUserAccountData(address)", user), block)
+		if err == nil {
+			if healthFactor, err := rpc.DecodeUintAt(result, 5); err == nil {
+				liability.HealthFactor = rpc.ToDecimal(healthFactor, 18)
+			}
+		}
+	}
+
+	return liability, nil
+}
+
+// Returns the address's Compound V2 and V3 borrows at the block.
+// Compound debt is not held as a token so it never appears in the wallet's token list.
+func CompoundDebts(ctx context.Context, address, chain string, block int) ([]Liability, error) {
+	if _, err := rpc.Endpoint(chain); err != nil {
+		return nil, err
+	}
+
+	var liabilities []Liability
+	user := rpc.EncodeAddress(address)
+
+	if comptroller, ok := comptrollers[chain]; ok {
+		debts, err := compoundV2Debts(ctx, chain, comptroller, user, block)
+		if err != nil {
+			return nil, err
+		}
+
+		liabilities = append(liabilities, debts...)
+	}
+
+	for _, comet := range comets[chain] {
+		owed, err := rpc.CallUint(ctx, chain, comet, "borrowBalanceOf(address)", block, user)
+		if err != nil {
+			return nil, err
+		}
+
+		if owed.Sign() == 0 {
+			continue
+		}
+
+		base, err := rpc.CallAddress(ctx, chain, comet, "baseToken()", block)
+		if err != nil {
+			return nil, err
+		}
+
+		debt, err := asset(ctx, chain, base, owed, block, true)
+		if err != nil {
+			return nil, err
+		}
+
+		liabilities = append(liabilities, Liability{Protocol: "compound v3", Asset: debt, Principal: debt.Amount, Market: comet, Method: "borrowBalanceOf(address)"})
+	}
+
+	return liabilities, nil
+}
+
+// Reads borrows in every market the account entered. The amount owed is accrued to the block by calling
+// borrowBalanceCurrent; principal is the stored balance as of the market's last accrual.
+func compoundV2Debts(ctx context.Context, chain, comptroller, user string, block int) ([]Liability, error) {
+	result, err := rpc.EthCall(ctx, chain, comptroller, rpc.EncodeCall("getAssetsIn(address)", user), block)
+	if err != nil {
+		return nil, err
+	}
+
+	markets, err := rpc.DecodeAddressArray(result, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	oracle, err := rpc.CallAddress(ctx, chain, comptroller, "oracle()", block)
+	if err != nil {
+		return nil, err
+	}
+
+	var liabilities []Liability
+	borrowedValue := new(big.Int)
+
+	for _, market := range markets {
+		owed, err := rpc.CallUint(ctx, chain, market, "borrowBalanceCurrent(address)", block, user)
+		if err != nil {
+			return nil, err
+		}
+
+		if owed.Sign() == 0 {
+			continue
+		}
+
+		stored, err := rpc.CallUint(ctx, chain, market, "borrowBalanceStored(address)", block, user)
+		if err != nil {
+			return nil, err
+		}
+
+		// the native market has no underlying token and reverts
+		underlying, err := rpc.CallAddress(ctx, chain, market, "underlying()", block)
+		if err != nil && !rpc.Reverted(err) {
+			return nil, err
+		}
+
+		debt, err := asset(ctx, chain, underlying, owed, block, true)
+		if err != nil {
+			return nil, err
+		}
+
+		// oracle prices are scaled so price * amount is USD * 1e36
+		price, err := rpc.CallUint(ctx, chain, oracle, "getUnderlyingPrice(address)", block, rpc.EncodeAddress(market))
+		if err == nil {
+			borrowedValue.Add(borrowedValue, new(big.Int).Mul(price, owed))
+		}
+
+		liabilities = append(liabilities, Liability{
+			Protocol:        "compound v2",
+			Asset:           debt,
+			Principal:       rpc.ToDecimal(stored, debt.Decimals),
+			AccruedInterest: rpc.ToDecimal(new(big.Int).Sub(owed, stored), debt.Decimals),
+			Market:          market,
+			Method:          "borrowBalanceCurrent(address)",
+		})
+	}
+
+	if len(liabilities) == 0 || borrowedValue.Sign() == 0 {
+		return liabilities, nil
+	}
+
+	// health factor: borrow capacity over borrows, where capacity = borrows + liquidity - shortfall
+	result, err = rpc.EthCall(ctx, chain, comptroller, rpc.EncodeCall("getAccountLiquidity(address)", user), block)
+	if err != nil {
+		return liabilities, nil
+	}
+
+	liquidity, errLiquidity := rpc.DecodeUintAt(result, 1)
+	shortfall, errShortfall := rpc.DecodeUintAt(result, 2)
+	if errLiquidity != nil || errShortfall != nil {
+		return liabilities, nil
+	}
+
+	borrowed := new(big.Int).Div(borrowedValue, wad)
+	capacity := new(big.Int).Sub(new(big.Int).Add(borrowed, liquidity), shortfall)
+	healthFactor, _ := new(big.Float).Quo(new(big.Float).SetInt(capacity), new(big.Float).SetInt(borrowed)).Float64()
+
+	for i := range liabilities {
+		liabilities[i].HealthFactor = healthFactor
+	}
+
+	return liabilities, nil
+}
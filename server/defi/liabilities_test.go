@@ -0,0 +1,170 @@
+package defi
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc/rpctest"
+)
+
+func TestAaveDebt(t *testing.T) {
+	holder, token, underlying, pool := address("1"), address("2"), address("3"), address("4")
+
+	// variable debt token with a balance of 2.5 and a health factor of 1.5
+	debtToken := func(node *rpctest.Node) {
+		node.Return(token, "UNDERLYING_ASSET_ADDRESS()", nil, rpc.EncodeAddress(underlying))
+		node.Return(token, "borrowAllowance(address,address)", nil, word(0))
+		node.Return(token, "POOL()", nil, rpc.EncodeAddress(pool))
+		node.Return(pool, "getUserAccountData(address)", nil, word(0), word(0), word(0), word(0), word(0), word(1.5e18))
+		erc20(node, underlying, "USDC", 6)
+	}
+
+	tests := []struct {
+		name  string
+		setup func(node *rpctest.Node)
+		// principal, accrued interest and health factor expected, or no liability when found is false
+		found               bool
+		principal, interest float64
+		healthFactor        float64
+		note                bool
+		err                 bool
+	}{
+		{"stable debt", func(node *rpctest.Node) {
+			debtToken(node)
+			node.Return(token, "principalBalanceOf(address)", nil, word(2e6))
+		}, true, 2, 0.5, 1.5, false, false},
+		{"variable debt", func(node *rpctest.Node) {
+			debtToken(node)
+			node.Return(token, "scaledBalanceOf(address)", nil, word(1e6))
+			// an index of 2 ray at the borrower's last interaction
+			node.Return(token, "getPreviousIndex(address)", nil, rpc.EncodeUint(new(big.Int).Mul(big.NewInt(2), ray)))
+		}, true, 2, 0.5, 1.5, false, false},
+		{"debt token exposing no principal", debtToken, true, 0, 0, 1.5, true, false},
+		{"principal fails at the node", func(node *rpctest.Node) {
+			debtToken(node)
+			node.Fail(token, "principalBalanceOf(address)")
+		}, false, 0, 0, 0, false, true},
+		{"scaled balance fails at the node", func(node *rpctest.Node) {
+			debtToken(node)
+			node.Fail(token, "scaledBalanceOf(address)")
+		}, false, 0, 0, 0, false, true},
+		{"aToken", func(node *rpctest.Node) {
+			debtToken(node)
+			node.Revert(token, "borrowAllowance(address,address)")
+		}, false, 0, 0, 0, false, false},
+		{"plain token", func(node *rpctest.Node) {}, false, 0, 0, 0, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := rpctest.New(t, "eth")
+			test.setup(node)
+
+			liability, err := AaveDebt(context.Background(), holder, "eth", token, "2500000", 100)
+
+			if test.err {
+				if err == nil {
+					t.Fatalf("AaveDebt() = %+v, want an error", liability)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !test.found {
+				if liability != nil {
+					t.Fatalf("AaveDebt() = %+v, want no liability", liability)
+				}
+
+				return
+			}
+
+			if liability == nil || liability.Protocol != "aave" || liability.Asset.Amount != 2.5 || liability.Principal != test.principal || liability.AccruedInterest != test.interest || liability.HealthFactor != test.healthFactor || (liability.Note != "") != test.note {
+				t.Errorf("AaveDebt() = %+v, want principal %v, interest %v and health factor %v", liability, test.principal, test.interest, test.healthFactor)
+			}
+		})
+	}
+}
+
+func TestCompoundDebts(t *testing.T) {
+	holder, market, underlying, oracle := address("1"), address("2"), address("3"), address("4")
+	comptroller := comptrollers["eth"]
+
+	// a USDC borrow of 2.5, 0.5 of it accrued since the market's last accrual, and no Compound V3 borrows
+	borrow := func(node *rpctest.Node) {
+		node.Return(comptroller, "getAssetsIn(address)", nil, word(32), word(1), rpc.EncodeAddress(market))
+		node.Return(comptroller, "oracle()", nil, rpc.EncodeAddress(oracle))
+		node.Return(market, "borrowBalanceCurrent(address)", nil, word(2.5e6))
+		node.Return(market, "borrowBalanceStored(address)", nil, word(2e6))
+		node.Return(market, "underlying()", nil, rpc.EncodeAddress(underlying))
+		erc20(node, underlying, "USDC", 6)
+
+		for _, comet := range comets["eth"] {
+			node.Return(comet, "borrowBalanceOf(address)", nil, word(0))
+		}
+	}
+
+	tests := []struct {
+		name  string
+		setup func(node *rpctest.Node)
+		// symbol of the debt expected, or no debt when empty
+		symbol string
+		err    bool
+	}{
+		{"borrow", borrow, "USDC", false},
+		{"native market borrow", func(node *rpctest.Node) {
+			borrow(node)
+			node.Revert(market, "underlying()")
+		}, "ETH", false},
+		{"underlying fails at the node", func(node *rpctest.Node) {
+			borrow(node)
+			node.Fail(market, "underlying()")
+		}, "", true},
+		{"no markets entered", func(node *rpctest.Node) {
+			borrow(node)
+			node.Return(comptroller, "getAssetsIn(address)", nil, word(32), word(0))
+		}, "", false},
+		{"comet fails at the node", func(node *rpctest.Node) {
+			borrow(node)
+			node.Fail(comets["eth"][0], "borrowBalanceOf(address)")
+		}, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := rpctest.New(t, "eth")
+			test.setup(node)
+
+			liabilities, err := CompoundDebts(context.Background(), holder, "eth", 100)
+
+			if test.err {
+				if err == nil {
+					t.Fatalf("CompoundDebts() = %+v, want an error", liabilities)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.symbol == "" {
+				if len(liabilities) != 0 {
+					t.Fatalf("CompoundDebts() = %+v, want no debt", liabilities)
+				}
+
+				return
+			}
+
+			if len(liabilities) != 1 || liabilities[0].Asset.Symbol != test.symbol || liabilities[0].Market != market || liabilities[0].Method != "borrowBalanceCurrent(address)" {
+				t.Fatalf("CompoundDebts() = %+v, want one %v borrow", liabilities, test.symbol)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package defi
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+// Maximum number of coins in a Curve pool
+const maxCurveCoins = 8
+
+// Uniswap V2 LP tokens and forks (Sushiswap, Pancakeswap, Trader Joe...). The holder owns a pro-rata share of both reserves.
+type uniswapV2 struct{}
+
+func (uniswapV2) Decompose(ctx context.Context, chain, tokenAddress string, balance *big.Int, block int) (*Position, error) {
+	token0, err := rpc.CallAddress(ctx, chain, tokenAddress, "token0()", block)
+	if err != nil {
+		return probe(err)
+	}
+
+	token1, err := rpc.CallAddress(ctx, chain, tokenAddress, "token1()", block)
+	if err != nil {
+		return probe(err)
+	}
+
+	// Uniswap V3 and other pools expose token0/token1 but have no fungible LP token
+	result, err := rpc.EthCall(ctx, chain, tokenAddress, rpc.EncodeCall("getReserves()"), block)
+	if err != nil {
+		return probe(err)
+	}
+
+	if len(rpc.Words(result)) < 2 {
+		return nil, nil
+	}
+
+	reserve0, err := rpc.DecodeUintAt(result, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	reserve1, err := rpc.DecodeUintAt(result, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	totalSupply, err := rpc.CallUint(ctx, chain, tokenAddress, "totalSupply()", block)
+	if err != nil {
+		return nil, err
+	}
+
+	position := &Position{Protocol: "uniswap v2", Kind: LP}
+
+	for _, reserve := range []struct {
+		token  string
+		amount *big.Int
+	}{{token0, reserve0}, {token1, reserve1}} {
+		component, err := asset(ctx, chain, reserve.token, rpc.Share(balance, reserve.amount, totalSupply), block, false)
+		if err != nil {
+			return nil, err
+		}
+
+		position.Underlying = append(position.Underlying, component)
+	}
+
+	return position, nil
+}
+
+// Curve LP tokens. Older pools mint a separate LP token whose minter() is the pool; newer pools are their own LP token.
+type curve struct{}
+
+func (curve) Decompose(ctx context.Context, chain, tokenAddress string, balance *big.Int, block int) (*Position, error) {
+	pool, err := rpc.CallAddress(ctx, chain, tokenAddress, "minter()", block)
+	if err != nil && !rpc.Reverted(err) {
+		return nil, err
+	}
+	if err != nil {
+		pool = tokenAddress
+	}
+
+	totalSupply, err := rpc.CallUint(ctx, chain, tokenAddress, "totalSupply()", block)
+	if err != nil {
+		return probe(err)
+	}
+
+	var position *Position
+
+	for i := 0; i < maxCurveCoins; i++ {
+		index := rpc.EncodeUint(big.NewInt(int64(i)))
+
+		coin, err := rpc.CallAddress(ctx, chain, pool, "coins(uint256)", block, index)
+		if err != nil && !rpc.Reverted(err) {
+			return nil, err
+		}
+		if err != nil {
+			break
+		}
+
+		reserve, err := rpc.CallUint(ctx, chain, pool, "balances(uint256)", block, index)
+		if err != nil {
+			return nil, err
+		}
+
+		// Curve uses this placeholder address for the native asset
+		if coin == "0xeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee" {
+			coin = ""
+		}
+
+		component, err := asset(ctx, chain, coin, rpc.Share(balance, reserve, totalSupply), block, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if position == nil {
+			position = &Position{Protocol: "curve", Kind: LP}
+		}
+
+		position.Underlying = append(position.Underlying, component)
+	}
+
+	if position == nil {
+		return nil, nil
+	}
+
+	return position, nil
+}
@@ -0,0 +1,55 @@
+package defi
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+// Liquid staking token and how its balance converts to the staked native asset
+type stakingToken struct {
+	protocol string
+	// View function returning native asset per token scaled by 1e18. Empty when the token rebases 1:1.
+	rateSignature string
+}
+
+// Liquid staking tokens keyed by chain and lowercase contract address
+var stakingTokens = map[string]map[string]stakingToken{
+	"eth": {
+		"0xae7ab96520de3a18e5e111b5eaab095312d7fe84": {protocol: "lido stETH"},
+		"0x7f39c581f595b53c5cb19bd0b3f8da6c935e2ca0": {protocol: "lido wstETH", rateSignature: "stEthPerToken()"},
+		"0xae78736cd615f374d3085123a210448e74fc6393": {protocol: "rocket pool rETH", rateSignature: "getExchangeRate()"},
+		"0xbe9895146f7af43049ca1c1ae358b0541ea49704": {protocol: "coinbase cbETH", rateSignature: "exchangeRate()"},
+		"0xac3e018457b222d93114458476f3e3416abbe38f": {protocol: "frax sfrxETH", rateSignature: "pricePerShare()"},
+	},
+}
+
+// Liquid staking tokens, valued in the staked native asset.
+type liquidStaking struct{}
+
+func (liquidStaking) Decompose(ctx context.Context, chain, tokenAddress string, balance *big.Int, block int) (*Position, error) {
+	token, ok := stakingTokens[chain][tokenAddress]
+	if !ok {
+		return nil, nil
+	}
+
+	amount := balance
+
+	if token.rateSignature != "" {
+		rate, err := rpc.CallUint(ctx, chain, tokenAddress, token.rateSignature, block)
+		if err != nil {
+			return nil, err
+		}
+
+		amount = rpc.Share(balance, rate, wad)
+	}
+
+	component, err := asset(ctx, chain, "", amount, block, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Position{Protocol: token.protocol, Kind: Staking, Underlying: []models.UnderlyingAsset{component}}, nil
+}
@@ -0,0 +1,420 @@
+package evidence
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/blocks"
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/report"
+	"github.com/harrisandtrotter/proof-of-balance/server/runs"
+)
+
+// Files holding the manifest and its signature. Every other file in the pack is listed in the manifest.
+const (
+	ManifestFile  = "manifest.json"
+	SignatureFile = "manifest.sig"
+	Algorithm     = "ed25519"
+)
+
+// Limits on what is read from an uploaded pack, so a zip bomb cannot exhaust memory. Packs hold a few dozen files and
+// their largest file, a wallet's raw token pages, is a few megabytes.
+const (
+	maxFiles     = 1000
+	maxFileSize  = 64 << 20
+	maxTotalSize = 256 << 20
+)
+
+// Lists the pack's files with their SHA-256 hashes and identifies the key that signed it
+type Manifest struct {
+	RunID     string    `json:"run_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Algorithm string    `json:"algorithm"`
+	PublicKey string    `json:"public_key"`
+	Files     []File    `json:"files"`
+}
+
+type File struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Size   int    `json:"size"`
+}
+
+// Consensus, share and decomposition evidence for a single row
+type proof struct {
+	Asset            string                   `json:"asset_symbol"`
+	AssetAddress     string                   `json:"contract_address"`
+	BlockNumber      int                      `json:"block_number"`
+	ReadAt           string                   `json:"read_at,omitempty"`
+	Consensus        string                   `json:"consensus,omitempty"`
+	ProviderBalances []models.ProviderBalance `json:"provider_balances,omitempty"`
+	Underlying       []models.UnderlyingAsset `json:"underlying,omitempty"`
+	ShareModel       string                   `json:"share_model,omitempty"`
+	Shares           float64                  `json:"shares,omitempty"`
+	ShareRate        float64                  `json:"share_rate,omitempty"`
+}
+
+// Price applied to a row
+type price struct {
+	Asset        string  `json:"asset_symbol"`
+	AssetAddress string  `json:"contract_address"`
+	Chain        string  `json:"chain"`
+	BlockNumber  int     `json:"block_number"`
+	UsdPrice     float64 `json:"usd_price"`
+	Source       string  `json:"price_source"`
+}
+
+// Builds the signed zip evidence pack for the run.
+func Pack(run *runs.Run, key ed25519.PrivateKey) ([]byte, error) {
+	files, err := contents(run)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := Manifest{
+		RunID:     run.ID,
+		CreatedAt: run.CreatedAt,
+		Algorithm: Algorithm,
+		PublicKey: hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		hash := sha256.Sum256(files[name])
+		manifest.Files = append(manifest.Files, File{Name: name, SHA256: hex.EncodeToString(hash[:]), Size: len(files[name])})
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	signature := hex.EncodeToString(ed25519.Sign(key, manifestData))
+
+	var buffer bytes.Buffer
+
+	archive := zip.NewWriter(&buffer)
+
+	names = append(names, ManifestFile, SignatureFile)
+	files[ManifestFile] = manifestData
+	files[SignatureFile] = []byte(signature)
+
+	for _, name := range names {
+		// fixed modification times keep the archive reproducible for a run
+		writer, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: run.CreatedAt})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = writer.Write(files[name])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Returns the files of the pack other than the manifest and signature.
+func contents(run *runs.Run) (map[string][]byte, error) {
+	files := map[string][]byte{}
+
+	add := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding %v: %v", name, err)
+		}
+
+		files[name] = data
+
+		return nil
+	}
+
+	var proofs []proof
+	var valuations []price
+
+	for _, row := range run.Rows {
+		proofs = append(proofs, proof{
+			Asset:            row.Asset,
+			AssetAddress:     row.AssetAddress,
+			BlockNumber:      row.BlockNumber,
+			ReadAt:           row.ReadAt,
+			Consensus:        row.Consensus,
+			ProviderBalances: row.ProviderBalances,
+			Underlying:       row.Underlying,
+			ShareModel:       row.ShareModel,
+			Shares:           row.Shares,
+			ShareRate:        row.ShareRate,
+		})
+
+		if row.PriceSource != "" {
+			valuations = append(valuations, price{
+				Asset:        row.Asset,
+				AssetAddress: row.AssetAddress,
+				Chain:        row.Chain,
+				BlockNumber:  row.BlockNumber,
+				UsdPrice:     row.UsdPrice,
+				Source:       row.PriceSource,
+			})
+		}
+	}
+
+	for name, v := range map[string]interface{}{
+		"request.json": run.Request,
+		"blocks.json":  []interface{}{run.Block},
+		"rows.json":    run.Rows,
+		"proofs.json":  proofs,
+		"prices.json":  valuations,
+	} {
+		if err := add(name, v); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, raw := range run.Raw {
+		files["raw/"+name+".json"] = raw
+	}
+
+	csv, err := report.CSV(run)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering report: %v", err)
+	}
+
+	files["report.csv"] = csv
+
+	workpaper, err := report.Workpaper([]*runs.Run{run})
+	if err != nil {
+		return nil, fmt.Errorf("error rendering workpaper: %v", err)
+	}
+
+	files["workpaper.xlsx"] = workpaper
+
+	return files, nil
+}
+
+// Checks the pack's signature and that every file matches its manifest hash, with no files missing or added.
+// When trusted is nil the key embedded in the manifest is used, so the caller must compare it with the firm's key.
+func Verify(data []byte, trusted ed25519.PublicKey) (*Manifest, error) {
+	files, err := unzip(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return verify(files, trusted)
+}
+
+// Verifies the pack and rebuilds the run it was exported from, so it can be re-performed without the runs directory.
+func Open(data []byte, trusted ed25519.PublicKey) (*runs.Run, error) {
+	files, err := unzip(data)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := verify(files, trusted)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &runs.Run{ID: manifest.RunID, CreatedAt: manifest.CreatedAt, Raw: map[string]json.RawMessage{}}
+
+	var resolved []blocks.Block
+
+	for name, v := range map[string]interface{}{
+		"request.json": &run.Request,
+		"blocks.json":  &resolved,
+		"rows.json":    &run.Rows,
+	} {
+		err = json.Unmarshal(files[name], v)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %v: %v", name, err)
+		}
+	}
+
+	if len(resolved) == 0 {
+		return nil, errors.New("evidence pack has no resolved block")
+	}
+
+	run.Block = resolved[0]
+
+	for name, content := range files {
+		if strings.HasPrefix(name, "raw/") {
+			run.Raw[strings.TrimSuffix(strings.TrimPrefix(name, "raw/"), ".json")] = content
+		}
+	}
+
+	return run, nil
+}
+
+// Returns the pack's files keyed by name.
+func unzip(data []byte) (map[string][]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading evidence pack: %v", err)
+	}
+
+	if len(archive.File) > maxFiles {
+		return nil, fmt.Errorf("evidence pack has more than %v files", maxFiles)
+	}
+
+	files := map[string][]byte{}
+	total := 0
+
+	for _, file := range archive.File {
+		if _, ok := files[file.Name]; ok {
+			return nil, fmt.Errorf("duplicate file %v in evidence pack", file.Name)
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		// the sizes in the zip's headers are not trusted, so reads stop one byte past the limit
+		content, err := io.ReadAll(io.LimitReader(reader, maxFileSize+1))
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading %v: %v", file.Name, err)
+		}
+
+		if len(content) > maxFileSize {
+			return nil, fmt.Errorf("%v in evidence pack is larger than %v bytes", file.Name, maxFileSize)
+		}
+
+		total += len(content)
+		if total > maxTotalSize {
+			return nil, fmt.Errorf("evidence pack is larger than %v bytes uncompressed", maxTotalSize)
+		}
+
+		files[file.Name] = content
+	}
+
+	return files, nil
+}
+
+func verify(files map[string][]byte, trusted ed25519.PublicKey) (*Manifest, error) {
+	manifestData, ok := files[ManifestFile]
+	if !ok {
+		return nil, errors.New("evidence pack has no manifest")
+	}
+
+	var manifest Manifest
+
+	err := json.Unmarshal(manifestData, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %v", err)
+	}
+
+	if manifest.Algorithm != Algorithm {
+		return nil, fmt.Errorf("unsupported signature algorithm %q", manifest.Algorithm)
+	}
+
+	key := trusted
+	if key == nil {
+		key, err = decodePublicKey(manifest.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key in manifest: %v", err)
+		}
+	} else if manifest.PublicKey != hex.EncodeToString(key) {
+		return nil, errors.New("evidence pack was not signed with the trusted key")
+	}
+
+	signature, err := hex.DecodeString(strings.TrimSpace(string(files[SignatureFile])))
+	if err != nil || !ed25519.Verify(key, manifestData, signature) {
+		return nil, errors.New("manifest signature is invalid")
+	}
+
+	listed := map[string]bool{ManifestFile: true, SignatureFile: true}
+
+	for _, file := range manifest.Files {
+		listed[file.Name] = true
+
+		content, ok := files[file.Name]
+		if !ok {
+			return nil, fmt.Errorf("%v is listed in the manifest but missing from the pack", file.Name)
+		}
+
+		hash := sha256.Sum256(content)
+		if hex.EncodeToString(hash[:]) != file.SHA256 {
+			return nil, fmt.Errorf("%v does not match its manifest hash", file.Name)
+		}
+	}
+
+	for name := range files {
+		if !listed[name] {
+			return nil, fmt.Errorf("%v is not listed in the manifest", name)
+		}
+	}
+
+	return &manifest, nil
+}
+
+// Reads the firm's signing key from EVIDENCE_SIGNING_KEY_FILE: a hex encoded 32 byte seed or 64 byte private key.
+func SigningKey() (ed25519.PrivateKey, error) {
+	if initialisers.EVIDENCEKEYFILE == "" {
+		return nil, errors.New("no evidence signing key configured (EVIDENCE_SIGNING_KEY_FILE)")
+	}
+
+	data, err := os.ReadFile(initialisers.EVIDENCEKEYFILE)
+	if err != nil {
+		return nil, fmt.Errorf("error reading evidence signing key: %v", err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("evidence signing key is not hex encoded: %v", err)
+	}
+
+	switch len(key) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(key), nil
+	}
+
+	return nil, fmt.Errorf("evidence signing key must be %v or %v bytes", ed25519.SeedSize, ed25519.PrivateKeySize)
+}
+
+// Returns the public key configured in EVIDENCE_PUBLIC_KEY, or nil when none is configured.
+func TrustedKey() (ed25519.PublicKey, error) {
+	if initialisers.EVIDENCEPUBLICKEY == "" {
+		return nil, nil
+	}
+
+	return decodePublicKey(initialisers.EVIDENCEPUBLICKEY)
+}
+
+func decodePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %v bytes", ed25519.PublicKeySize)
+	}
+
+	return ed25519.PublicKey(key), nil
+}
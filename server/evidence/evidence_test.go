@@ -0,0 +1,184 @@
+package evidence
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/blocks"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/runs"
+)
+
+// Returns a key generated from a fixed seed, so packs are reproducible.
+func testKey(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+}
+
+func testRun() *runs.Run {
+	return &runs.Run{
+		ID:        "20240331T235959Z-0badf00d",
+		CreatedAt: time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC),
+		Request:   models.Request{Address: "0x1", Chain: "eth", Date: "31/03/2024", Timestamp: "23:59:59"},
+		Block:     blocks.Block{Date: "2024-03-31T23:59:59Z", Block: 100, Hash: "0xabc"},
+		Rows: []models.ClientResponse{
+			{Address: "0x1", Chain: "eth", BlockNumber: 100, Asset: "ETH", AssetAddress: "N/A", Balance: 2, SpamReasons: []string{}},
+		},
+		Raw: map[string]json.RawMessage{
+			runs.RawNativeBalance: json.RawMessage(`{"balance":"2000000000000000000"}`),
+			runs.RawExchanges:     json.RawMessage(`[{"method":"POST","url":"$ETH_RPC_URL","request_body":"{}","status":200,"body":"{}"}]`),
+		},
+	}
+}
+
+// Returns the files of a zip.
+func read(t *testing.T, pack []byte) map[string][]byte {
+	t.Helper()
+
+	files, err := unzip(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+// Returns a zip of the files.
+func write(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+
+	for name, content := range files {
+		writer, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writer.Write(content)
+	}
+
+	archive.Close()
+
+	return buffer.Bytes()
+}
+
+func TestOpen(t *testing.T) {
+	key := testKey(1)
+	trusted := key.Public().(ed25519.PublicKey)
+
+	pack, err := Pack(testRun(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a pack signed with another key, whose manifest names that key
+	forged, err := Pack(testRun(), testKey(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := read(t, pack)
+	tampered["rows.json"] = bytes.Replace(tampered["rows.json"], []byte(`"balance": 2`), []byte(`"balance": 3`), 1)
+
+	added := read(t, pack)
+	added["extra.txt"] = []byte("not in the manifest")
+
+	missing := read(t, pack)
+	delete(missing, "rows.json")
+
+	unsigned := read(t, pack)
+	unsigned[SignatureFile] = []byte(strings.Repeat("00", ed25519.SignatureSize))
+
+	tests := []struct {
+		name    string
+		pack    []byte
+		trusted ed25519.PublicKey
+		err     string
+	}{
+		{"trusted key", pack, trusted, ""},
+		{"embedded key", pack, nil, ""},
+		{"other key", forged, trusted, "not signed with the trusted key"},
+		{"tampered file", write(t, tampered), trusted, "does not match its manifest hash"},
+		{"added file", write(t, added), trusted, "not listed in the manifest"},
+		{"missing file", write(t, missing), trusted, "missing from the pack"},
+		{"bad signature", write(t, unsigned), trusted, "signature is invalid"},
+		{"not a zip", []byte("evidence"), trusted, "error reading evidence pack"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			run, err := Open(test.pack, test.trusted)
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Open() error = %v, want %q", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := testRun()
+			if run.ID != want.ID || run.Block.Block != want.Block.Block || run.Request != want.Request || len(run.Rows) != 1 || run.Rows[0].Balance != 2 {
+				t.Errorf("Open() = %+v, want %+v", run, want)
+			}
+
+			if string(run.Raw[runs.RawNativeBalance]) != string(want.Raw[runs.RawNativeBalance]) {
+				t.Errorf("raw native balance = %s", run.Raw[runs.RawNativeBalance])
+			}
+
+			if string(run.Raw[runs.RawExchanges]) != string(want.Raw[runs.RawExchanges]) {
+				t.Errorf("provider exchanges = %s", run.Raw[runs.RawExchanges])
+			}
+		})
+	}
+}
+
+func TestPackIsReproducible(t *testing.T) {
+	first, err := Pack(testRun(), testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, _ := Pack(testRun(), testKey(1))
+
+	if !bytes.Equal(first, second) {
+		t.Error("packs of the same run differ")
+	}
+}
+
+func TestUnzipLimits(t *testing.T) {
+	many := map[string][]byte{}
+	for i := 0; i <= maxFiles; i++ {
+		many[fmt.Sprintf("file-%v", i)] = nil
+	}
+
+	tests := []struct {
+		name  string
+		files map[string][]byte
+		err   string
+	}{
+		{"too many files", many, "more than"},
+		// zeros compress to almost nothing, as a zip bomb's entries do
+		{"file too large", map[string][]byte{"bomb": make([]byte, maxFileSize+1)}, "larger than"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := unzip(write(t, test.files))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("unzip() error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package faults
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/scheduler"
+)
+
+// Error codes. Codes are stable and safe for clients to match on; messages are not.
+const (
+	InvalidRequest          = "invalid_request"
+	InvalidChain            = "invalid_chain"
+	InvalidDate             = "invalid_date"
+	InvalidEvidence         = "invalid_evidence"
+	Unauthorized            = "unauthorized"
+	Forbidden               = "forbidden"
+	QuotaExceeded           = "quota_exceeded"
+	NotFound                = "not_found"
+	BlockNotFound           = "block_not_found"
+	ProviderRejected        = "provider_rejected"
+	ProviderRateLimited     = "provider_rate_limited"
+	ProviderPlanLimit       = "provider_plan_limit"
+	ProviderUnavailable     = "provider_unavailable"
+	ProviderTimeout         = "provider_timeout"
+	ProviderCircuitOpen     = "provider_circuit_open"
+	ProviderInvalidResponse = "provider_invalid_response"
+	ProviderFailed          = "provider_failed"
+	StorageFailed           = "storage_failed"
+	SigningFailed           = "signing_failed"
+	Internal                = "internal"
+)
+
+// Steps of a run an error can occur at
+const (
+	StepRequest       = "request"
+	StepBlock         = "block"
+	StepNativeBalance = "native_balance"
+	StepTokenBalances = "token_balances"
+	StepSpamLists     = "spam_lists"
+	StepStaking       = "staking"
+	StepDecomposition = "decomposition"
+	StepLiabilities   = "liabilities"
+	StepShares        = "shares"
+	StepPrice         = "price"
+	StepNFTs          = "nfts"
+	StepTransfers     = "transfers"
+	StepValidators    = "validators"
+	StepVesting       = "vesting"
+	StepSave          = "save"
+)
+
+// HTTP status and retryability of each code
+var catalogue = map[string]struct {
+	status    int
+	retryable bool
+}{
+	InvalidRequest:          {http.StatusBadRequest, false},
+	InvalidChain:            {http.StatusBadRequest, false},
+	InvalidDate:             {http.StatusBadRequest, false},
+	InvalidEvidence:         {http.StatusBadRequest, false},
+	Unauthorized:            {http.StatusUnauthorized, false},
+	Forbidden:               {http.StatusForbidden, false},
+	QuotaExceeded:           {http.StatusTooManyRequests, true},
+	NotFound:                {http.StatusNotFound, false},
+	BlockNotFound:           {http.StatusNotFound, false},
+	ProviderRejected:        {http.StatusBadGateway, false},
+	ProviderRateLimited:     {http.StatusTooManyRequests, true},
+	ProviderPlanLimit:       {http.StatusBadGateway, false},
+	ProviderUnavailable:     {http.StatusBadGateway, true},
+	ProviderTimeout:         {http.StatusGatewayTimeout, true},
+	ProviderCircuitOpen:     {http.StatusServiceUnavailable, true},
+	ProviderInvalidResponse: {http.StatusBadGateway, false},
+	ProviderFailed:          {http.StatusBadGateway, false},
+	StorageFailed:           {http.StatusInternalServerError, false},
+	SigningFailed:           {http.StatusInternalServerError, false},
+	Internal:                {http.StatusInternalServerError, false},
+}
+
+// Error reported by the API, the CLI and in reports: what failed, where, and whether trying again may help
+type Error struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Status    int    `json:"status"`
+	Retryable bool   `json:"retryable"`
+	Wallet    string `json:"wallet,omitempty"`
+	Chain     string `json:"chain,omitempty"`
+	Step      string `json:"step,omitempty"`
+	Err       error  `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Step != "" {
+		return fmt.Sprintf("%v at %v: %v", e.Code, e.Step, e.Message)
+	}
+
+	return fmt.Sprintf("%v: %v", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Records the wallet and chain the error occurred for, and returns the error.
+func (e *Error) For(wallet, chain string) *Error {
+	e.Wallet, e.Chain = wallet, chain
+
+	return e
+}
+
+// Returns an error with the code's status and retryability.
+func New(code, step string, err error) *Error {
+	entry, ok := catalogue[code]
+	if !ok {
+		code, entry = Internal, catalogue[Internal]
+	}
+
+	return &Error{Code: code, Message: err.Error(), Status: entry.status, Retryable: entry.retryable, Step: step, Err: err}
+}
+
+// Returns an error with the code and a message of its own.
+func Newf(code, step, format string, args ...interface{}) *Error {
+	return New(code, step, fmt.Errorf(format, args...))
+}
+
+// Returns the error as an *Error. Errors already in the catalogue keep their code; provider failures are classified
+// by what went wrong; anything else gets the code given.
+func Wrap(code, step string, err error) *Error {
+	var known *Error
+	if errors.As(err, &known) {
+		wrapped := *known
+		if wrapped.Step == "" {
+			wrapped.Step = step
+		}
+
+		return &wrapped
+	}
+
+	return New(classify(err, code), step, err)
+}
+
+// Returns the code for a failed provider request, or the fallback when the error is not one.
+func classify(err error, fallback string) string {
+	var provider *scheduler.ProviderError
+
+	switch {
+	case errors.Is(err, scheduler.ErrCircuitOpen):
+		return ProviderCircuitOpen
+	case errors.Is(err, context.DeadlineExceeded):
+		return ProviderTimeout
+	case errors.As(err, &provider) && provider.Status != 0:
+		return responseCode(provider.Status, provider.Body)
+	case errors.As(err, &provider):
+		return ProviderUnavailable
+	}
+
+	// connection failures, as opposed to every error the HTTP client wraps
+	var connection *net.OpError
+	var lookup *net.DNSError
+
+	if errors.As(err, &connection) || errors.As(err, &lookup) {
+		return ProviderUnavailable
+	}
+
+	return fallback
+}
+
+// Returns the error for an unsuccessful provider response, using the provider's own message when the body has one.
+func Response(provider string, status int, body []byte) *Error {
+	return Newf(responseCode(status, body), "", "%v returned %v: %v", provider, status, Message(body))
+}
+
+// Returns the message of a provider's error body: its "message" or "error" field, or the body itself.
+func Message(body []byte) string {
+	var reply struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+
+	if json.Unmarshal(body, &reply) == nil {
+		if reply.Message != "" {
+			return reply.Message
+		}
+
+		if reply.Error != "" {
+			return reply.Error
+		}
+	}
+
+	return strings.TrimSpace(string(body))
+}
+
+// Returns the code for an unsuccessful provider response.
+func responseCode(status int, body []byte) string {
+	message := strings.ToLower(Message(body))
+
+	if scheduler.PlanLimited(message) {
+		return ProviderPlanLimit
+	}
+
+	switch {
+	case status == http.StatusTooManyRequests || strings.Contains(message, "rate limit") || strings.Contains(message, "too many requests"):
+		return ProviderRateLimited
+	case status >= http.StatusInternalServerError:
+		return ProviderUnavailable
+	}
+
+	return ProviderRejected
+}
+
+// Logs the error with its code and context as key=value pairs.
+func Log(err *Error) {
+	log.Printf("error code=%v status=%v retryable=%v wallet=%q chain=%q step=%q: %v", err.Code, err.Status, err.Retryable, err.Wallet, err.Chain, err.Step, err.Message)
+}
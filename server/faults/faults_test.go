@@ -0,0 +1,92 @@
+package faults
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/scheduler"
+)
+
+func TestWrap(t *testing.T) {
+	known := Newf(BlockNotFound, StepBlock, "no block found")
+	unstepped := Newf(ProviderRateLimited, "", "rate limited")
+
+	tests := []struct {
+		name string
+		err  error
+		// code and step given to Wrap
+		code, step string
+		// code, status, retryability and step expected
+		want      string
+		status    int
+		retryable bool
+		wantStep  string
+	}{
+		{"known error keeps its code and step", known, ProviderFailed, StepTokenBalances, BlockNotFound, http.StatusNotFound, false, StepBlock},
+		{"known error takes the step when it has none", unstepped, ProviderFailed, StepPrice, ProviderRateLimited, http.StatusTooManyRequests, true, StepPrice},
+		{"known error wrapped in another", fmt.Errorf("reading decimals: %w", known), ProviderFailed, StepPrice, BlockNotFound, http.StatusNotFound, false, StepBlock},
+		{"open circuit", fmt.Errorf("rpc: %w", scheduler.ErrCircuitOpen), ProviderFailed, StepPrice, ProviderCircuitOpen, http.StatusServiceUnavailable, true, StepPrice},
+		{"timeout", &scheduler.ProviderError{Host: "node", Attempts: 1, Err: context.DeadlineExceeded}, ProviderFailed, StepPrice, ProviderTimeout, http.StatusGatewayTimeout, true, StepPrice},
+		{"rate limited", &scheduler.ProviderError{Host: "node", Status: 429, Body: []byte(`{"message":"slow down"}`)}, ProviderFailed, StepPrice, ProviderRateLimited, http.StatusTooManyRequests, true, StepPrice},
+		{"plan used up", &scheduler.ProviderError{Host: "node", Status: 400, Body: []byte(`{"message":"Upgrade your plan"}`)}, ProviderFailed, StepPrice, ProviderPlanLimit, http.StatusBadGateway, false, StepPrice},
+		{"server error", &scheduler.ProviderError{Host: "node", Status: 502, Body: []byte("bad gateway")}, ProviderFailed, StepPrice, ProviderUnavailable, http.StatusBadGateway, true, StepPrice},
+		{"rejected", &scheduler.ProviderError{Host: "node", Status: 400, Body: []byte(`{"error":"invalid address"}`)}, ProviderFailed, StepPrice, ProviderRejected, http.StatusBadGateway, false, StepPrice},
+		{"no response", &scheduler.ProviderError{Host: "node", Attempts: 3, Err: errors.New("EOF")}, ProviderFailed, StepPrice, ProviderUnavailable, http.StatusBadGateway, true, StepPrice},
+		{"connection refused", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, ProviderFailed, StepPrice, ProviderUnavailable, http.StatusBadGateway, true, StepPrice},
+		{"unknown host", fmt.Errorf("post: %w", &net.DNSError{Name: "node", Err: "no such host"}), ProviderFailed, StepPrice, ProviderUnavailable, http.StatusBadGateway, true, StepPrice},
+		{"other error", errors.New("execution reverted"), ProviderFailed, StepPrice, ProviderFailed, http.StatusBadGateway, false, StepPrice},
+		{"code not in the catalogue", errors.New("failed"), "mystery", StepPrice, Internal, http.StatusInternalServerError, false, StepPrice},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Wrap(test.code, test.step, test.err)
+
+			if got.Code != test.want || got.Status != test.status || got.Retryable != test.retryable || got.Step != test.wantStep {
+				t.Errorf("Wrap() = %+v, want %v (%v, retryable %v) at %v", got, test.want, test.status, test.retryable, test.wantStep)
+			}
+
+			// provider failures stay inspectable, e.g. for a revert
+			var known *Error
+			if !errors.As(test.err, &known) && !errors.Is(got, test.err) {
+				t.Errorf("Wrap() does not unwrap to %v", test.err)
+			}
+		})
+	}
+
+	// wrapping copies a known error rather than changing it
+	Wrap(ProviderFailed, StepPrice, unstepped)
+	if unstepped.Step != "" {
+		t.Errorf("Wrap() changed the step of the error it wrapped to %v", unstepped.Step)
+	}
+}
+
+func TestResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		code    string
+		message string
+	}{
+		{"message field", 401, `{"message":"Invalid key"}`, ProviderRejected, "moralis returned 401: Invalid key"},
+		{"error field", 500, `{"error":"database down"}`, ProviderUnavailable, "moralis returned 500: database down"},
+		{"plain body", 503, " unavailable \n", ProviderUnavailable, "moralis returned 503: unavailable"},
+		{"rate limit in the message", 400, `{"message":"Rate limit exceeded"}`, ProviderRateLimited, "moralis returned 400: Rate limit exceeded"},
+		{"compute units used up", 429, `{"message":"Your compute units are used up"}`, ProviderPlanLimit, "moralis returned 429: Your compute units are used up"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Response("moralis", test.status, []byte(test.body))
+
+			if got.Code != test.code || got.Message != test.message {
+				t.Errorf("Response() = %v: %v, want %v: %v", got.Code, got.Message, test.code, test.message)
+			}
+		})
+	}
+}
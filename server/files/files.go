@@ -0,0 +1,36 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Replaces the file with the data by writing a temporary file beside it and renaming it over the file, so a crash
+// mid-write never leaves it truncated.
+func Write(name string, data []byte, perm os.FileMode) error {
+	temp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Chmod(temp.Name(), perm)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), name)
+}
@@ -1,18 +1,93 @@
 package initialisers
 
 import (
+	"errors"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 var APIKEY string
 
+// File manual price overrides are persisted to
+var OVERRIDESFILE string
+
+// JSON-RPC endpoints keyed by Moralis chain name
+var RPCURLS = map[string]string{}
+
+// Additional JSON-RPC endpoints per chain used as independent balance providers, and the number of providers that must agree
+var CONSENSUSRPCURLS = map[string][]string{}
+var QUORUM int
+
+// Provider traffic mode (live, record or replay) and the evidence directory recordings are kept in
+var PROVIDERMODE string
+var EVIDENCEDIR string
+
+// Directory stored runs are kept in, and the firm's Ed25519 key for signing evidence packs
+var RUNSDIR string
+var EVIDENCEKEYFILE string
+
+// Hex encoded Ed25519 public key evidence packs are verified against
+var EVIDENCEPUBLICKEY string
+
+// Provider limits: concurrent requests, requests per second and, for Moralis, compute units per second. Zero means unlimited.
+// Other providers' limits apply to each host separately.
+var MORALISCONCURRENCY int
+var MORALISRPS float64
+var MORALISCUPS float64
+var PROVIDERCONCURRENCY int
+var PROVIDERRPS float64
+
+// Timeout of each attempt at a provider request, retries after the first attempt, and the failed requests in a row
+// that open a provider's circuit for the cooldown. Zero turns the timeout or the circuit breaker off.
+var PROVIDERTIMEOUT time.Duration
+var PROVIDERRETRIES int
+var BREAKERTHRESHOLD int
+var BREAKERCOOLDOWN time.Duration
+
+// API keys and their tenants and quotas, and where each key's usage today is kept
+var AUTHKEYSFILE string
+var USAGEFILE string
+
+// Serve without authentication, for local use only
+var AUTHDISABLED bool
+
+// How long a browser session lasts after signing in with an API key
+var SESSIONTTL time.Duration
+
+// Origins browsers may call the API from
+var CORSORIGINS string
+
+// Wallets proved at once, and token balances of a wallet worked on at once
+var WALLETWORKERS int
+var TOKENWORKERS int
+
+// File holding the firm's wording for PDF balance confirmations
+var CONFIRMATIONTEMPLATEFILE string
+
+// Most blocks a completeness check scans, and the time it is allowed. Zero turns the cap or the timeout off.
+var COMPLETENESSMAXBLOCKS int
+var COMPLETENESSTIMEOUT time.Duration
+
+// File holding the local spam allow and deny lists
+var SPAMLISTFILE string
+
+// Ethereum Beacon API base url, e.g. a consensus client or a local fixture server
+var BEACONURL string
+
+// Avalanche P-chain API and Cronos POS REST API, for stake held outside the EVM chains
+var AVALANCHEPCHAINURL string
+var CRONOSPOSURL string
+
 // Efficiently load environment variables
 func LoadEnvironment() {
+	// a .env file is optional: headless servers and CI set the variables directly
 	err := godotenv.Load()
-	if err != nil {
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		log.Fatalf("error loading environment variables. message: %v", err)
 	}
 
@@ -21,3 +96,159 @@ func LoadEnvironment() {
 func LoadAPIKey() {
 	APIKEY = os.Getenv("MORALIS_API_KEY")
 }
+
+func LoadOverridesFile() {
+	OVERRIDESFILE = os.Getenv("PRICE_OVERRIDES_FILE")
+	if OVERRIDESFILE == "" {
+		OVERRIDESFILE = "price-overrides.jsonl"
+	}
+}
+
+func LoadRPCEndpoints() {
+	RPCURLS["eth"] = os.Getenv("ETH_RPC_URL")
+	RPCURLS["arbitrum"] = os.Getenv("ARBITRUM_RPC_URL")
+	RPCURLS["polygon"] = os.Getenv("POLYGON_RPC_URL")
+	RPCURLS["bsc"] = os.Getenv("BSC_RPC_URL")
+	RPCURLS["fantom"] = os.Getenv("FANTOM_RPC_URL")
+	RPCURLS["avalanche"] = os.Getenv("AVALANCHE_RPC_URL")
+	RPCURLS["cronos"] = os.Getenv("CRONOS_RPC_URL")
+}
+
+func LoadSpamListFile() {
+	SPAMLISTFILE = os.Getenv("SPAM_LIST_FILE")
+	if SPAMLISTFILE == "" {
+		SPAMLISTFILE = "spam-list.json"
+	}
+}
+
+func LoadBeaconURL() {
+	BEACONURL = os.Getenv("BEACON_API_URL")
+}
+
+func LoadStakingEndpoints() {
+	AVALANCHEPCHAINURL = os.Getenv("AVALANCHE_P_CHAIN_URL")
+	CRONOSPOSURL = os.Getenv("CRONOS_POS_LCD_URL")
+}
+
+func LoadConsensus() {
+	chains := map[string]string{
+		"eth":       "ETH_CONSENSUS_RPC_URLS",
+		"arbitrum":  "ARBITRUM_CONSENSUS_RPC_URLS",
+		"polygon":   "POLYGON_CONSENSUS_RPC_URLS",
+		"bsc":       "BSC_CONSENSUS_RPC_URLS",
+		"fantom":    "FANTOM_CONSENSUS_RPC_URLS",
+		"avalanche": "AVALANCHE_CONSENSUS_RPC_URLS",
+		"cronos":    "CRONOS_CONSENSUS_RPC_URLS",
+	}
+
+	for chain, variable := range chains {
+		CONSENSUSRPCURLS[chain] = nil
+
+		for _, url := range strings.Split(os.Getenv(variable), ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				CONSENSUSRPCURLS[chain] = append(CONSENSUSRPCURLS[chain], url)
+			}
+		}
+	}
+
+	QUORUM = 2
+	if quorum, err := strconv.Atoi(os.Getenv("BALANCE_QUORUM")); err == nil && quorum > 0 {
+		QUORUM = quorum
+	}
+}
+
+func LoadEvidence() {
+	PROVIDERMODE = os.Getenv("PROVIDER_MODE")
+	if PROVIDERMODE == "" {
+		PROVIDERMODE = "live"
+	}
+
+	EVIDENCEDIR = os.Getenv("EVIDENCE_DIR")
+	if EVIDENCEDIR == "" {
+		EVIDENCEDIR = "evidence"
+	}
+}
+
+func LoadRuns() {
+	RUNSDIR = os.Getenv("RUNS_DIR")
+	if RUNSDIR == "" {
+		RUNSDIR = "runs"
+	}
+
+	EVIDENCEKEYFILE = os.Getenv("EVIDENCE_SIGNING_KEY_FILE")
+	EVIDENCEPUBLICKEY = os.Getenv("EVIDENCE_PUBLIC_KEY")
+}
+
+func LoadAuth() {
+	AUTHKEYSFILE = os.Getenv("AUTH_KEYS_FILE")
+	if AUTHKEYSFILE == "" {
+		AUTHKEYSFILE = "api-keys.json"
+	}
+
+	USAGEFILE = os.Getenv("API_USAGE_FILE")
+	if USAGEFILE == "" {
+		USAGEFILE = "api-usage.json"
+	}
+
+	AUTHDISABLED = os.Getenv("AUTH_DISABLED") == "true"
+	SESSIONTTL = time.Duration(floatVariable("SESSION_TTL_HOURS", 8) * float64(time.Hour))
+	if SESSIONTTL <= 0 {
+		SESSIONTTL = 8 * time.Hour
+	}
+
+	CORSORIGINS = os.Getenv("CORS_ALLOWED_ORIGINS")
+	if CORSORIGINS == "" {
+		CORSORIGINS = "http://localhost:5500,http://127.0.0.1:5500"
+	}
+}
+
+func LoadScheduler() {
+	MORALISCONCURRENCY = intVariable("MORALIS_MAX_CONCURRENCY", 10)
+	MORALISRPS = floatVariable("MORALIS_REQUESTS_PER_SECOND", 20)
+	MORALISCUPS = floatVariable("MORALIS_COMPUTE_UNITS_PER_SECOND", 0)
+	PROVIDERCONCURRENCY = intVariable("PROVIDER_MAX_CONCURRENCY", 8)
+	PROVIDERRPS = floatVariable("PROVIDER_REQUESTS_PER_SECOND", 25)
+	PROVIDERTIMEOUT = seconds("PROVIDER_TIMEOUT_SECONDS", 30)
+	PROVIDERRETRIES = intVariable("PROVIDER_RETRIES", 3)
+	BREAKERTHRESHOLD = intVariable("PROVIDER_BREAKER_THRESHOLD", 5)
+	BREAKERCOOLDOWN = seconds("PROVIDER_BREAKER_COOLDOWN_SECONDS", 30)
+	WALLETWORKERS = intVariable("WALLET_WORKERS", 4)
+	TOKENWORKERS = intVariable("TOKEN_WORKERS", 8)
+}
+
+// Returns the variable as a non-negative integer, or the fallback when it is unset or invalid.
+func intVariable(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 0 {
+		return fallback
+	}
+
+	return value
+}
+
+// Returns the variable as a non-negative number, or the fallback when it is unset or invalid.
+func floatVariable(name string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil || value < 0 {
+		return fallback
+	}
+
+	return value
+}
+
+// Returns the variable, given in seconds, as a duration.
+func seconds(name string, fallback float64) time.Duration {
+	return time.Duration(floatVariable(name, fallback) * float64(time.Second))
+}
+
+func LoadCompleteness() {
+	COMPLETENESSMAXBLOCKS = intVariable("COMPLETENESS_MAX_BLOCKS", 5000000)
+	COMPLETENESSTIMEOUT = seconds("COMPLETENESS_TIMEOUT_SECONDS", 300)
+}
+
+func LoadConfirmationTemplate() {
+	CONFIRMATIONTEMPLATEFILE = os.Getenv("CONFIRMATION_TEMPLATE_FILE")
+	if CONFIRMATIONTEMPLATEFILE == "" {
+		CONFIRMATIONTEMPLATEFILE = "confirmation-template.json"
+	}
+}
@@ -2,6 +2,11 @@ package models
 
 import (
 	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
 )
 
 // Token balance response structure
@@ -16,6 +21,21 @@ type TokenBalance struct {
 	PossibleSpam bool   `json:"possible_spam"`
 }
 
+// NFT response structure
+type NFTBalance struct {
+	TokenAddress       string `json:"token_address"`
+	TokenID            string `json:"token_id"`
+	ContractType       string `json:"contract_type"`
+	OwnerOf            string `json:"owner_of"`
+	Amount             string `json:"amount"`
+	Name               string `json:"name"`
+	Symbol             string `json:"symbol"`
+	TokenURI           string `json:"token_uri"`
+	Metadata           string `json:"metadata"`
+	PossibleSpam       bool   `json:"possible_spam"`
+	VerifiedCollection bool   `json:"verified_collection"`
+}
+
 // Input file data structure for token balances
 type TokenFile struct {
 	Address string
@@ -34,19 +54,120 @@ type Request struct {
 	Chain     string `json:"chain"`
 	Date      string `json:"date"`
 	Timestamp string `json:"timestamp"`
+	// "full" adds the network-backed spam checks (transfers, liquidity, contract code)
+	SpamChecks string `json:"spam_checks"`
+	// First block scanned by the completeness check, defaults to genesis
+	FromBlock string `json:"from_block"`
+	// "true" decomposes lending, LP and staking receipt tokens into their underlying assets
+	Decompose string `json:"decompose"`
+	// "true" reports lending protocol debt as liability rows
+	Liabilities string `json:"liabilities"`
+	// Comma separated validator indices or pubkeys, required for validator balance requests
+	Validators string `json:"validators"`
+	// "true" adds native staking and delegation rows
+	Staking string `json:"staking"`
+	// Delegator address where stake is not held by the EVM address (Avalanche P-chain, Cronos POS)
+	StakingAddress string `json:"staking_address"`
+	// "true" reports shares and share rates for rebasing tokens
+	Shares string `json:"shares"`
+	// "true" reads each balance from every configured provider and applies the quorum rule
+	Consensus string `json:"consensus"`
+	// "true" values each row in USD, preferring manual price overrides
+	Prices string `json:"prices"`
+	// Comma separated custom vesting contracts to check for the address as beneficiary, each as "contract" or
+	// "contract:token" for contracts without token()
+	VestingContracts string `json:"vesting_contracts"`
+}
+
+// Incoming request body struct for setting a manual price override
+type OverrideRequest struct {
+	TokenAddress  string `json:"token_address"`
+	Chain         string `json:"chain"`
+	Date          string `json:"date"`
+	UsdPrice      string `json:"usd_price"`
+	Justification string `json:"justification"`
+	Author        string `json:"author"`
 }
 
 // The main response which will be returned to client
 type ClientResponse struct {
-	Address      string  `json:"account_address"`
-	Chain        string  `json:"chain"`
-	BlockNumber  int     `json:"block_number"`
-	Asset        string  `json:"asset_symbol"`
-	AssetName    string  `json:"asset_name"`
-	AssetAddress string  `json:"contract_address"`
-	Balance      float64 `json:"balance"`
-	CheckerUrl   string  `json:"checker_url"`
-	PossibleSpam bool    `json:"possible_spam"`
+	Address     string `json:"account_address"`
+	Chain       string `json:"chain"`
+	BlockNumber int    `json:"block_number"`
+	// "latest" for rows read at the chain's latest height because it keeps no historical state, such as Avalanche P-chain stake
+	ReadAt       string   `json:"read_at,omitempty"`
+	Asset        string   `json:"asset_symbol"`
+	AssetName    string   `json:"asset_name"`
+	AssetAddress string   `json:"contract_address"`
+	Balance      float64  `json:"balance"`
+	CheckerUrl   string   `json:"checker_url"`
+	PossibleSpam bool     `json:"possible_spam"`
+	SpamScore    int      `json:"spam_score"`
+	SpamReasons  []string `json:"spam_reasons"`
+	// curl command reproducing the balance read against any node of the chain, independent of the explorer
+	VerifyCommand string `json:"verify_command,omitempty"`
+	// Protocol position the token represents and the assets it is a claim to
+	Protocol   string            `json:"protocol,omitempty"`
+	Underlying []UnderlyingAsset `json:"underlying,omitempty"`
+	// Liability rows report the amount owed (principal plus accrued interest) as a positive balance
+	Liability       bool    `json:"liability"`
+	Principal       float64 `json:"principal,omitempty"`
+	AccruedInterest float64 `json:"accrued_interest,omitempty"`
+	HealthFactor    float64 `json:"health_factor,omitempty"`
+	// Native stake delegated to a validator
+	Validator        string  `json:"validator,omitempty"`
+	UnclaimedRewards float64 `json:"unclaimed_rewards,omitempty"`
+	Note             string  `json:"note,omitempty"`
+	// Rebasing and share-based tokens: shares held and balance per share at the block
+	ShareModel string  `json:"share_model,omitempty"`
+	Shares     float64 `json:"shares,omitempty"`
+	ShareRate  float64 `json:"share_rate,omitempty"`
+	// Valuation at the cut-off when prices are requested
+	UsdPrice    float64 `json:"usd_price,omitempty"`
+	UsdValue    float64 `json:"usd_value,omitempty"`
+	PriceSource string  `json:"price_source,omitempty"`
+	// Multi-provider consensus: quorum outcome and each provider's raw balance and block hash
+	Consensus        string            `json:"consensus,omitempty"`
+	ProviderBalances []ProviderBalance `json:"provider_balances,omitempty"`
+	// Steps that failed for the row without failing the run, such as pricing or decomposition
+	Errors []*faults.Error `json:"errors,omitempty"`
+}
+
+// Raw balance a single provider reported and the hash of the block it read
+type ProviderBalance struct {
+	Provider  string `json:"provider"`
+	Balance   string `json:"raw_balance"`
+	BlockHash string `json:"block_hash"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Asset a DeFi position decomposes into at the block
+type UnderlyingAsset struct {
+	TokenAddress string  `json:"contract_address"`
+	Symbol       string  `json:"asset_symbol"`
+	Decimals     int     `json:"decimals"`
+	RawAmount    string  `json:"raw_amount"`
+	Amount       float64 `json:"amount"`
+	Liability    bool    `json:"liability"`
+}
+
+// NFT holding returned to client, kept separate from fungible balances
+type NFTResponse struct {
+	Address          string `json:"account_address"`
+	Chain            string `json:"chain"`
+	BlockNumber      int    `json:"block_number"`
+	ContractAddress  string `json:"contract_address"`
+	ContractType     string `json:"contract_type"`
+	CollectionName   string `json:"collection_name"`
+	CollectionSymbol string `json:"collection_symbol"`
+	TokenID          string `json:"token_id"`
+	Quantity         string `json:"quantity"`
+	TokenURI         string `json:"token_uri"`
+	Metadata         string `json:"metadata"`
+	PossibleSpam     bool   `json:"possible_spam"`
+	// Ownership confirmed by ownerOf/balanceOf at the block
+	OwnershipVerified bool   `json:"ownership_verified"`
+	VerificationError string `json:"verification_error,omitempty"`
 }
 
 const (
@@ -172,3 +293,26 @@ func ReturnInfo(chain string) (string, string, string, string, error) {
 
 	return asset, checkerUrl, tokenName, tokenCheckerUrl, nil
 }
+
+// Returns the explorer's check tool pre-filled with the wallet, the block and, for the token check tool, the contract,
+// so a reviewer can confirm a row without retyping it.
+func CheckerLink(checkerUrl, address, tokenAddress string, block int) string {
+	params := url.Values{}
+	params.Set("a", address)
+	params.Set("b", strconv.Itoa(block))
+
+	if strings.HasSuffix(checkerUrl, "tokencheck-tool") {
+		params.Set("t", tokenAddress)
+	}
+
+	return checkerUrl + "?" + params.Encode()
+}
+
+// Returns the block the row was read at for printing, or "latest" for rows read at the chain's latest height.
+func (r ClientResponse) Block() string {
+	if r.ReadAt != "" {
+		return r.ReadAt
+	}
+
+	return strconv.Itoa(r.BlockNumber)
+}
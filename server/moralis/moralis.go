@@ -0,0 +1,201 @@
+package moralis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/recorder"
+)
+
+// Base URL of the Moralis API. Tests point it at a local server.
+var API = "https://deep-index.moralis.io/api/v2"
+
+// Name providers are reported under in errors
+const provider = "moralis"
+
+// Pages read from one list before giving up, so a cursor that never ends cannot loop forever
+const maxPages = 1000
+
+// Page of a cursor paginated list endpoint. Total is only reported by some endpoints.
+type Page struct {
+	Cursor   string            `json:"cursor"`
+	Page     int               `json:"page"`
+	PageSize int               `json:"page_size"`
+	Total    *int              `json:"total"`
+	Result   []json.RawMessage `json:"result"`
+}
+
+// Returns the body of a successful GET of the endpoint, or the error Moralis reported.
+func Get(ctx context.Context, endpoint string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("X-API-Key", initialisers.APIKEY)
+
+	resp, err := recorder.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// error bodies would otherwise be read as empty balances
+	if resp.StatusCode != http.StatusOK {
+		return nil, faults.Response(provider, resp.StatusCode, body)
+	}
+
+	return body, nil
+}
+
+// Reads the pages of a list endpoint in order, following cursors, and passes each page's items to fn until fn
+// returns false or the last page is read. Endpoints that answer with a bare array are a single page. Returns the
+// responses as received: the body of a single page, or a JSON array of the pages' bodies.
+func Pages(ctx context.Context, endpoint string, fn func(items []json.RawMessage) (bool, error)) ([]byte, error) {
+	var bodies []json.RawMessage
+	var cursor string
+
+	items := 0
+
+	for {
+		if len(bodies) == maxPages {
+			return nil, faults.Newf(faults.ProviderInvalidResponse, "", "%v returned more than %v pages for %v", provider, maxPages, endpoint)
+		}
+
+		next, err := withCursor(endpoint, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := Get(ctx, next)
+		if err != nil {
+			return nil, err
+		}
+
+		bodies = append(bodies, body)
+
+		page, err := parsePage(body)
+		if err != nil {
+			return nil, err
+		}
+
+		items += len(page.Result)
+
+		more, err := fn(page.Result)
+		if err != nil {
+			return nil, err
+		}
+
+		if !more {
+			break
+		}
+
+		if page.Cursor == "" {
+			// the list was read to the end, so it must hold every item Moralis counted
+			if page.Total != nil && items < *page.Total {
+				return nil, faults.Newf(faults.ProviderInvalidResponse, "", "%v returned %v of %v items for %v", provider, items, *page.Total, endpoint)
+			}
+
+			break
+		}
+
+		if page.Cursor == cursor {
+			return nil, faults.Newf(faults.ProviderInvalidResponse, "", "%v returned the same cursor twice for %v", provider, endpoint)
+		}
+
+		cursor = page.Cursor
+	}
+
+	if len(bodies) == 1 {
+		return bodies[0], nil
+	}
+
+	return json.Marshal(bodies)
+}
+
+// Returns every item of a list endpoint along with the responses as received.
+func List(ctx context.Context, endpoint string) ([]json.RawMessage, []byte, error) {
+	var all []json.RawMessage
+
+	raw, err := Pages(ctx, endpoint, func(items []json.RawMessage) (bool, error) {
+		all = append(all, items...)
+		return true, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return all, raw, nil
+}
+
+// Returns the endpoint with the cursor of the page to read, or unchanged for the first page.
+func withCursor(endpoint, cursor string) (string, error) {
+	if cursor == "" {
+		return endpoint, nil
+	}
+
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := parsed.Query()
+	query.Set("cursor", cursor)
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String(), nil
+}
+
+// Parses a list response: a page object, or a bare array for endpoints that are not paginated. An object without a
+// result is an error Moralis returned with a successful status.
+func parsePage(body []byte) (Page, error) {
+	trimmed := bytes.TrimSpace(body)
+
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var items []json.RawMessage
+
+		err := json.Unmarshal(trimmed, &items)
+		if err != nil {
+			return Page{}, faults.New(faults.ProviderInvalidResponse, "", err)
+		}
+
+		return Page{Result: items}, nil
+	}
+
+	var fields map[string]json.RawMessage
+
+	err := json.Unmarshal(trimmed, &fields)
+	if err != nil {
+		return Page{}, faults.New(faults.ProviderInvalidResponse, "", err)
+	}
+
+	if _, ok := fields["result"]; !ok {
+		if _, ok := fields["message"]; ok {
+			return Page{}, faults.Response(provider, http.StatusOK, body)
+		}
+
+		return Page{}, faults.Newf(faults.ProviderInvalidResponse, "", "%v list response has no result", provider)
+	}
+
+	var page Page
+
+	err = json.Unmarshal(trimmed, &page)
+	if err != nil {
+		return Page{}, faults.New(faults.ProviderInvalidResponse, "", err)
+	}
+
+	return page, nil
+}
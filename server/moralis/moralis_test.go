@@ -0,0 +1,168 @@
+package moralis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+)
+
+// Serves the bodies by the cursor requested, the first page having no cursor, and records the cursors read.
+func serve(t *testing.T, pages map[string]string, cursors *[]string) string {
+	t.Helper()
+
+	initialisers.APIKEY = "test-key"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message":"Invalid key"}`))
+			return
+		}
+
+		cursor := r.URL.Query().Get("cursor")
+		*cursors = append(*cursors, cursor)
+
+		body, ok := pages[cursor]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message":"unknown cursor"}`))
+			return
+		}
+
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL + "/0x1/erc20?chain=eth"
+}
+
+func TestPages(t *testing.T) {
+	tests := []struct {
+		name    string
+		pages   map[string]string
+		items   int
+		cursors []string
+		err     string
+	}{
+		{"single page", map[string]string{"": `{"cursor":null,"result":[1,2]}`}, 2, []string{""}, ""},
+		{"bare array", map[string]string{"": `[1,2,3]`}, 3, []string{""}, ""},
+		{"follows cursors", map[string]string{
+			"":  `{"cursor":"a","result":[1,2]}`,
+			"a": `{"cursor":"b","result":[3]}`,
+			"b": `{"cursor":"","total":4,"result":[4]}`,
+		}, 4, []string{"", "a", "b"}, ""},
+		{"short of total", map[string]string{"": `{"cursor":"","total":5,"result":[1,2]}`}, 0, nil, "returned 2 of 5 items"},
+		{"repeated cursor", map[string]string{"": `{"cursor":"a","result":[1]}`, "a": `{"cursor":"a","result":[2]}`}, 0, nil, "same cursor twice"},
+		{"error with success status", map[string]string{"": `{"message":"Rate limit exceeded"}`}, 0, nil, "Rate limit exceeded"},
+		{"no result", map[string]string{"": `{"cursor":""}`}, 0, nil, "has no result"},
+		{"not json", map[string]string{"": `<html>`}, 0, nil, "invalid character"},
+		{"error status", map[string]string{}, 0, nil, "unknown cursor"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var cursors []string
+			endpoint := serve(t, test.pages, &cursors)
+
+			items, raw, err := List(context.Background(), endpoint)
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("List() error = %v, want %q", err, test.err)
+				}
+
+				var failure *faults.Error
+				if !errors.As(err, &failure) {
+					t.Errorf("List() error %T is not a catalogued fault", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(items) != test.items {
+				t.Errorf("List() returned %v items, want %v", len(items), test.items)
+			}
+
+			if strings.Join(cursors, ",") != strings.Join(test.cursors, ",") {
+				t.Errorf("cursors read = %q, want %q", cursors, test.cursors)
+			}
+
+			// a single page is kept as received, several as an array of the pages
+			if len(test.cursors) == 1 && string(raw) != test.pages[""] {
+				t.Errorf("raw = %s, want the page as received", raw)
+			}
+
+			var bodies []json.RawMessage
+			if len(test.cursors) > 1 && (json.Unmarshal(raw, &bodies) != nil || len(bodies) != len(test.cursors)) {
+				t.Errorf("raw = %s, want %v pages", raw, len(test.cursors))
+			}
+		})
+	}
+}
+
+func TestPagesStopsWhenAsked(t *testing.T) {
+	var cursors []string
+	endpoint := serve(t, map[string]string{"": `{"cursor":"a","result":[1]}`, "a": `{"cursor":"","result":[2]}`}, &cursors)
+
+	_, err := Pages(context.Background(), endpoint, func(items []json.RawMessage) (bool, error) {
+		return false, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cursors) != 1 {
+		t.Errorf("read %v pages after the first asked to stop", len(cursors))
+	}
+}
+
+func TestWithCursor(t *testing.T) {
+	tests := []struct {
+		endpoint, cursor, want string
+	}{
+		{API + "/0x1/erc20?chain=eth", "", API + "/0x1/erc20?chain=eth"},
+		{API + "/0x1/erc20?chain=eth", "a+b/c=", API + "/0x1/erc20?chain=eth&cursor=a%2Bb%2Fc%3D"},
+		{API + "/0x1/erc20?chain=eth&cursor=old", "new", API + "/0x1/erc20?chain=eth&cursor=new"},
+	}
+
+	for _, test := range tests {
+		got, err := withCursor(test.endpoint, test.cursor)
+		if err != nil || got != test.want {
+			t.Errorf("withCursor(%v, %q) = %v, %v, want %v", test.endpoint, test.cursor, got, err, test.want)
+		}
+	}
+}
+
+func TestTokenBalance(t *testing.T) {
+	const token = "0xdac17f958d2ee523a2206206994597c13d831ec7"
+
+	tests := []struct {
+		name, item, err string
+	}{
+		{"valid", `{"token_address":"` + token + `","balance":"1000","decimals":6}`, ""},
+		{"bad address", `{"token_address":"0x1","balance":"1000","decimals":6}`, "invalid token_address"},
+		{"negative balance", `{"token_address":"` + token + `","balance":"-1","decimals":6}`, "invalid balance"},
+		{"decimal balance", `{"token_address":"` + token + `","balance":"1.5","decimals":6}`, "invalid balance"},
+		{"bad decimals", `{"token_address":"` + token + `","balance":"1","decimals":300}`, "invalid decimals"},
+		{"not an object", `"balance"`, "cannot unmarshal"},
+	}
+
+	for _, test := range tests {
+		_, err := TokenBalance(json.RawMessage(test.item))
+
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%v: TokenBalance() error = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
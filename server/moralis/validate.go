@@ -0,0 +1,80 @@
+package moralis
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+)
+
+var address = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+var amount = regexp.MustCompile(`^[0-9]+$`)
+
+// Reports whether the value is a 20 byte hex address.
+func IsAddress(value string) bool {
+	return address.MatchString(value)
+}
+
+// Reports whether the value is a non-negative integer amount in base units, as Moralis reports balances.
+func IsAmount(value string) bool {
+	return amount.MatchString(value)
+}
+
+// Decodes and checks one item of an /erc20 response.
+func TokenBalance(item json.RawMessage) (models.TokenBalance, error) {
+	var balance models.TokenBalance
+
+	err := json.Unmarshal(item, &balance)
+	if err != nil {
+		return balance, faults.New(faults.ProviderInvalidResponse, faults.StepTokenBalances, err)
+	}
+
+	switch {
+	case !IsAddress(balance.TokenAddress):
+		return balance, faults.Newf(faults.ProviderInvalidResponse, faults.StepTokenBalances, "token balance has invalid token_address %q", balance.TokenAddress)
+	case !IsAmount(balance.Balance):
+		return balance, faults.Newf(faults.ProviderInvalidResponse, faults.StepTokenBalances, "token %v has invalid balance %q", balance.TokenAddress, balance.Balance)
+	case balance.Decimals < 0 || balance.Decimals > 255:
+		return balance, faults.Newf(faults.ProviderInvalidResponse, faults.StepTokenBalances, "token %v has invalid decimals %v", balance.TokenAddress, balance.Decimals)
+	}
+
+	return balance, nil
+}
+
+// Decodes and checks one item of an /nft response.
+func NFTBalance(item json.RawMessage) (models.NFTBalance, error) {
+	var balance models.NFTBalance
+
+	err := json.Unmarshal(item, &balance)
+	if err != nil {
+		return balance, faults.New(faults.ProviderInvalidResponse, faults.StepNFTs, err)
+	}
+
+	switch {
+	case !IsAddress(balance.TokenAddress):
+		return balance, faults.Newf(faults.ProviderInvalidResponse, faults.StepNFTs, "nft has invalid token_address %q", balance.TokenAddress)
+	case !IsAmount(balance.TokenID):
+		return balance, faults.Newf(faults.ProviderInvalidResponse, faults.StepNFTs, "nft %v has invalid token_id %q", balance.TokenAddress, balance.TokenID)
+	case balance.Amount != "" && !IsAmount(balance.Amount):
+		return balance, faults.Newf(faults.ProviderInvalidResponse, faults.StepNFTs, "nft %v #%v has invalid amount %q", balance.TokenAddress, balance.TokenID, balance.Amount)
+	}
+
+	return balance, nil
+}
+
+// Decodes and checks a /balance response.
+func NativeBalance(body []byte) (models.NativeBalance, error) {
+	var balance *models.NativeBalance
+
+	err := json.Unmarshal(body, &balance)
+	if err != nil {
+		return models.NativeBalance{}, faults.New(faults.ProviderInvalidResponse, faults.StepNativeBalance, err)
+	}
+
+	if balance == nil || !IsAmount(balance.Balance) {
+		return models.NativeBalance{}, faults.Newf(faults.ProviderInvalidResponse, faults.StepNativeBalance, "%v returned no valid balance", provider)
+	}
+
+	return *balance, nil
+}
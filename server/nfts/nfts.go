@@ -0,0 +1,120 @@
+package nfts
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/moralis"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+const (
+	ERC721  = "ERC721"
+	ERC1155 = "ERC1155"
+)
+
+// Returns the NFTs held by the address at the block, with ownership verified on-chain at that block.
+func Holdings(ctx context.Context, address, chain string, block int) ([]models.NFTResponse, error) {
+	balances, err := List(ctx, address, chain, block)
+	if err != nil {
+		return nil, err
+	}
+
+	holdings := []models.NFTResponse{}
+
+	for _, nft := range balances {
+		holding := models.NFTResponse{
+			Address:          address,
+			Chain:            chain,
+			BlockNumber:      block,
+			ContractAddress:  nft.TokenAddress,
+			ContractType:     nft.ContractType,
+			CollectionName:   nft.Name,
+			CollectionSymbol: nft.Symbol,
+			TokenID:          nft.TokenID,
+			Quantity:         nft.Amount,
+			TokenURI:         nft.TokenURI,
+			Metadata:         nft.Metadata,
+			PossibleSpam:     nft.PossibleSpam,
+		}
+
+		quantity, err := verify(ctx, address, chain, block, nft)
+		if err != nil {
+			holding.VerificationError = err.Error()
+		} else {
+			holding.OwnershipVerified = quantity.Sign() > 0
+			holding.Quantity = quantity.String()
+
+			if !holding.OwnershipVerified {
+				holding.VerificationError = "address did not own the token at the block"
+			}
+		}
+
+		holdings = append(holdings, holding)
+	}
+
+	return holdings, nil
+}
+
+// Confirms ownership at the block, returning the quantity held (1 or 0 for ERC721).
+func verify(ctx context.Context, address, chain string, block int, nft models.NFTBalance) (*big.Int, error) {
+	id, ok := new(big.Int).SetString(nft.TokenID, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid token id %q", nft.TokenID)
+	}
+
+	switch strings.ToUpper(nft.ContractType) {
+	case ERC721:
+		result, err := rpc.EthCall(ctx, chain, nft.TokenAddress, rpc.EncodeCall("ownerOf(uint256)", rpc.EncodeUint(id)), block)
+		if err != nil {
+			return nil, err
+		}
+
+		words := rpc.Words(result)
+		if len(words) == 0 {
+			return nil, fmt.Errorf("empty ownerOf response")
+		}
+
+		if strings.EqualFold(rpc.DecodeAddress(words[0]), address) {
+			return big.NewInt(1), nil
+		}
+
+		return big.NewInt(0), nil
+	case ERC1155:
+		result, err := rpc.EthCall(ctx, chain, nft.TokenAddress, rpc.EncodeCall("balanceOf(address,uint256)", rpc.EncodeAddress(address), rpc.EncodeUint(id)), block)
+		if err != nil {
+			return nil, err
+		}
+
+		return rpc.DecodeUintAt(result, 0)
+	}
+
+	return nil, fmt.Errorf("unsupported contract type %q", nft.ContractType)
+}
+
+// Retrieves every page of NFTs Moralis holds for the address up to the block, without on-chain verification.
+func List(ctx context.Context, address, chain string, block int) ([]models.NFTBalance, error) {
+	endpoint := fmt.Sprintf("%v/%v/nft?chain=%v&to_block=%v&format=decimal&normalizeMetadata=false", moralis.API, address, chain, block)
+
+	items, _, err := moralis.List(ctx, endpoint)
+	if err != nil {
+		return nil, faults.Wrap(faults.ProviderFailed, faults.StepNFTs, err)
+	}
+
+	var balances []models.NFTBalance
+
+	for _, item := range items {
+		balance, err := moralis.NFTBalance(item)
+		if err != nil {
+			return nil, err
+		}
+
+		balances = append(balances, balance)
+	}
+
+	return balances, nil
+}
@@ -0,0 +1,127 @@
+package nfts
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/moralis"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc/rpctest"
+)
+
+// Serves the body for every Moralis request until the test ends.
+func serve(t *testing.T, status int, body string) {
+	t.Helper()
+
+	saved := moralis.API
+	t.Cleanup(func() { moralis.API = saved })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	moralis.API = server.URL
+}
+
+func TestHoldings(t *testing.T) {
+	holder, other := "0x"+strings.Repeat("1", 40), "0x"+strings.Repeat("2", 40)
+	collection := "0x" + strings.Repeat("3", 40)
+
+	tests := []struct {
+		name         string
+		contractType string
+		setup        func(node *rpctest.Node)
+		verified     bool
+		quantity     string
+		// part of the verification error expected, empty when there is none
+		err string
+	}{
+		{"erc721 owned", ERC721, func(node *rpctest.Node) {
+			node.Return(collection, "ownerOf(uint256)", nil, rpc.EncodeAddress(holder))
+		}, true, "1", ""},
+		{"erc721 owned by another address", ERC721, func(node *rpctest.Node) {
+			node.Return(collection, "ownerOf(uint256)", nil, rpc.EncodeAddress(other))
+		}, false, "0", "did not own"},
+		{"erc721 with no owner data", ERC721, func(node *rpctest.Node) {
+			node.Return(collection, "ownerOf(uint256)", nil)
+		}, false, "1", "empty ownerOf response"},
+		{"erc721 read fails at the node", ERC721, func(node *rpctest.Node) {
+			node.Fail(collection, "ownerOf(uint256)")
+		}, false, "1", "503"},
+		{"erc1155 balance", ERC1155, func(node *rpctest.Node) {
+			node.Return(collection, "balanceOf(address,uint256)", nil, rpc.EncodeUint(big.NewInt(3)))
+		}, true, "3", ""},
+		{"erc1155 reverts", ERC1155, func(node *rpctest.Node) {}, false, "1", "execution reverted"},
+		{"unsupported standard", "CRYPTOPUNKS", func(node *rpctest.Node) {}, false, "1", "unsupported contract type"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serve(t, http.StatusOK, `{"cursor":null,"result":[{"token_address":"`+collection+`","token_id":"7","amount":"1","contract_type":"`+test.contractType+`"}]}`)
+
+			node := rpctest.New(t, "eth")
+			test.setup(node)
+
+			holdings, err := Holdings(context.Background(), holder, "eth", 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(holdings) != 1 {
+				t.Fatalf("Holdings() = %+v, want one holding", holdings)
+			}
+
+			holding := holdings[0]
+
+			if holding.OwnershipVerified != test.verified || holding.Quantity != test.quantity || holding.TokenID != "7" || holding.BlockNumber != 100 {
+				t.Errorf("Holdings() = %+v, want verified %v with quantity %v", holding, test.verified, test.quantity)
+			}
+
+			if test.err == "" && holding.VerificationError != "" || !strings.Contains(holding.VerificationError, test.err) {
+				t.Errorf("verification error = %q, want %q", holding.VerificationError, test.err)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		items  int
+		err    bool
+	}{
+		{"no nfts", http.StatusOK, `{"cursor":null,"result":[]}`, 0, false},
+		{"nft", http.StatusOK, `{"cursor":null,"result":[{"token_address":"0x` + strings.Repeat("3", 40) + `","token_id":"7","contract_type":"ERC721"}]}`, 1, false},
+		{"invalid token address", http.StatusOK, `{"cursor":null,"result":[{"token_address":"0x3","token_id":"7"}]}`, 0, true},
+		{"invalid token id", http.StatusOK, `{"cursor":null,"result":[{"token_address":"0x` + strings.Repeat("3", 40) + `","token_id":"seven"}]}`, 0, true},
+		{"provider error", http.StatusTooManyRequests, `{"message":"Rate limit exceeded"}`, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serve(t, test.status, test.body)
+
+			balances, err := List(context.Background(), "0x"+strings.Repeat("1", 40), "eth", 100)
+
+			if test.err {
+				if err == nil {
+					t.Fatalf("List() = %+v, want an error", balances)
+				}
+
+				return
+			}
+
+			if err != nil || len(balances) != test.items {
+				t.Errorf("List() = %+v, %v, want %v nfts", balances, err, test.items)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package overrides
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+)
+
+const (
+	// Date format overrides are keyed on
+	DateFormat = "2006-01-02"
+
+	// Label printed against valuations which use an override
+	Source = "manual override"
+)
+
+// Manual price set by an auditor for assets without a reliable market price (private placements, delisted tokens)
+type Override struct {
+	TokenAddress  string    `json:"token_address"`
+	Chain         string    `json:"chain"`
+	Date          string    `json:"date"`
+	UsdPrice      float64   `json:"usd_price"`
+	Justification string    `json:"justification"`
+	Author        string    `json:"author"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Guards the overrides file. Overrides are appended and never rewritten so the file doubles as the audit trail.
+var mu sync.Mutex
+
+// Validates and appends an override to the overrides file. The latest override for a token, chain and date is the one applied.
+func Set(o Override) (Override, error) {
+	o.TokenAddress = strings.ToLower(strings.TrimSpace(o.TokenAddress))
+	o.Justification = strings.TrimSpace(o.Justification)
+	o.Author = strings.TrimSpace(o.Author)
+
+	if o.TokenAddress == "" {
+		return Override{}, errors.New("token address is required")
+	}
+
+	chain, err := models.DetermineChain(strings.TrimSpace(o.Chain))
+	if err != nil {
+		return Override{}, err
+	}
+
+	o.Chain = chain
+
+	if _, err := time.Parse(DateFormat, o.Date); err != nil {
+		return Override{}, fmt.Errorf("date must be in %v format: %v", DateFormat, err)
+	}
+
+	if o.UsdPrice < 0 {
+		return Override{}, errors.New("usd price cannot be negative")
+	}
+
+	if o.Justification == "" {
+		return Override{}, errors.New("a justification is required for every price override")
+	}
+
+	if o.Author == "" {
+		return Override{}, errors.New("an author is required for every price override")
+	}
+
+	o.CreatedAt = time.Now().UTC()
+
+	line, err := json.Marshal(o)
+	if err != nil {
+		return Override{}, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	file, err := os.OpenFile(initialisers.OVERRIDESFILE, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return Override{}, fmt.Errorf("error opening overrides file: %v", err)
+	}
+
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return Override{}, fmt.Errorf("error writing overrides file: %v", err)
+	}
+
+	return o, nil
+}
+
+// Returns the override applying to the token on the chain and date, if any.
+func Lookup(tokenAddress, chain, date string) (Override, bool, error) {
+	history, err := History(tokenAddress, chain, date)
+	if err != nil {
+		return Override{}, false, err
+	}
+
+	if len(history) == 0 {
+		return Override{}, false, nil
+	}
+
+	return history[len(history)-1], true, nil
+}
+
+// Returns every override recorded in the order they were set. Empty filters match everything.
+func History(tokenAddress, chain, date string) ([]Override, error) {
+	tokenAddress = strings.ToLower(strings.TrimSpace(tokenAddress))
+
+	if blockchain, err := models.DetermineChain(chain); err == nil {
+		chain = blockchain
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	file, err := os.Open(initialisers.OVERRIDESFILE)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Override{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening overrides file: %v", err)
+	}
+
+	defer file.Close()
+
+	history := []Override{}
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var o Override
+		if err := json.Unmarshal(scanner.Bytes(), &o); err != nil {
+			return nil, fmt.Errorf("error parsing overrides file: %v", err)
+		}
+
+		if tokenAddress != "" && o.TokenAddress != tokenAddress {
+			continue
+		}
+		if chain != "" && o.Chain != chain {
+			continue
+		}
+		if date != "" && o.Date != date {
+			continue
+		}
+
+		history = append(history, o)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading overrides file: %v", err)
+	}
+
+	return history, nil
+}
+
+// Describes the override for labelling valued rows in the output.
+func (o Override) Label() string {
+	return fmt.Sprintf("%v by %v on %v: %v", Source, o.Author, o.CreatedAt.Format(DateFormat), o.Justification)
+}
@@ -0,0 +1,105 @@
+package overrides
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+)
+
+func valid() Override {
+	return Override{TokenAddress: "0xABC", Chain: "eth", Date: "2024-03-31", UsdPrice: 1.5, Justification: "last placement round", Author: "auditor"}
+}
+
+func TestSet(t *testing.T) {
+	initialisers.OVERRIDESFILE = filepath.Join(t.TempDir(), "price-overrides.jsonl")
+
+	tests := []struct {
+		name   string
+		change func(o *Override)
+		err    string
+	}{
+		{"valid", func(o *Override) {}, ""},
+		{"no token", func(o *Override) { o.TokenAddress = " " }, "token address is required"},
+		{"unknown chain", func(o *Override) { o.Chain = "nowhere" }, "chain"},
+		{"bad date", func(o *Override) { o.Date = "31/03/2024" }, "date must be in"},
+		{"negative price", func(o *Override) { o.UsdPrice = -1 }, "cannot be negative"},
+		{"no justification", func(o *Override) { o.Justification = "" }, "justification is required"},
+		{"no author", func(o *Override) { o.Author = " " }, "author is required"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := valid()
+			test.change(&o)
+
+			_, err := Set(o)
+
+			if test.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("Set() error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	initialisers.OVERRIDESFILE = filepath.Join(t.TempDir(), "price-overrides.jsonl")
+
+	if _, ok, err := Lookup("0xabc", "eth", "2024-03-31"); ok || err != nil {
+		t.Fatalf("Lookup() without a file = %v, %v", ok, err)
+	}
+
+	first := valid()
+
+	second := valid()
+	second.UsdPrice = 2
+
+	other := valid()
+	other.Date = "2024-04-01"
+
+	for _, o := range []Override{first, second, other} {
+		if _, err := Set(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name, token, chain, date string
+		ok                       bool
+		price                    float64
+	}{
+		{"latest wins", "0xabc", "eth", "2024-03-31", true, 2},
+		{"address case ignored", "0xAbC", "eth", "2024-03-31", true, 2},
+		{"chain name", "0xabc", "ethereum", "2024-03-31", true, 2},
+		{"other date", "0xabc", "eth", "2024-04-01", true, 1.5},
+		{"no override for date", "0xabc", "eth", "2024-04-02", false, 0},
+		{"no override for chain", "0xabc", "bsc", "2024-03-31", false, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o, ok, err := Lookup(test.token, test.chain, test.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ok != test.ok || o.UsdPrice != test.price {
+				t.Errorf("Lookup() = %v, %v, want %v, %v", o.UsdPrice, ok, test.price, test.ok)
+			}
+		})
+	}
+
+	history, _ := History("", "", "")
+	if len(history) != 3 {
+		t.Errorf("History() has %v overrides, want 3", len(history))
+	}
+}
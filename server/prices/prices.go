@@ -1,17 +1,24 @@
 package prices
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
 
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
 	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/overrides"
+	"github.com/harrisandtrotter/proof-of-balance/server/recorder"
 )
 
 const (
 	MoralisAPI = "https://deep-index.moralis.io/api/v2"
+
+	// Label printed against valuations priced by Moralis
+	MoralisSource = "moralis"
 )
 
 type Price struct {
@@ -30,55 +37,98 @@ type Error struct {
 	Message string `json:"message"`
 }
 
-// Returns the price for the specified asset.
-func (p *Price) GetPrice(address, chain string, block int) float64 {
-
+// Returns Moralis's price for the asset at the block, or nil when no pool has enough liquidity to price it.
+func (p *Price) Fetch(ctx context.Context, address, chain string, block int) (*Price, error) {
 	url := fmt.Sprintf("%v/erc20/%v/price?chain=%v&to_block=%v", MoralisAPI, address, chain, block)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("X-API-Key", initialisers.APIKEY)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := recorder.Client.Do(req)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
+	defer resp.Body.Close()
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	var errorMessage Error
+
+	// error pages from proxies in front of Moralis are not JSON, so those are reported by their status below
+	err = json.Unmarshal(body, &errorMessage)
+	if err != nil && resp.StatusCode == http.StatusOK {
+		return nil, err
+	}
+
+	if strings.Contains(errorMessage.Message, "No pools found with enough liquidity") {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, faults.Response(MoralisSource, resp.StatusCode, body)
 	}
 
 	var data Price
-	var price float64
+
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// Reports whether Moralis can find a pool with enough liquidity to price the asset at the block.
+func (p *Price) HasLiquidity(ctx context.Context, address, chain string, block int) (bool, error) {
+	data, err := p.Fetch(ctx, address, chain, block)
+	if err != nil {
+		return false, err
+	}
+
+	return data != nil, nil
+}
+
+// Returns the price for the asset on the date (yyyy-mm-dd) and its source, preferring manual overrides. Assets Moralis
+// cannot price are valued at zero.
+func (p *Price) Valuation(ctx context.Context, address, chain string, block int, date string) (float64, string, error) {
+	override, ok, err := overrides.Lookup(address, chain, date)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if ok {
+		return override.UsdPrice, override.Label(), nil
+	}
+
+	data, err := p.Fetch(ctx, address, chain, block)
+	if err != nil {
+		return 0, "", err
 	}
 
-	msg := data.CheckError(body)
-	if strings.Contains(msg, "No pools found with enough liquidity, to calculate the price") {
-		fmt.Printf("\nCoin is not found. Coin is most likely spam!\nToken address: %v\n\n", address)
-		price = 0
-	} else {
-		price = data.UsdPrice
-		fmt.Printf("Exchange: %v\n", data.ExchangeName)
+	if data == nil {
+		return 0, MoralisSource + " (no liquid pool)", nil
 	}
 
-	return price
+	return data.UsdPrice, MoralisSource, nil
 }
 
-func (p *Price) CheckError(body []byte) string {
+// Returns the message of a Moralis error response.
+func (p *Price) CheckError(body []byte) (string, error) {
 	var errorMessage Error
 
 	err := json.Unmarshal(body, &errorMessage)
 	if err != nil {
-		panic(err)
+		return "", fmt.Errorf("error parsing price error response: %v", err)
 	}
 
-	return errorMessage.Message
+	return errorMessage.Message, nil
 }
@@ -0,0 +1,32 @@
+package prices
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/overrides"
+)
+
+func TestValuationPrefersOverride(t *testing.T) {
+	initialisers.OVERRIDESFILE = filepath.Join(t.TempDir(), "price-overrides.jsonl")
+
+	_, err := overrides.Set(overrides.Override{TokenAddress: "0xabc", Chain: "eth", Date: "2024-03-31", UsdPrice: 4.2, Justification: "delisted", Author: "auditor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var p Price
+
+	// an override is applied without asking Moralis, so the test needs no network
+	usdPrice, source, err := p.Valuation(context.Background(), "0xABC", "eth", 100, "2024-03-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if usdPrice != 4.2 || !strings.HasPrefix(source, overrides.Source) || !strings.Contains(source, "delisted") {
+		t.Errorf("Valuation() = %v, %q", usdPrice, source)
+	}
+}
@@ -0,0 +1,44 @@
+package progress
+
+import (
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+)
+
+// Event types, in the order a wallet's run sends them
+const (
+	Started   = "started"
+	Block     = "block"
+	Balances  = "balances"
+	Row       = "row"
+	Completed = "completed"
+	Failed    = "failed"
+	// sent once every wallet has finished
+	Done = "done"
+)
+
+// Progress of a run: which wallet it is for, what has just completed and any row produced
+type Event struct {
+	Type    string `json:"type"`
+	Wallet  int    `json:"wallet,omitempty"`
+	Wallets int    `json:"wallets,omitempty"`
+	Address string `json:"address,omitempty"`
+	Chain   string `json:"chain,omitempty"`
+	// Block the balances are read at, and the number of token balances held there
+	BlockNumber int `json:"block_number,omitempty"`
+	Tokens      int `json:"tokens,omitempty"`
+	// Rows completed so far for the wallet, including this one
+	Rows  int                    `json:"rows,omitempty"`
+	Row   *models.ClientResponse `json:"row,omitempty"`
+	RunID string                 `json:"run_id,omitempty"`
+	Error *faults.Error          `json:"error,omitempty"`
+}
+
+// Receives events as a run progresses. A nil Reporter discards them.
+type Reporter func(Event)
+
+func (r Reporter) Send(event Event) {
+	if r != nil {
+		r(event)
+	}
+}
@@ -0,0 +1,178 @@
+package rebasing
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+// Share accounting models
+const (
+	LidoShares   = "lido shares"
+	AaveScaled   = "aave scaled balance"
+	Ampleforth   = "ampleforth scaled balance"
+	OlympusIndex = "olympus gOHM equivalent"
+)
+
+// Known rebasing tokens keyed by chain and lowercase contract address
+var known = map[string]map[string]string{
+	"eth": {
+		"0xae7ab96520de3a18e5e111b5eaab095312d7fe84": LidoShares,
+		"0xd46ba6d942050d489dbd938a2c909a5d5039a161": Ampleforth,
+		"0x04906695d6d12cf5459975d7c3c03356e4ccd460": OlympusIndex,
+	},
+}
+
+// Underlying shares behind a rebasing balance at the block
+type Shares struct {
+	Model string
+	// Shares held, in the token's decimals
+	Shares  float64
+	Balance float64
+	// Balance per share at the block. Reconciling shares * rate to the balance explains rebasing variances.
+	ShareRate float64
+}
+
+// Returns the share position for a known or detected rebasing token, or nil when balanceOf is not share based.
+func Detect(ctx context.Context, address, chain, tokenAddress, balance string, decimals, block int) (*Shares, error) {
+	if _, err := rpc.Endpoint(chain); err != nil {
+		return nil, err
+	}
+
+	tokenAddress = strings.ToLower(tokenAddress)
+	holder := rpc.EncodeAddress(address)
+
+	amount, ok := new(big.Int).SetString(balance, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid balance %q", balance)
+	}
+
+	model, ok := known[chain][tokenAddress]
+	if !ok {
+		// aTokens on any chain: balanceOf is the scaled balance times the reserve's liquidity index
+		if _, err := rpc.CallAddress(ctx, chain, tokenAddress, "UNDERLYING_ASSET_ADDRESS()", block); err != nil {
+			// only a token reverting the probe is not an aToken; a node failing to answer is an error
+			if rpc.Reverted(err) {
+				return nil, nil
+			}
+
+			return nil, err
+		}
+
+		model = AaveScaled
+	}
+
+	var shares *big.Int
+	var err error
+
+	switch model {
+	case LidoShares:
+		shares, err = rpc.CallUint(ctx, chain, tokenAddress, "sharesOf(address)", block, holder)
+	case AaveScaled, Ampleforth:
+		shares, err = rpc.CallUint(ctx, chain, tokenAddress, "scaledBalanceOf(address)", block, holder)
+	case OlympusIndex:
+		// gOHM has 18 decimals against sOHM's 9
+		shares, err = rpc.CallUint(ctx, chain, tokenAddress, "toG(uint256)", block, rpc.EncodeUint(amount))
+		if err == nil {
+			shares = new(big.Int).Div(shares, big.NewInt(1e9))
+		}
+	}
+	if err != nil {
+		if model == AaveScaled && rpc.Reverted(err) {
+			// debt tokens of older deployments may not expose scaled balances
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	position := &Shares{
+		Model:   model,
+		Shares:  rpc.ToDecimal(shares, decimals),
+		Balance: rpc.ToDecimal(amount, decimals),
+	}
+
+	rate, err := shareRate(ctx, chain, tokenAddress, model, block)
+	if err != nil {
+		return nil, err
+	}
+
+	position.ShareRate = rate
+
+	return position, nil
+}
+
+// Returns the balance per share at the block as reported by the token (or its pool), independent of the holder.
+func shareRate(ctx context.Context, chain, tokenAddress, model string, block int) (float64, error) {
+	switch model {
+	case LidoShares:
+		oneShare := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+
+		pooled, err := rpc.CallUint(ctx, chain, tokenAddress, "getPooledEthByShares(uint256)", block, rpc.EncodeUint(oneShare))
+		if err != nil {
+			return 0, err
+		}
+
+		return rpc.ToDecimal(pooled, 18), nil
+	case AaveScaled:
+		pool, err := rpc.CallAddress(ctx, chain, tokenAddress, "POOL()", block)
+		if err != nil {
+			return 0, err
+		}
+
+		underlying, err := rpc.CallAddress(ctx, chain, tokenAddress, "UNDERLYING_ASSET_ADDRESS()", block)
+		if err != nil {
+			return 0, err
+		}
+
+		// variable debt accrues on the borrow index, aTokens on the liquidity index
+		normalized := "getReserveNormalizedIncome(address)"
+		zero := rpc.EncodeAddress("0x0")
+		_, err = rpc.CallUint(ctx, chain, tokenAddress, "borrowAllowance(address,address)", block, zero, zero)
+		if err != nil && !rpc.Reverted(err) {
+			return 0, err
+		}
+		if err == nil {
+			normalized = "getReserveNormalizedVariableDebt(address)"
+		}
+
+		// indexes are rays (1e27)
+		index, err := rpc.CallUint(ctx, chain, pool, normalized, block, rpc.EncodeAddress(underlying))
+		if err != nil {
+			return 0, err
+		}
+
+		return rpc.ToDecimal(index, 27), nil
+	case Ampleforth:
+		supply, err := rpc.CallUint(ctx, chain, tokenAddress, "totalSupply()", block)
+		if err != nil {
+			return 0, err
+		}
+
+		scaledSupply, err := rpc.CallUint(ctx, chain, tokenAddress, "scaledTotalSupply()", block)
+		if err != nil {
+			return 0, err
+		}
+
+		if scaledSupply.Sign() == 0 {
+			return 0, nil
+		}
+
+		rate, _ := new(big.Float).Quo(new(big.Float).SetInt(supply), new(big.Float).SetInt(scaledSupply)).Float64()
+
+		return rate, nil
+	case OlympusIndex:
+		// sOHM per gOHM, 9 decimals
+		index, err := rpc.CallUint(ctx, chain, tokenAddress, "index()", block)
+		if err != nil {
+			return 0, err
+		}
+
+		return rpc.ToDecimal(index, 9), nil
+	}
+
+	return 0, fmt.Errorf("unknown share model %q", model)
+}
@@ -0,0 +1,103 @@
+package rebasing
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc/rpctest"
+)
+
+func word(n *big.Int) string {
+	return rpc.EncodeUint(n)
+}
+
+// Returns the amount scaled by 10^decimals.
+func scaled(amount int64, decimals int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(amount), new(big.Int).Exp(big.NewInt(10), big.NewInt(decimals), nil))
+}
+
+func TestDetect(t *testing.T) {
+	holder, token, pool, underlying := "0x"+strings.Repeat("1", 40), "0x"+strings.Repeat("2", 40), "0x"+strings.Repeat("3", 40), "0x"+strings.Repeat("4", 40)
+	lido := "0xae7ab96520de3a18e5e111b5eaab095312d7fe84"
+
+	aToken := func(node *rpctest.Node) {
+		node.Return(token, "UNDERLYING_ASSET_ADDRESS()", nil, rpc.EncodeAddress(underlying))
+		node.Return(token, "POOL()", nil, rpc.EncodeAddress(pool))
+		node.Return(token, "scaledBalanceOf(address)", nil, word(scaled(8, 18)))
+		// a liquidity index of 1.25 ray
+		node.Return(pool, "getReserveNormalizedIncome(address)", nil, word(scaled(125, 25)))
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		setup func(node *rpctest.Node)
+		// model, shares and share rate expected, or no position when model is empty
+		model  string
+		shares float64
+		rate   float64
+		err    bool
+	}{
+		{"plain token", token, func(node *rpctest.Node) {}, "", 0, 0, false},
+		{"aave aToken", token, aToken, AaveScaled, 8, 1.25, false},
+		{"aave variable debt", token, func(node *rpctest.Node) {
+			aToken(node)
+			node.Return(token, "borrowAllowance(address,address)", nil, word(big.NewInt(0)))
+			node.Return(pool, "getReserveNormalizedVariableDebt(address)", nil, word(scaled(15, 26)))
+		}, AaveScaled, 8, 1.5, false},
+		{"aave probe fails at the node", token, func(node *rpctest.Node) {
+			node.Fail(token, "UNDERLYING_ASSET_ADDRESS()")
+		}, "", 0, 0, true},
+		{"older debt token without scaled balances", token, func(node *rpctest.Node) {
+			node.Return(token, "UNDERLYING_ASSET_ADDRESS()", nil, rpc.EncodeAddress(underlying))
+		}, "", 0, 0, false},
+		{"scaled balance fails at the node", token, func(node *rpctest.Node) {
+			node.Return(token, "UNDERLYING_ASSET_ADDRESS()", nil, rpc.EncodeAddress(underlying))
+			node.Fail(token, "scaledBalanceOf(address)")
+		}, "", 0, 0, true},
+		{"debt check fails at the node", token, func(node *rpctest.Node) {
+			aToken(node)
+			node.Fail(token, "borrowAllowance(address,address)")
+		}, "", 0, 0, true},
+		{"lido stETH", lido, func(node *rpctest.Node) {
+			node.Return(lido, "sharesOf(address)", nil, word(scaled(8, 18)))
+			node.Return(lido, "getPooledEthByShares(uint256)", nil, word(scaled(125, 16)))
+		}, LidoShares, 8, 1.25, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := rpctest.New(t, "eth")
+			test.setup(node)
+
+			shares, err := Detect(context.Background(), holder, "eth", test.token, scaled(10, 18).String(), 18, 100)
+
+			if test.err {
+				if err == nil {
+					t.Fatalf("Detect() = %+v, want an error", shares)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.model == "" {
+				if shares != nil {
+					t.Fatalf("Detect() = %+v, want no position", shares)
+				}
+
+				return
+			}
+
+			if shares == nil || shares.Model != test.model || shares.Shares != test.shares || shares.ShareRate != test.rate || shares.Balance != 10 {
+				t.Errorf("Detect() = %+v, want %v with %v shares at %v", shares, test.model, test.shares, test.rate)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/files"
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/scheduler"
+)
+
+// Provider modes (PROVIDER_MODE)
+const (
+	Live   = "live"
+	Record = "record"
+	Replay = "replay"
+)
+
+// HTTP client for provider requests. Requests are passed through, recorded or replayed depending on the mode.
+var Client = client{&http.Client{Transport: transport{}}}
+
+type client struct {
+	http *http.Client
+}
+
+// Sends the request. Errors name the request's redacted URL, as they are reported on rows and to API clients.
+func (c client) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+
+	var failure *url.Error
+	if errors.As(err, &failure) {
+		failure.URL = Redact(failure.URL)
+	}
+
+	return resp, err
+}
+
+// Recorded request and response, one file per distinct request
+type Recording struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	RequestBody string      `json:"request_body,omitempty"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        string      `json:"body"`
+}
+
+type transport struct{}
+
+func (transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var payload []byte
+
+	if req.Body != nil {
+		var err error
+
+		payload, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		req.Body = io.NopCloser(bytes.NewReader(payload))
+	}
+
+	// headers such as a Cosmos block height select what is answered, so they are part of the key when set
+	keyed := payload
+	if identity := scheduler.Identity(req.Header); identity != "" {
+		keyed = append(append([]byte{}, payload...), identity...)
+	}
+
+	path := filepath.Join(initialisers.EVIDENCEDIR, Key(req.Method, Redact(req.URL.String()), keyed)+".json")
+
+	var resp *http.Response
+	var err error
+
+	switch initialisers.PROVIDERMODE {
+	case Replay:
+		resp, err = replay(req, path)
+	case Record:
+		resp, err = scheduler.Transport.RoundTrip(req)
+		if err == nil {
+			resp, err = record(req, resp, payload, path)
+		}
+	default:
+		resp, err = scheduler.Transport.RoundTrip(req)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if transcript, ok := req.Context().Value(transcriptKey{}).(*Transcript); ok {
+		return transcript.add(req, resp, payload)
+	}
+
+	return resp, nil
+}
+
+// Provider exchanges made for one run, kept in its evidence pack. Exchanges are redacted as recordings are and keep
+// no headers, so the pack holds no API keys.
+type Transcript struct {
+	mu        sync.Mutex
+	exchanges []Recording
+}
+
+type transcriptKey struct{}
+
+// Returns a context whose provider requests are added to the returned transcript.
+func WithTranscript(ctx context.Context) (context.Context, *Transcript) {
+	transcript := &Transcript{}
+
+	return context.WithValue(ctx, transcriptKey{}, transcript), transcript
+}
+
+// Returns the exchanges ordered by request, each distinct exchange once, so the pack of a run does not depend on the
+// order requests completed in.
+func (t *Transcript) Exchanges() []Recording {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	exchanges := append([]Recording{}, t.exchanges...)
+
+	sort.Slice(exchanges, func(i, j int) bool {
+		a, b := exchanges[i], exchanges[j]
+		if a.URL != b.URL {
+			return a.URL < b.URL
+		}
+		if a.RequestBody != b.RequestBody {
+			return a.RequestBody < b.RequestBody
+		}
+		if a.Status != b.Status {
+			return a.Status < b.Status
+		}
+
+		return a.Body < b.Body
+	})
+
+	var distinct []Recording
+	for _, exchange := range exchanges {
+		last := len(distinct) - 1
+		if last < 0 || exchange.URL != distinct[last].URL || exchange.RequestBody != distinct[last].RequestBody || exchange.Status != distinct[last].Status || exchange.Body != distinct[last].Body {
+			distinct = append(distinct, exchange)
+		}
+	}
+
+	return distinct
+}
+
+// Adds the exchange and hands back an equivalent unread response.
+func (t *Transcript) add(req *http.Request, resp *http.Response, payload []byte) (*http.Response, error) {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.exchanges = append(t.exchanges, Recording{
+		Method:      req.Method,
+		URL:         Redact(req.URL.String()),
+		RequestBody: string(payload),
+		Status:      resp.StatusCode,
+		Body:        string(body),
+	})
+
+	return resp, nil
+}
+
+// Returns the name recordings of the request are stored under, from its redacted URL. The Moralis API key is sent as
+// a header so it is never part of the key.
+func Key(method, url string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + url + "\n"))
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Returns the URL with the configured endpoint it starts with replaced by the variable naming it, e.g.
+// $ETH_RPC_URL, as node providers such as Alchemy and Infura put the API key in the endpoint's path. Recordings are
+// then free of keys and replay with any endpoint configured under the same variable. Other URLs lose any password.
+func Redact(raw string) string {
+	var name, base string
+
+	for variable, endpoint := range endpoints() {
+		endpoint = strings.TrimRight(endpoint, "/")
+		if endpoint == "" || !strings.HasPrefix(raw, endpoint) {
+			continue
+		}
+
+		// the endpoint must end at a path or query boundary of the URL
+		if rest := raw[len(endpoint):]; rest != "" && rest[0] != '/' && rest[0] != '?' {
+			continue
+		}
+
+		if len(endpoint) > len(base) || len(endpoint) == len(base) && variable < name {
+			name, base = variable, endpoint
+		}
+	}
+
+	if base != "" {
+		return "$" + name + raw[len(base):]
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "endpoint"
+	}
+
+	return parsed.Redacted()
+}
+
+// Returns the configured provider endpoints keyed by the variable they are configured with.
+func endpoints() map[string]string {
+	configured := map[string]string{
+		"BEACON_API_URL":        initialisers.BEACONURL,
+		"AVALANCHE_P_CHAIN_URL": initialisers.AVALANCHEPCHAINURL,
+		"CRONOS_POS_LCD_URL":    initialisers.CRONOSPOSURL,
+	}
+
+	for chain, endpoint := range initialisers.RPCURLS {
+		configured[strings.ToUpper(chain)+"_RPC_URL"] = endpoint
+	}
+
+	for chain, urls := range initialisers.CONSENSUSRPCURLS {
+		for i, endpoint := range urls {
+			configured[fmt.Sprintf("%v_CONSENSUS_RPC_URLS[%v]", strings.ToUpper(chain), i)] = endpoint
+		}
+	}
+
+	return configured
+}
+
+// Saves the response and hands back an equivalent unread response.
+func record(req *http.Request, resp *http.Response, payload []byte, path string) (*http.Response, error) {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	recording := Recording{
+		Method:      req.Method,
+		URL:         Redact(req.URL.String()),
+		RequestBody: string(payload),
+		Status:      resp.StatusCode,
+		Header:      resp.Header,
+		Body:        string(body),
+	}
+
+	data, err := json.MarshalIndent(recording, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating evidence directory: %v", err)
+	}
+
+	// written whole or not at all, as replay trusts any recording it finds
+	err = files.Write(path, data, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error recording provider response: %v", err)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	return resp, nil
+}
+
+// Serves the recorded response for the request without calling the provider.
+func replay(req *http.Request, path string) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no recording of %v %v in %v", req.Method, Redact(req.URL.String()), initialisers.EVIDENCEDIR)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var recording Recording
+
+	err = json.Unmarshal(data, &recording)
+	if err != nil {
+		return nil, fmt.Errorf("error reading recording %v: %v", path, err)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recording.Status, http.StatusText(recording.Status)),
+		StatusCode:    recording.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recording.Header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(recording.Body))),
+		ContentLength: int64(len(recording.Body)),
+		Request:       req,
+	}, nil
+}
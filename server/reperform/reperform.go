@@ -0,0 +1,128 @@
+package reperform
+
+import (
+	"strings"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/runs"
+)
+
+// Differences between a stored run and its re-performance at the same block
+type Diff struct {
+	OriginalRun   string   `json:"original_run"`
+	Reperformed   string   `json:"reperformed_run"`
+	Chain         string   `json:"chain"`
+	BlockNumber   int      `json:"block_number"`
+	BlockHash     string   `json:"block_hash"`
+	Matches       bool     `json:"matches"`
+	Balances      []Change `json:"balances"`
+	Prices        []Change `json:"prices"`
+	TokensAdded   []Token  `json:"tokens_added"`
+	TokensRemoved []Token  `json:"tokens_removed"`
+}
+
+// Value that changed between the runs
+type Change struct {
+	Asset        string  `json:"asset_symbol"`
+	AssetAddress string  `json:"contract_address"`
+	Protocol     string  `json:"protocol,omitempty"`
+	Original     float64 `json:"original"`
+	Current      float64 `json:"current"`
+	Difference   float64 `json:"difference"`
+	// Price source, when the change is a price
+	OriginalSource string `json:"original_source,omitempty"`
+	CurrentSource  string `json:"current_source,omitempty"`
+}
+
+// Row present in only one of the runs
+type Token struct {
+	Asset        string  `json:"asset_symbol"`
+	AssetName    string  `json:"asset_name"`
+	AssetAddress string  `json:"contract_address"`
+	Protocol     string  `json:"protocol,omitempty"`
+	Balance      float64 `json:"balance"`
+}
+
+// Compares the rows of the original run with its re-performance.
+func Compare(original, current *runs.Run) Diff {
+	diff := Diff{
+		OriginalRun:   original.ID,
+		Reperformed:   current.ID,
+		Chain:         original.Request.Chain,
+		BlockNumber:   original.Block.Block,
+		BlockHash:     original.Block.Hash,
+		Balances:      []Change{},
+		Prices:        []Change{},
+		TokensAdded:   []Token{},
+		TokensRemoved: []Token{},
+	}
+
+	if len(original.Rows) > 0 {
+		diff.Chain = original.Rows[0].Chain
+	}
+
+	currentRows := map[string]models.ClientResponse{}
+	for _, row := range current.Rows {
+		currentRows[key(row)] = row
+	}
+
+	seen := map[string]bool{}
+
+	for _, before := range original.Rows {
+		seen[key(before)] = true
+
+		after, ok := currentRows[key(before)]
+		if !ok {
+			diff.TokensRemoved = append(diff.TokensRemoved, token(before))
+			continue
+		}
+
+		if before.Balance != after.Balance {
+			diff.Balances = append(diff.Balances, change(before, before.Balance, after.Balance))
+		}
+
+		if before.UsdPrice != after.UsdPrice || before.PriceSource != after.PriceSource {
+			priceChange := change(before, before.UsdPrice, after.UsdPrice)
+			priceChange.OriginalSource = before.PriceSource
+			priceChange.CurrentSource = after.PriceSource
+
+			diff.Prices = append(diff.Prices, priceChange)
+		}
+	}
+
+	for _, row := range current.Rows {
+		if !seen[key(row)] {
+			diff.TokensAdded = append(diff.TokensAdded, token(row))
+		}
+	}
+
+	diff.Matches = len(diff.Balances) == 0 && len(diff.Prices) == 0 && len(diff.TokensAdded) == 0 && len(diff.TokensRemoved) == 0
+
+	return diff
+}
+
+// Identifies a row across runs. A token can appear more than once, e.g. as a balance and a staking or debt row.
+func key(row models.ClientResponse) string {
+	return strings.ToLower(row.AssetAddress) + "|" + row.Protocol + "|" + row.Validator
+}
+
+func change(row models.ClientResponse, original, current float64) Change {
+	return Change{
+		Asset:        row.Asset,
+		AssetAddress: row.AssetAddress,
+		Protocol:     row.Protocol,
+		Original:     original,
+		Current:      current,
+		Difference:   current - original,
+	}
+}
+
+func token(row models.ClientResponse) Token {
+	return Token{
+		Asset:        row.Asset,
+		AssetName:    row.AssetName,
+		AssetAddress: row.AssetAddress,
+		Protocol:     row.Protocol,
+		Balance:      row.Balance,
+	}
+}
@@ -0,0 +1,71 @@
+package reperform
+
+import (
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/blocks"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/runs"
+)
+
+func TestCompare(t *testing.T) {
+	native := models.ClientResponse{Chain: "eth", Asset: "ETH", AssetAddress: "N/A", Balance: 2}
+	usdc := models.ClientResponse{Chain: "eth", Asset: "USDC", AssetAddress: "0xA0b8", Balance: 100, UsdPrice: 1, PriceSource: "moralis"}
+	staked := models.ClientResponse{Chain: "eth", Asset: "ETH", AssetAddress: "N/A", Balance: 32, Protocol: "native staking on eth", Validator: "1"}
+
+	with := func(row models.ClientResponse, change func(r *models.ClientResponse)) models.ClientResponse {
+		change(&row)
+		return row
+	}
+
+	tests := []struct {
+		name              string
+		original, current []models.ClientResponse
+		balances, prices  int
+		added, removed    int
+		matches           bool
+	}{
+		{"same rows", []models.ClientResponse{native, usdc}, []models.ClientResponse{usdc, native}, 0, 0, 0, 0, true},
+		{"address case ignored", []models.ClientResponse{usdc}, []models.ClientResponse{with(usdc, func(r *models.ClientResponse) { r.AssetAddress = "0xa0b8" })}, 0, 0, 0, 0, true},
+		{"balance changed", []models.ClientResponse{native}, []models.ClientResponse{with(native, func(r *models.ClientResponse) { r.Balance = 3 })}, 1, 0, 0, 0, false},
+		{"price changed", []models.ClientResponse{usdc}, []models.ClientResponse{with(usdc, func(r *models.ClientResponse) { r.UsdPrice = 0.99 })}, 0, 1, 0, 0, false},
+		{"price source changed", []models.ClientResponse{usdc}, []models.ClientResponse{with(usdc, func(r *models.ClientResponse) { r.PriceSource = "manual override" })}, 0, 1, 0, 0, false},
+		{"token added", []models.ClientResponse{native}, []models.ClientResponse{native, usdc}, 0, 0, 1, 0, false},
+		{"token removed", []models.ClientResponse{native, usdc}, []models.ClientResponse{native}, 0, 0, 0, 1, false},
+		{"stake kept apart from the balance", []models.ClientResponse{native, staked}, []models.ClientResponse{native, with(staked, func(r *models.ClientResponse) { r.Balance = 31 })}, 1, 0, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := &runs.Run{ID: "a", Request: models.Request{Chain: "ethereum"}, Block: blocks.Block{Block: 100, Hash: "0xabc"}, Rows: test.original}
+			current := &runs.Run{ID: "b", Rows: test.current}
+
+			diff := Compare(original, current)
+
+			if len(diff.Balances) != test.balances || len(diff.Prices) != test.prices || len(diff.TokensAdded) != test.added || len(diff.TokensRemoved) != test.removed || diff.Matches != test.matches {
+				t.Errorf("Compare() = %+v", diff)
+			}
+
+			if diff.OriginalRun != "a" || diff.Reperformed != "b" || diff.Chain != "eth" || diff.BlockNumber != 100 || diff.BlockHash != "0xabc" {
+				t.Errorf("Compare() identifies %+v", diff)
+			}
+		})
+	}
+}
+
+func TestCompareDifference(t *testing.T) {
+	original := &runs.Run{Rows: []models.ClientResponse{{AssetAddress: "0x1", Balance: 10, UsdPrice: 2, PriceSource: "moralis"}}}
+	current := &runs.Run{Rows: []models.ClientResponse{{AssetAddress: "0x1", Balance: 7.5, UsdPrice: 2, PriceSource: "manual override"}}}
+
+	diff := Compare(original, current)
+
+	balance := diff.Balances[0]
+	if balance.Original != 10 || balance.Current != 7.5 || balance.Difference != -2.5 {
+		t.Errorf("balance change = %+v", balance)
+	}
+
+	price := diff.Prices[0]
+	if price.Difference != 0 || price.OriginalSource != "moralis" || price.CurrentSource != "manual override" {
+		t.Errorf("price change = %+v", price)
+	}
+}
@@ -0,0 +1,113 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"strings"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/runs"
+)
+
+// Report column headers, in the order of the CSV export
+var Headers = []string{
+	"Address", "Chain", "Token Name", "Token Symbol", "Token Address", "Balance", "Block number", "Token checker",
+	"Possible spam", "Protocol", "Liability", "Usd rate", "Usd value", "Price source", "Consensus", "Verify command",
+	"Error codes", "Errors",
+}
+
+// Returns the run's rows in report column order.
+func Rows(run *runs.Run) [][]string {
+	var rows [][]string
+
+	for _, row := range run.Rows {
+		rows = append(rows, []string{
+			row.Address,
+			row.Chain,
+			row.AssetName,
+			row.Asset,
+			row.AssetAddress,
+			strconv.FormatFloat(row.Balance, 'f', -1, 64),
+			row.Block(),
+			row.CheckerUrl,
+			strconv.FormatBool(row.PossibleSpam),
+			row.Protocol,
+			strconv.FormatBool(row.Liability),
+			formatPrice(row.UsdPrice, row.PriceSource),
+			formatPrice(row.UsdValue, row.PriceSource),
+			row.PriceSource,
+			row.Consensus,
+			row.VerifyCommand,
+			errorCodes(row.Errors),
+			errorMessages(row.Errors),
+		})
+	}
+
+	return rows
+}
+
+// Returns a report row for a wallet that could not be proved, with the error in the error columns.
+func FailureRow(failure *faults.Error) []string {
+	row := make([]string, len(Headers))
+
+	row[0] = failure.Wallet
+	row[1] = failure.Chain
+	row[len(row)-2] = errorCodes([]*faults.Error{failure})
+	row[len(row)-1] = errorMessages([]*faults.Error{failure})
+
+	return row
+}
+
+// Returns the codes of the errors, each with the step it occurred at.
+func errorCodes(failures []*faults.Error) string {
+	var codes []string
+
+	for _, failure := range failures {
+		if failure.Step != "" {
+			codes = append(codes, failure.Code+" at "+failure.Step)
+		} else {
+			codes = append(codes, failure.Code)
+		}
+	}
+
+	return strings.Join(codes, "; ")
+}
+
+func errorMessages(failures []*faults.Error) string {
+	var messages []string
+
+	for _, failure := range failures {
+		messages = append(messages, failure.Message)
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Renders the run as a CSV report.
+func CSV(run *runs.Run) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	writer := csv.NewWriter(&buffer)
+
+	err := writer.Write(Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.WriteAll(Rows(run))
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Leaves unpriced rows blank rather than reporting a zero value.
+func formatPrice(value float64, source string) string {
+	if source == "" {
+		return ""
+	}
+
+	return strconv.FormatFloat(value, 'f', 6, 64)
+}
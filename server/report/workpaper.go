@@ -0,0 +1,327 @@
+package report
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/blocks"
+	"github.com/harrisandtrotter/proof-of-balance/server/consensus"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/runs"
+)
+
+// Summary line: one asset on one chain across the wallets
+type summaryLine struct {
+	chain     string
+	asset     string
+	address   string
+	liability bool
+	spam      bool
+	wallets   map[string]bool
+	quantity  float64
+	value     float64
+	priced    bool
+}
+
+// Returned when the runs given to Workpaper are not all at the same cut-off, as their totals could not be added up
+var ErrMixedCutOffs = errors.New("runs have different cut-offs")
+
+// Builds the XLSX working paper for the runs: a summary sheet, a detail sheet per wallet and a parameters sheet.
+// The runs must share one cut-off.
+func Workpaper(stored []*runs.Run) ([]byte, error) {
+	if len(stored) == 0 {
+		return nil, errors.New("no runs to report")
+	}
+
+	first := stored[0]
+	for _, run := range stored[1:] {
+		if run.Request.Date != first.Request.Date || run.Request.Timestamp != first.Request.Timestamp {
+			return nil, fmt.Errorf("%w: run %v is at %v %v and run %v at %v %v", ErrMixedCutOffs, first.ID, first.Request.Date, first.Request.Timestamp, run.ID, run.Request.Date, run.Request.Timestamp)
+		}
+	}
+
+	used := map[string]bool{}
+	sheets := []sheet{summarySheet(stored, used)}
+
+	for _, run := range stored {
+		sheets = append(sheets, detailSheet(run, used))
+	}
+
+	sheets = append(sheets, parametersSheet(stored, used))
+
+	// the latest run dates the workbook so the same runs always give the same file
+	modified := stored[0].CreatedAt
+	for _, run := range stored {
+		if run.CreatedAt.After(modified) {
+			modified = run.CreatedAt
+		}
+	}
+
+	return writeXLSX(sheets, modified)
+}
+
+// Totals per chain and asset. Spam and liabilities are listed but kept out of the asset totals.
+func summarySheet(stored []*runs.Run, used map[string]bool) sheet {
+	lines := map[string]*summaryLine{}
+	var keys []string
+
+	for _, run := range stored {
+		for _, row := range run.Rows {
+			key := strings.Join([]string{row.Chain, row.Asset, strings.ToLower(row.AssetAddress), strconv.FormatBool(row.Liability), strconv.FormatBool(row.PossibleSpam)}, "|")
+
+			line, ok := lines[key]
+			if !ok {
+				line = &summaryLine{
+					chain:     row.Chain,
+					asset:     row.Asset,
+					address:   row.AssetAddress,
+					liability: row.Liability,
+					spam:      row.PossibleSpam,
+					wallets:   map[string]bool{},
+				}
+				lines[key] = line
+				keys = append(keys, key)
+			}
+
+			line.wallets[strings.ToLower(row.Address)] = true
+			line.quantity += row.Balance
+			line.value += row.UsdValue
+			line.priced = line.priced || row.PriceSource != ""
+		}
+	}
+
+	sort.Strings(keys)
+
+	rows := [][]cell{headerRow("Chain", "Asset", "Token address", "Type", "Possible spam", "Wallets", "Quantity", "USD value")}
+
+	type totals struct{ assets, liabilities float64 }
+	chainTotals := map[string]*totals{}
+	var chains []string
+
+	for _, key := range keys {
+		line := lines[key]
+
+		kind := "Asset"
+		if line.liability {
+			kind = "Liability"
+		}
+
+		value := cell{}
+		if line.priced {
+			value = number(line.value, styleUsd)
+		}
+
+		rows = append(rows, []cell{
+			text(line.chain), text(line.asset), text(line.address), text(kind), text(yesNo(line.spam)),
+			{Value: len(line.wallets)}, number(line.quantity, styleQuantity), value,
+		})
+
+		if _, ok := chainTotals[line.chain]; !ok {
+			chainTotals[line.chain] = &totals{}
+			chains = append(chains, line.chain)
+		}
+
+		switch {
+		case line.spam:
+		case line.liability:
+			chainTotals[line.chain].liabilities += line.value
+		default:
+			chainTotals[line.chain].assets += line.value
+		}
+	}
+
+	table := len(rows)
+
+	rows = append(rows, []cell{}, headerRow("Chain", "Assets (USD, excluding spam)", "Liabilities (USD)", "Net (USD)"))
+
+	first := len(rows) + 1
+	for _, chain := range chains {
+		t := chainTotals[chain]
+		row := len(rows) + 1
+
+		rows = append(rows, []cell{
+			text(chain),
+			number(t.assets, styleUsd),
+			number(t.liabilities, styleUsd),
+			{Value: t.assets - t.liabilities, Formula: fmt.Sprintf("B%d-C%d", row, row), Style: styleUsd},
+		})
+	}
+
+	var grand totals
+	for _, t := range chainTotals {
+		grand.assets += t.assets
+		grand.liabilities += t.liabilities
+	}
+
+	last := len(rows)
+	rows = append(rows, []cell{
+		{Value: "Total", Style: styleTotal},
+		{Value: grand.assets, Formula: fmt.Sprintf("SUM(B%d:B%d)", first, last), Style: styleTotal},
+		{Value: grand.liabilities, Formula: fmt.Sprintf("SUM(C%d:C%d)", first, last), Style: styleTotal},
+		{Value: grand.assets - grand.liabilities, Formula: fmt.Sprintf("SUM(D%d:D%d)", first, last), Style: styleTotal},
+	})
+
+	return sheet{
+		Name:   sheetName("Summary", used),
+		Widths: []float64{12, 28, 46, 11, 14, 10, 22, 18},
+		Header: true,
+		Table:  table,
+		Rows:   rows,
+	}
+}
+
+// Every row of one run, with a link to the explorer's check tool and the command to check it against a node.
+func detailSheet(run *runs.Run, used map[string]bool) sheet {
+	rows := [][]cell{headerRow(
+		"Token name", "Symbol", "Token address", "Balance", "USD rate", "USD value", "Price source", "Possible spam",
+		"Spam score", "Protocol", "Liability", "Consensus", "Block number", "Explorer check", "Node check",
+	)}
+
+	address, chain := run.Request.Address, run.Request.Chain
+
+	for _, row := range run.Rows {
+		address, chain = row.Address, row.Chain
+
+		rate, value := cell{}, cell{}
+		if row.PriceSource != "" {
+			rate = number(row.UsdPrice, styleUsd)
+			value = number(row.UsdValue, styleUsd)
+		}
+
+		check := cell{}
+		if row.CheckerUrl != "" {
+			check = cell{Value: "Verify", Link: row.CheckerUrl, Style: styleLink}
+		}
+
+		rows = append(rows, []cell{
+			text(row.AssetName), text(row.Asset), text(row.AssetAddress), number(row.Balance, styleQuantity), rate, value,
+			text(row.PriceSource), text(yesNo(row.PossibleSpam)), {Value: row.SpamScore}, text(row.Protocol),
+			text(yesNo(row.Liability)), text(row.Consensus), blockCell(row), check, text(row.VerifyCommand),
+		})
+	}
+
+	return sheet{
+		Name:   sheetName(shortAddress(address)+" "+chain, used),
+		Widths: []float64{28, 12, 46, 22, 14, 16, 22, 14, 11, 28, 10, 26, 14, 14, 60},
+		Header: true,
+		Table:  len(rows),
+		Rows:   rows,
+	}
+}
+
+// Cut-off, blocks and hashes, providers and prices behind the figures.
+func parametersSheet(stored []*runs.Run, used map[string]bool) sheet {
+	// Workpaper only combines runs at one cut-off
+	first := stored[0].Request
+
+	rows := [][]cell{
+		{{Value: "Proof of balance parameters", Style: styleTitle}},
+		{},
+		{text("Cut-off date"), text(first.Date)},
+		{text("Cut-off time (UTC)"), text(first.Timestamp)},
+		{},
+		headerRow("Run ID", "Address", "Chain", "Block number", "Block hash", "Block timestamp", "Block provider", "Balance providers", "Options", "Created"),
+	}
+
+	for _, run := range stored {
+		chain := run.Request.Chain
+		if blockchain, err := models.DetermineChain(chain); err == nil {
+			chain = blockchain
+		}
+
+		rows = append(rows, []cell{
+			text(run.ID), text(run.Request.Address), text(chain), {Value: run.Block.Block}, text(run.Block.Hash),
+			text(run.Block.BlockTimestamp), text(blocks.Provider), text(strings.Join(providers(run), ", ")),
+			text(options(run.Request)), text(run.CreatedAt.UTC().Format(time.RFC3339)),
+		})
+	}
+
+	rows = append(rows, []cell{}, headerRow("Chain", "Asset", "Token address", "Block number", "USD price", "Price source"))
+
+	for _, run := range stored {
+		for _, row := range run.Rows {
+			if row.PriceSource == "" {
+				continue
+			}
+
+			rows = append(rows, []cell{
+				text(row.Chain), text(row.Asset), text(row.AssetAddress), blockCell(row), number(row.UsdPrice, styleUsd), text(row.PriceSource),
+			})
+		}
+	}
+
+	return sheet{
+		Name:   sheetName("Parameters", used),
+		Widths: []float64{28, 46, 12, 14, 68, 24, 16, 40, 40, 22},
+		Rows:   rows,
+	}
+}
+
+// Returns the providers the run's balances were read from.
+func providers(run *runs.Run) []string {
+	names := []string{consensus.Moralis}
+	seen := map[string]bool{consensus.Moralis: true}
+
+	for _, row := range run.Rows {
+		for _, provider := range row.ProviderBalances {
+			if !seen[provider.Provider] {
+				seen[provider.Provider] = true
+				names = append(names, provider.Provider)
+			}
+		}
+	}
+
+	return names
+}
+
+// Returns the enrichment options the request enabled.
+func options(request models.Request) string {
+	var enabled []string
+
+	for name, value := range map[string]string{
+		"spam checks": request.SpamChecks,
+		"decompose":   request.Decompose,
+		"liabilities": request.Liabilities,
+		"staking":     request.Staking,
+		"shares":      request.Shares,
+		"consensus":   request.Consensus,
+		"prices":      request.Prices,
+	} {
+		if value != "" {
+			enabled = append(enabled, name)
+		}
+	}
+
+	sort.Strings(enabled)
+
+	return strings.Join(enabled, ", ")
+}
+
+func shortAddress(address string) string {
+	if len(address) <= 12 {
+		return address
+	}
+
+	return address[:6] + ".." + address[len(address)-4:]
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "Yes"
+	}
+
+	return "No"
+}
+
+// Returns the row's block as a number, or as text for rows read at the latest height.
+func blockCell(row models.ClientResponse) cell {
+	if row.ReadAt != "" {
+		return text(row.ReadAt)
+	}
+
+	return cell{Value: row.BlockNumber}
+}
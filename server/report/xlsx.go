@@ -0,0 +1,296 @@
+package report
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Media type of the workbooks written
+const XLSXContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// Cell styles, indexes into cellXfs in styles.xml
+const (
+	styleDefault = iota
+	styleHeader
+	styleQuantity
+	styleUsd
+	styleLink
+	styleTotal
+	styleTitle
+)
+
+const stylesXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="#,##0.00000000"/></numFmts>
+<fonts count="4">
+<font><sz val="11"/><name val="Calibri"/></font>
+<font><b/><sz val="11"/><name val="Calibri"/></font>
+<font><u/><sz val="11"/><color rgb="FF0563C1"/><name val="Calibri"/></font>
+<font><b/><sz val="14"/><name val="Calibri"/></font>
+</fonts>
+<fills count="3">
+<fill><patternFill patternType="none"/></fill>
+<fill><patternFill patternType="gray125"/></fill>
+<fill><patternFill patternType="solid"><fgColor rgb="FFD9E1F2"/><bgColor indexed="64"/></patternFill></fill>
+</fills>
+<borders count="2">
+<border><left/><right/><top/><bottom/><diagonal/></border>
+<border><left/><right/><top style="thin"/><bottom style="double"/><diagonal/></border>
+</borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="7">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="0" fontId="1" fillId="2" borderId="0" xfId="0" applyFont="1" applyFill="1"/>
+<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="4" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="0" fontId="2" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+<xf numFmtId="4" fontId="1" fillId="0" borderId="1" xfId="0" applyNumberFormat="1" applyFont="1" applyBorder="1"/>
+<xf numFmtId="0" fontId="3" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+</cellXfs>
+<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>
+</styleSheet>`
+
+// Worksheet cell. Value is a string or a number; Formula, when set, is written with Value as its cached result.
+type cell struct {
+	Value   interface{}
+	Formula string
+	Style   int
+	Link    string
+}
+
+// Worksheet. Header freezes the first row; Table is the number of rows, from the first, covered by an auto filter.
+type sheet struct {
+	Name   string
+	Widths []float64
+	Header bool
+	Table  int
+	Rows   [][]cell
+}
+
+func text(value string) cell {
+	return cell{Value: value}
+}
+
+func number(value float64, style int) cell {
+	return cell{Value: value, Style: style}
+}
+
+func headerRow(titles ...string) []cell {
+	row := make([]cell, len(titles))
+	for i, title := range titles {
+		row[i] = cell{Value: title, Style: styleHeader}
+	}
+
+	return row
+}
+
+// Returns the spreadsheet column name for a zero based index (A, B, ... Z, AA, ...).
+func columnName(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+
+	return name
+}
+
+// Returns a sheet name Excel accepts: at most 31 characters, none of []:*?/\ and unique within the workbook.
+func sheetName(name string, used map[string]bool) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+
+		return r
+	}, name)
+
+	if len(name) > 31 {
+		name = name[:31]
+	}
+
+	candidate := name
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		suffix := fmt.Sprintf(" (%v)", i)
+		if len(name)+len(suffix) > 31 {
+			candidate = name[:31-len(suffix)] + suffix
+		} else {
+			candidate = name + suffix
+		}
+	}
+
+	used[strings.ToLower(candidate)] = true
+
+	return candidate
+}
+
+// Writes the sheets as an XLSX workbook. Files are stamped with the modified time so output is reproducible.
+func writeXLSX(sheets []sheet, modified time.Time) ([]byte, error) {
+	// package parts first, with the content types part leading so readers can identify the package early
+	names := []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"}
+	files := map[string]string{}
+
+	add := func(name, content string) {
+		files[name] = content
+		names = append(names, name)
+	}
+
+	var contentTypes, workbook, workbookRels strings.Builder
+
+	contentTypes.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+`)
+
+	workbook.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+
+	workbookRels.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+
+	for i, s := range sheets {
+		id := i + 1
+
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`+"\n", id)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escape(s.Name), id, id)
+		fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, id, id)
+
+		worksheet, rels := worksheetXML(s)
+
+		add(fmt.Sprintf("xl/worksheets/sheet%d.xml", id), worksheet)
+		if rels != "" {
+			add(fmt.Sprintf("xl/worksheets/_rels/sheet%d.xml.rels", id), rels)
+		}
+	}
+
+	contentTypes.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`, len(sheets)+1)
+
+	files["[Content_Types].xml"] = contentTypes.String()
+	files["_rels/.rels"] = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	files["xl/workbook.xml"] = workbook.String()
+	files["xl/_rels/workbook.xml.rels"] = workbookRels.String()
+	files["xl/styles.xml"] = stylesXML
+
+	var buffer bytes.Buffer
+
+	archive := zip.NewWriter(&buffer)
+
+	for _, name := range names {
+		writer, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = writer.Write([]byte(files[name]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Returns the worksheet XML and, when the sheet has hyperlinks, its relationships XML.
+func worksheetXML(s sheet) (string, string) {
+	var data, links, rels strings.Builder
+
+	linkCount := 0
+
+	for r, row := range s.Rows {
+		fmt.Fprintf(&data, `<row r="%d">`, r+1)
+
+		for c, value := range row {
+			ref := columnName(c) + strconv.Itoa(r+1)
+
+			if value.Link != "" {
+				linkCount++
+				fmt.Fprintf(&links, `<hyperlink ref="%s" r:id="rId%d"/>`, ref, linkCount)
+				fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink" Target="%s" TargetMode="External"/>`, linkCount, escape(value.Link))
+			}
+
+			style := ""
+			if value.Style != styleDefault {
+				style = fmt.Sprintf(` s="%d"`, value.Style)
+			}
+
+			formula := ""
+			if value.Formula != "" {
+				formula = "<f>" + escape(value.Formula) + "</f>"
+			}
+
+			switch v := value.Value.(type) {
+			case nil:
+				if formula != "" || style != "" {
+					fmt.Fprintf(&data, `<c r="%s"%s>%s</c>`, ref, style, formula)
+				}
+			case float64:
+				fmt.Fprintf(&data, `<c r="%s"%s>%s<v>%s</v></c>`, ref, style, formula, strconv.FormatFloat(v, 'f', -1, 64))
+			case int:
+				fmt.Fprintf(&data, `<c r="%s"%s>%s<v>%d</v></c>`, ref, style, formula, v)
+			default:
+				fmt.Fprintf(&data, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, escape(fmt.Sprint(v)))
+			}
+		}
+
+		data.WriteString(`</row>`)
+	}
+
+	var worksheet strings.Builder
+
+	worksheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">`)
+
+	if s.Header {
+		worksheet.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	}
+
+	if len(s.Widths) > 0 {
+		worksheet.WriteString(`<cols>`)
+		for i, width := range s.Widths {
+			fmt.Fprintf(&worksheet, `<col min="%d" max="%d" width="%v" customWidth="1"/>`, i+1, i+1, width)
+		}
+		worksheet.WriteString(`</cols>`)
+	}
+
+	worksheet.WriteString(`<sheetData>` + data.String() + `</sheetData>`)
+
+	if s.Table > 1 {
+		fmt.Fprintf(&worksheet, `<autoFilter ref="A1:%s%d"/>`, columnName(len(s.Rows[0])-1), s.Table)
+	}
+
+	if linkCount > 0 {
+		worksheet.WriteString(`<hyperlinks>` + links.String() + `</hyperlinks>`)
+	}
+
+	worksheet.WriteString(`</worksheet>`)
+
+	if linkCount == 0 {
+		return worksheet.String(), ""
+	}
+
+	return worksheet.String(), `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + rels.String() + `</Relationships>`
+}
+
+func escape(value string) string {
+	var buffer bytes.Buffer
+
+	xml.EscapeText(&buffer, []byte(value))
+
+	return buffer.String()
+}
@@ -0,0 +1,200 @@
+package report
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/blocks"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/runs"
+)
+
+// Returns the parts of a workbook, failing the test if any part is not well-formed XML.
+func parts(t *testing.T, workbook []byte) map[string]string {
+	t.Helper()
+
+	archive, err := zip.NewReader(bytes.NewReader(workbook), int64(len(workbook)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, _ := io.ReadAll(reader)
+		reader.Close()
+
+		decoder := xml.NewDecoder(bytes.NewReader(content))
+		for {
+			_, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%v is not well-formed: %v", file.Name, err)
+			}
+		}
+
+		files[file.Name] = string(content)
+	}
+
+	return files
+}
+
+func TestColumnName(t *testing.T) {
+	tests := []struct {
+		index int
+		want  string
+	}{
+		{0, "A"}, {25, "Z"}, {26, "AA"}, {51, "AZ"}, {52, "BA"}, {701, "ZZ"}, {702, "AAA"},
+	}
+
+	for _, test := range tests {
+		if got := columnName(test.index); got != test.want {
+			t.Errorf("columnName(%v) = %v, want %v", test.index, got, test.want)
+		}
+	}
+}
+
+func TestSheetName(t *testing.T) {
+	used := map[string]bool{}
+
+	tests := []struct {
+		name, want string
+	}{
+		{"Summary", "Summary"},
+		{"summary", "summary (2)"},
+		{"0x57..c0DD eth/polygon", "0x57..c0DD eth_polygon"},
+		{"a[b]c:d*e?f\\g", "a_b_c_d_e_f_g"},
+		{strings.Repeat("x", 40), strings.Repeat("x", 31)},
+		{strings.Repeat("x", 40), strings.Repeat("x", 27) + " (2)"},
+	}
+
+	for _, test := range tests {
+		if got := sheetName(test.name, used); got != test.want {
+			t.Errorf("sheetName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWorksheetXML(t *testing.T) {
+	tests := []struct {
+		name string
+		cell cell
+		want string
+	}{
+		{"text", text("ETH"), `<c r="A1" t="inlineStr"><is><t xml:space="preserve">ETH</t></is></c>`},
+		{"escaped text", text(`<b>&"`), `<t xml:space="preserve">&lt;b&gt;&amp;&#34;</t>`},
+		{"number", number(1.5, styleUsd), `<c r="A1" s="3"><v>1.5</v></c>`},
+		{"integer", cell{Value: 17}, `<c r="A1"><v>17</v></c>`},
+		{"formula", cell{Value: 3.0, Formula: "SUM(B2:B3)", Style: styleTotal}, `<c r="A1" s="5"><f>SUM(B2:B3)</f><v>3</v></c>`},
+		{"empty", cell{}, `<row r="1"></row>`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			worksheet, rels := worksheetXML(sheet{Rows: [][]cell{{test.cell}}})
+
+			if !strings.Contains(worksheet, test.want) {
+				t.Errorf("worksheet %v does not contain %v", worksheet, test.want)
+			}
+
+			if rels != "" {
+				t.Errorf("relationships written for a sheet without links: %v", rels)
+			}
+		})
+	}
+
+	link := "https://etherscan.io/tokencheck-tool?a=0x1&b=100"
+	worksheet, rels := worksheetXML(sheet{Header: true, Table: 2, Rows: [][]cell{headerRow("Asset", "Check"), {text("ETH"), {Value: "Verify", Link: link, Style: styleLink}}}})
+
+	for _, want := range []string{`<hyperlink ref="B2" r:id="rId1"/>`, `<autoFilter ref="A1:B2"/>`, `state="frozen"`} {
+		if !strings.Contains(worksheet, want) {
+			t.Errorf("worksheet does not contain %v", want)
+		}
+	}
+
+	if !strings.Contains(rels, `Target="https://etherscan.io/tokencheck-tool?a=0x1&amp;b=100"`) {
+		t.Errorf("relationships %v do not hold the escaped link", rels)
+	}
+}
+
+func TestWorkpaper(t *testing.T) {
+	if _, err := Workpaper(nil); err == nil {
+		t.Error("Workpaper() without runs succeeded")
+	}
+
+	run := &runs.Run{
+		ID:        "20240331T235959Z-0badf00d",
+		CreatedAt: time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC),
+		Request:   models.Request{Address: "0x57", Chain: "avalanche", Date: "31/03/2024", Timestamp: "23:59:59"},
+		Block:     blocks.Block{Block: 100, Hash: "0xabc"},
+		Rows: []models.ClientResponse{
+			{Address: "0x57", Chain: "avalanche", BlockNumber: 100, Asset: "AVAX", AssetName: "Avalanche", AssetAddress: "N/A", Balance: 2, UsdPrice: 40, UsdValue: 80, PriceSource: "moralis", CheckerUrl: "https://snowtrace.io/balancecheck-tool?a=0x57&b=100"},
+			{Address: "0x57", Chain: "avalanche", Asset: "AVAX", AssetName: "Staked Avalanche", AssetAddress: "P-chain", Balance: 25, ReadAt: "latest"},
+		},
+	}
+
+	first, err := Workpaper([]*runs.Run{run})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, _ := Workpaper([]*runs.Run{run})
+	if !bytes.Equal(first, second) {
+		t.Error("workpapers of the same runs differ")
+	}
+
+	files := parts(t, first)
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml", "xl/worksheets/sheet2.xml", "xl/worksheets/sheet3.xml", "xl/worksheets/_rels/sheet2.xml.rels"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("workbook has no %v", name)
+		}
+	}
+
+	if strings.Count(files["xl/workbook.xml"], "<sheet ") != 3 {
+		t.Errorf("workbook = %v, want summary, detail and parameters sheets", files["xl/workbook.xml"])
+	}
+
+	if !strings.Contains(files["xl/worksheets/sheet2.xml"], `<t xml:space="preserve">latest</t>`) {
+		t.Error("stake read at the latest height is not shown as latest")
+	}
+}
+
+func TestWorkpaperCutOffs(t *testing.T) {
+	at := func(id, date, timestamp string) *runs.Run {
+		return &runs.Run{ID: id, Request: models.Request{Address: "0x57", Chain: "eth", Date: date, Timestamp: timestamp}}
+	}
+
+	tests := []struct {
+		name   string
+		stored []*runs.Run
+		mixed  bool
+	}{
+		{"one cut-off", []*runs.Run{at("a", "31/03/2024", "23:59:59"), at("b", "31/03/2024", "23:59:59")}, false},
+		{"different dates", []*runs.Run{at("a", "31/03/2024", "23:59:59"), at("b", "30/03/2024", "23:59:59")}, true},
+		{"different times", []*runs.Run{at("a", "31/03/2024", "23:59:59"), at("b", "31/03/2024", "12:00:00")}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Workpaper(test.stored)
+
+			if mixed := errors.Is(err, ErrMixedCutOffs); mixed != test.mixed || !test.mixed && err != nil {
+				t.Errorf("Workpaper() error = %v, want mixed cut-offs %v", err, test.mixed)
+			}
+		})
+	}
+}
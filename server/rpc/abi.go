@@ -0,0 +1,185 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Returns the address left-padded to a 32-byte word, without the 0x prefix.
+func EncodeAddress(address string) string {
+	return fmt.Sprintf("%064s", strings.ToLower(strings.TrimPrefix(address, "0x")))
+}
+
+// Returns the unsigned integer as a 32-byte word, without the 0x prefix.
+func EncodeUint(n *big.Int) string {
+	return fmt.Sprintf("%064x", n)
+}
+
+// Returns the address as an indexed event topic.
+func AddressTopic(address string) string {
+	return "0x" + EncodeAddress(address)
+}
+
+// Returns calldata for the function signature with already encoded 32-byte arguments.
+func EncodeCall(signature string, args ...string) string {
+	return Selector(signature) + strings.Join(args, "")
+}
+
+// Splits hex encoded return data into 32-byte words.
+func Words(data string) []string {
+	data = strings.TrimPrefix(data, "0x")
+
+	var words []string
+	for len(data) >= 64 {
+		words = append(words, data[:64])
+		data = data[64:]
+	}
+
+	return words
+}
+
+// Decodes a 32-byte word (or topic) as an unsigned integer.
+func DecodeUint(word string) (*big.Int, error) {
+	word = strings.TrimPrefix(word, "0x")
+	if word == "" {
+		return nil, errors.New("empty return data")
+	}
+
+	n, ok := new(big.Int).SetString(word, 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid uint256 %q", word)
+	}
+
+	return n, nil
+}
+
+// Converts a raw integer amount to a decimal amount.
+func ToDecimal(amount *big.Int, decimals int) float64 {
+	value, _ := new(big.Float).Quo(new(big.Float).SetInt(amount), new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))).Float64()
+
+	return value
+}
+
+// Returns amount * numerator / denominator rounded down, or zero when the denominator is zero.
+func Share(amount, numerator, denominator *big.Int) *big.Int {
+	if denominator.Sign() == 0 {
+		return big.NewInt(0)
+	}
+
+	return new(big.Int).Div(new(big.Int).Mul(amount, numerator), denominator)
+}
+
+// Decodes a 32-byte word (or topic) as an address.
+func DecodeAddress(word string) string {
+	word = strings.TrimPrefix(word, "0x")
+	if len(word) < 40 {
+		return ""
+	}
+
+	return "0x" + word[len(word)-40:]
+}
+
+// Decodes the word at index as an unsigned integer.
+func DecodeUintAt(data string, index int) (*big.Int, error) {
+	words := Words(data)
+	if index >= len(words) {
+		return nil, fmt.Errorf("return data has %v words, wanted index %v", len(words), index)
+	}
+
+	return DecodeUint(words[index])
+}
+
+// Decodes a dynamic uint256[] whose offset is stored in the word at index.
+func DecodeUintArray(data string, index int) ([]*big.Int, error) {
+	words := Words(data)
+
+	offset, err := DecodeUintAt(data, index)
+	if err != nil {
+		return nil, err
+	}
+
+	position, ok := bounded(offset, 32*(len(words)-1))
+	if !ok {
+		return nil, errors.New("array offset out of range")
+	}
+	start := position / 32
+
+	length, err := DecodeUint(words[start])
+	if err != nil {
+		return nil, err
+	}
+
+	n, ok := bounded(length, len(words)-start-1)
+	if !ok {
+		return nil, errors.New("array length out of range")
+	}
+
+	values := make([]*big.Int, n)
+	for i := 0; i < n; i++ {
+		values[i], err = DecodeUint(words[start+1+i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// Decodes a string return value. Falls back to a null-padded bytes32, as returned by older tokens such as MKR.
+func DecodeString(data string) string {
+	words := Words(data)
+
+	if len(words) == 1 {
+		raw, err := hex.DecodeString(words[0])
+		if err != nil {
+			return ""
+		}
+
+		return strings.TrimRight(string(raw), "\x00")
+	}
+
+	length, err := DecodeUintAt(data, 1)
+	if err != nil || len(words) < 2 {
+		return ""
+	}
+
+	raw, err := hex.DecodeString(strings.Join(words[2:], ""))
+	if err != nil {
+		return ""
+	}
+
+	n, ok := bounded(length, len(raw))
+	if !ok {
+		return ""
+	}
+
+	return string(raw[:n])
+}
+
+// Returns the decoded value as an int when it lies between zero and max. Return data is untrusted, so lengths and
+// offsets are checked before they are used to index it.
+func bounded(value *big.Int, max int) (int, bool) {
+	if value.Sign() < 0 || !value.IsInt64() || value.Int64() > int64(max) {
+		return 0, false
+	}
+
+	return int(value.Int64()), true
+}
+
+// Decodes a dynamic address[] whose offset is stored in the word at index.
+func DecodeAddressArray(data string, index int) ([]string, error) {
+	values, err := DecodeUintArray(data, index)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, len(values))
+	for i, value := range values {
+		addresses[i] = DecodeAddress(fmt.Sprintf("%064x", value))
+	}
+
+	return addresses, nil
+}
@@ -0,0 +1,214 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Returns the words joined as return data.
+func data(words ...string) string {
+	return "0x" + strings.Join(words, "")
+}
+
+func word(n int64) string {
+	return EncodeUint(big.NewInt(n))
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name, got, want string
+	}{
+		{"address", EncodeAddress("0xAbC0000000000000000000000000000000000001"), strings.Repeat("0", 24) + "abc0000000000000000000000000000000000001"},
+		{"uint", EncodeUint(big.NewInt(255)), strings.Repeat("0", 62) + "ff"},
+		{"topic", AddressTopic("0x1"), "0x" + strings.Repeat("0", 63) + "1"},
+		{"call", EncodeCall("balanceOf(address)", EncodeAddress("0x1")), "0x70a08231" + strings.Repeat("0", 63) + "1"},
+	}
+
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%v = %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestDecodeUint(t *testing.T) {
+	tests := []struct {
+		data  string
+		index int
+		want  string
+		err   bool
+	}{
+		{data(word(7)), 0, "7", false},
+		{data(word(1), word(2), word(3)), 2, "3", false},
+		{data(EncodeUint(new(big.Int).Lsh(big.NewInt(1), 255))), 0, new(big.Int).Lsh(big.NewInt(1), 255).String(), false},
+		{data(word(1)), 1, "", true},
+		{"0x", 0, "", true},
+		{"0x" + strings.Repeat("z", 64), 0, "", true},
+	}
+
+	for _, test := range tests {
+		got, err := DecodeUintAt(test.data, test.index)
+		if (err != nil) != test.err {
+			t.Errorf("DecodeUintAt(%v, %v) error = %v, want error %v", test.data, test.index, err, test.err)
+			continue
+		}
+
+		if err == nil && got.String() != test.want {
+			t.Errorf("DecodeUintAt(%v, %v) = %v, want %v", test.data, test.index, got, test.want)
+		}
+	}
+}
+
+func TestDecodeAddress(t *testing.T) {
+	tests := []struct {
+		word, want string
+	}{
+		{EncodeAddress("0xAbC0000000000000000000000000000000000001"), "0xabc0000000000000000000000000000000000001"},
+		{AddressTopic("0x2"), "0x0000000000000000000000000000000000000002"},
+		{"0x1234", ""},
+	}
+
+	for _, test := range tests {
+		if got := DecodeAddress(test.word); got != test.want {
+			t.Errorf("DecodeAddress(%v) = %v, want %v", test.word, got, test.want)
+		}
+	}
+}
+
+func TestDecodeArrays(t *testing.T) {
+	// (address[], uint256) with the array's offset in the first word
+	addresses := data(word(64), word(9), word(2), EncodeAddress("0xa"), EncodeAddress("0xb"))
+
+	got, err := DecodeAddressArray(addresses, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"0x000000000000000000000000000000000000000a", "0x000000000000000000000000000000000000000b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeAddressArray() = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want []string
+		err  bool
+	}{
+		{"values", data(word(32), word(3), word(1), word(2), word(3)), []string{"1", "2", "3"}, false},
+		{"empty", data(word(32), word(0)), []string{}, false},
+		{"offset out of range", data(word(320), word(1)), nil, true},
+		{"length out of range", data(word(32), word(5), word(1)), nil, true},
+		{"offset of 2^63", data(EncodeUint(new(big.Int).Lsh(big.NewInt(1), 63)), word(1), word(1)), nil, true},
+		{"offset of 2^256-1", data(strings.Repeat("f", 64), word(1), word(1)), nil, true},
+		{"offset past the last word", data(word(64), word(0)), nil, true},
+		{"length of 2^63", data(word(32), EncodeUint(new(big.Int).Lsh(big.NewInt(1), 63)), word(1)), nil, true},
+		{"length of 2^256-1", data(word(32), strings.Repeat("f", 64), word(1)), nil, true},
+		{"no words", "0x", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := DecodeUintArray(test.data, 0)
+			if (err != nil) != test.err {
+				t.Fatalf("DecodeUintArray() error = %v, want error %v", err, test.err)
+			}
+
+			got := []string{}
+			for _, value := range values {
+				got = append(got, value.String())
+			}
+
+			if !test.err && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("DecodeUintArray() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeString(t *testing.T) {
+	// the text right-padded with zeros to a word
+	text := func(s string) string {
+		return hex.EncodeToString([]byte(s)) + strings.Repeat("0", 64-2*len(s))
+	}
+
+	tests := []struct {
+		name, data, want string
+	}{
+		{"string", data(word(32), word(4), text("USDC")), "USDC"},
+		{"bytes32", data(text("MKR")), "MKR"},
+		{"length past data", data(word(32), word(40), word(0)), ""},
+		{"length of 2^63", data(word(32), EncodeUint(new(big.Int).Lsh(big.NewInt(1), 63)), text("USDC")), ""},
+		{"length of 2^256-1", data(word(32), strings.Repeat("f", 64), text("USDC")), ""},
+		{"empty", "0x", ""},
+	}
+
+	for _, test := range tests {
+		if got := DecodeString(test.data); got != test.want {
+			t.Errorf("%v: DecodeString() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestToDecimal(t *testing.T) {
+	tests := []struct {
+		amount   string
+		decimals int
+		want     float64
+	}{
+		{"1500000", 6, 1.5},
+		{"1000000000000000000", 18, 1},
+		{"0", 18, 0},
+		{"42", 0, 42},
+	}
+
+	for _, test := range tests {
+		amount, _ := new(big.Int).SetString(test.amount, 10)
+
+		if got := ToDecimal(amount, test.decimals); got != test.want {
+			t.Errorf("ToDecimal(%v, %v) = %v, want %v", test.amount, test.decimals, got, test.want)
+		}
+	}
+}
+
+func TestShare(t *testing.T) {
+	tests := []struct {
+		amount, numerator, denominator int64
+		want                           int64
+	}{
+		{1000, 1, 4, 250},
+		{10, 1, 3, 3},
+		{1000, 0, 4, 0},
+		{1000, 1, 0, 0},
+	}
+
+	for _, test := range tests {
+		got := Share(big.NewInt(test.amount), big.NewInt(test.numerator), big.NewInt(test.denominator))
+		if got.Int64() != test.want {
+			t.Errorf("Share(%v, %v, %v) = %v, want %v", test.amount, test.numerator, test.denominator, got, test.want)
+		}
+	}
+}
+
+func TestVerifyCommand(t *testing.T) {
+	tests := []struct {
+		name, got, want string
+	}{
+		{"native", VerifyCommand("eth", "0x1", "", 16), `"method":"eth_getBalance","params":["0x1","0x10"]`},
+		{"token", VerifyCommand("eth", "0x1", "0x2", 16), `"method":"eth_call","params":[{"data":"0x70a08231` + strings.Repeat("0", 63) + `1","to":"0x2"},"0x10"]`},
+		{"call", VerifyCall("fantom", "0x3", "getStake(address,uint256)", 0, EncodeAddress("0x1"), word(5)), `"params":[{"data":"` + Selector("getStake(address,uint256)") + strings.Repeat("0", 63) + "1" + word(5) + `","to":"0x3"},"latest"]`},
+	}
+
+	for _, test := range tests {
+		if !strings.Contains(test.got, test.want) {
+			t.Errorf("%v: command %v does not contain %v", test.name, test.got, test.want)
+		}
+	}
+
+	if got := VerifyCall("fantom", "0x3", "lastValidatorID()", 1); !strings.HasSuffix(got, `"$FANTOM_RPC_URL"`) {
+		t.Errorf("command %v does not use the chain's RPC variable", got)
+	}
+}
@@ -0,0 +1,101 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/bits"
+)
+
+// Round constants for Keccak-f[1600]
+var roundConstants = [24]uint64{
+	0x0000000000000001, 0x0000000000008082, 0x800000000000808A, 0x8000000080008000,
+	0x000000000000808B, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+	0x000000000000008A, 0x0000000000000088, 0x0000000080008009, 0x000000008000000A,
+	0x000000008000808B, 0x800000000000008B, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800A, 0x800000008000000A,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+// Rotation offsets indexed by lane (x + 5y)
+var rotations = [25]int{
+	0, 1, 62, 28, 27,
+	36, 44, 6, 55, 20,
+	3, 10, 43, 25, 39,
+	41, 45, 15, 21, 8,
+	18, 2, 61, 56, 14,
+}
+
+// Keccak-256 as used by Ethereum (original Keccak padding, not NIST SHA3-256).
+func Keccak256(data []byte) []byte {
+	const rate = 136
+
+	var state [25]uint64
+
+	// pad10*1 with the Keccak domain byte
+	padded := make([]byte, len(data), len(data)+rate)
+	copy(padded, data)
+	padded = append(padded, 0x01)
+	for len(padded)%rate != 0 {
+		padded = append(padded, 0x00)
+	}
+	padded[len(padded)-1] |= 0x80
+
+	for offset := 0; offset < len(padded); offset += rate {
+		for i := 0; i < rate/8; i++ {
+			state[i] ^= binary.LittleEndian.Uint64(padded[offset+i*8:])
+		}
+		keccakF(&state)
+	}
+
+	out := make([]byte, 32)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(out[i*8:], state[i])
+	}
+
+	return out
+}
+
+// Returns the 4-byte function selector for a signature such as "balanceOf(address)".
+func Selector(signature string) string {
+	return "0x" + hex.EncodeToString(Keccak256([]byte(signature))[:4])
+}
+
+// Returns the 32-byte event topic for a signature such as "Transfer(address,address,uint256)".
+func Topic(signature string) string {
+	return "0x" + hex.EncodeToString(Keccak256([]byte(signature)))
+}
+
+func keccakF(a *[25]uint64) {
+	var c [5]uint64
+	var b [25]uint64
+
+	for round := 0; round < 24; round++ {
+		// theta
+		for x := 0; x < 5; x++ {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		for x := 0; x < 5; x++ {
+			d := c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+			for y := 0; y < 25; y += 5 {
+				a[x+y] ^= d
+			}
+		}
+
+		// rho and pi
+		for x := 0; x < 5; x++ {
+			for y := 0; y < 5; y++ {
+				b[y+5*((2*x+3*y)%5)] = bits.RotateLeft64(a[x+5*y], rotations[x+5*y])
+			}
+		}
+
+		// chi
+		for y := 0; y < 25; y += 5 {
+			for x := 0; x < 5; x++ {
+				a[x+y] = b[x+y] ^ (^b[(x+1)%5+y] & b[(x+2)%5+y])
+			}
+		}
+
+		// iota
+		a[0] ^= roundConstants[round]
+	}
+}
@@ -0,0 +1,53 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestKeccak256(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty", "", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{"sentence", "The quick brown fox jumps over the lazy dog", "4d741b6f1eb29cb2a9b9911c82f56fa8d73b04959d3d9d222895df6c0b28aa15"},
+		// exactly one block of input, so the padding takes a block of its own
+		{"one block", strings.Repeat("a", 136), "a6c4d403279fe3e0af03729caada8374b5ca54d8065329a3ebcaeb4b60aa386e"},
+		{"two blocks", strings.Repeat("a", 200), "96ea54061def936c4be90b518992fdc6f12f535068a256229aca54267b4d084d"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := hex.EncodeToString(Keccak256([]byte(test.input))); got != test.want {
+				t.Errorf("Keccak256() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSelectorAndTopic(t *testing.T) {
+	tests := []struct {
+		signature string
+		selector  string
+	}{
+		{"balanceOf(address)", "0x70a08231"},
+		{"transfer(address,uint256)", "0xa9059cbb"},
+		{"decimals()", "0x313ce567"},
+		{"symbol()", "0x95d89b41"},
+	}
+
+	for _, test := range tests {
+		if got := Selector(test.signature); got != test.selector {
+			t.Errorf("Selector(%v) = %v, want %v", test.signature, got, test.selector)
+		}
+	}
+
+	const transfer = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+	if got := Topic("Transfer(address,address,uint256)"); got != transfer {
+		t.Errorf("Topic(Transfer) = %v, want %v", got, transfer)
+	}
+}
@@ -0,0 +1,293 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/recorder"
+)
+
+// JSON-RPC request envelope
+type request struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+// JSON-RPC response envelope
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Returned by contract calls that return no data, as calls to functions a contract does not have do when it has a
+// fallback function, or calls to an address without code
+var ErrNoReturnData = errors.New("no return data")
+
+// Phrases in node errors saying a call ran and failed in the contract, as opposed to the node failing to answer
+var reverts = []string{"revert", "vm execution error", "invalid opcode", "invalid jump"}
+
+// Error returned by a node for a JSON-RPC call
+type Error struct {
+	Method  string
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v failed (code: %v): %v", e.Method, e.Code, e.Message)
+}
+
+// Reports whether a contract call failed in the contract: it reverted, or returned nothing. Probes take this to mean
+// the contract does not have the function. Any other error means the contract could not be read, and is not an answer.
+func Reverted(err error) bool {
+	if errors.Is(err, ErrNoReturnData) {
+		return true
+	}
+
+	var node *Error
+	if !errors.As(err, &node) {
+		return false
+	}
+
+	// geth and most clients report reverts with code 3 and their reason in the message
+	if node.Code == 3 {
+		return true
+	}
+
+	message := strings.ToLower(node.Message)
+	for _, phrase := range reverts {
+		if strings.Contains(message, phrase) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the JSON-RPC endpoint configured for the chain.
+func Endpoint(chain string) (string, error) {
+	blockchain, err := models.DetermineChain(chain)
+	if err != nil {
+		return "", err
+	}
+
+	url, ok := initialisers.RPCURLS[blockchain]
+	if !ok || url == "" {
+		return "", fmt.Errorf("no rpc endpoint configured for %v", blockchain)
+	}
+
+	return url, nil
+}
+
+// Performs a JSON-RPC call against the chain's node and returns the raw result.
+func Call(ctx context.Context, chain, method string, params ...interface{}) (json.RawMessage, error) {
+	url, err := Endpoint(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	return CallURL(ctx, url, method, params...)
+}
+
+// Performs a JSON-RPC call against a specific node and returns the raw result.
+func CallURL(ctx context.Context, url, method string, params ...interface{}) (json.RawMessage, error) {
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	payload, err := json.Marshal(request{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := recorder.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result response
+
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %v response (status %v): %v", method, resp.StatusCode, err)
+	}
+
+	if result.Error != nil {
+		return nil, &Error{Method: method, Code: result.Error.Code, Message: result.Error.Message}
+	}
+
+	return result.Result, nil
+}
+
+// Returns the block parameter for a block number. Zero or negative blocks mean latest.
+func BlockTag(block int) string {
+	if block <= 0 {
+		return "latest"
+	}
+
+	return "0x" + strconv.FormatInt(int64(block), 16)
+}
+
+// Returns a curl command reproducing a balance read against any node of the chain: eth_getBalance for the native
+// asset, or an eth_call of balanceOf for a token. The node is left as the chain's RPC variable, e.g. $ETH_RPC_URL.
+func VerifyCommand(chain, address, tokenAddress string, block int) string {
+	if strings.HasPrefix(tokenAddress, "0x") {
+		return VerifyCall(chain, tokenAddress, "balanceOf(address)", block, EncodeAddress(address))
+	}
+
+	return curl(chain, request{JSONRPC: "2.0", ID: 1, Method: "eth_getBalance", Params: []interface{}{address, BlockTag(block)}})
+}
+
+// Returns a curl command reproducing an eth_call of the function on the contract with the encoded arguments, for
+// amounts read from contracts other than by balanceOf.
+func VerifyCall(chain, contract, signature string, block int, args ...string) string {
+	return curl(chain, request{JSONRPC: "2.0", ID: 1, Method: "eth_call", Params: []interface{}{
+		map[string]string{"to": contract, "data": EncodeCall(signature, args...)},
+		BlockTag(block),
+	}})
+}
+
+func curl(chain string, call request) string {
+	body, _ := json.Marshal(call)
+
+	return fmt.Sprintf(`curl -s -X POST -H "Content-Type: application/json" --data '%s' "$%v_RPC_URL"`, body, strings.ToUpper(chain))
+}
+
+// Returns the contract bytecode at the address as of the block.
+func GetCode(ctx context.Context, chain, address string, block int) (string, error) {
+	raw, err := Call(ctx, chain, "eth_getCode", address, BlockTag(block))
+	if err != nil {
+		return "", err
+	}
+
+	var code string
+
+	err = json.Unmarshal(raw, &code)
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// Performs a read-only contract call at the block and returns the hex encoded return data.
+func EthCall(ctx context.Context, chain, to, data string, block int) (string, error) {
+	call := map[string]string{"to": to, "data": data}
+
+	raw, err := Call(ctx, chain, "eth_call", call, BlockTag(block))
+	if err != nil {
+		return "", err
+	}
+
+	var result string
+
+	err = json.Unmarshal(raw, &result)
+	if err != nil {
+		return "", err
+	}
+
+	return result, nil
+}
+
+// Event log as returned by eth_getLogs
+type Log struct {
+	Address         string   `json:"address"`
+	Topics          []string `json:"topics"`
+	Data            string   `json:"data"`
+	BlockNumber     string   `json:"blockNumber"`
+	TransactionHash string   `json:"transactionHash"`
+}
+
+// Filter for eth_getLogs. Each topic position holds nil (any), a single topic or a list of alternatives.
+type LogFilter struct {
+	FromBlock int
+	ToBlock   int
+	Address   []string
+	Topics    []interface{}
+}
+
+// Returns the logs matching the filter.
+func GetLogs(ctx context.Context, chain string, filter LogFilter) ([]Log, error) {
+	params := map[string]interface{}{
+		"fromBlock": "0x" + strconv.FormatInt(int64(filter.FromBlock), 16),
+		"toBlock":   BlockTag(filter.ToBlock),
+		"topics":    filter.Topics,
+	}
+
+	if len(filter.Address) > 0 {
+		params["address"] = filter.Address
+	}
+
+	raw, err := Call(ctx, chain, "eth_getLogs", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var logs []Log
+
+	err = json.Unmarshal(raw, &logs)
+	if err != nil {
+		return nil, err
+	}
+
+	return logs, nil
+}
+
+// Calls a view function with already encoded arguments and decodes the first word as an unsigned integer.
+func CallUint(ctx context.Context, chain, to, signature string, block int, args ...string) (*big.Int, error) {
+	result, err := EthCall(ctx, chain, to, EncodeCall(signature, args...), block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(Words(result)) == 0 {
+		return nil, fmt.Errorf("%v: %w", signature, ErrNoReturnData)
+	}
+
+	return DecodeUintAt(result, 0)
+}
+
+// Calls a view function with already encoded arguments and decodes the first word as an address.
+func CallAddress(ctx context.Context, chain, to, signature string, block int, args ...string) (string, error) {
+	result, err := EthCall(ctx, chain, to, EncodeCall(signature, args...), block)
+	if err != nil {
+		return "", err
+	}
+
+	words := Words(result)
+	if len(words) == 0 {
+		return "", fmt.Errorf("%v: %w", signature, ErrNoReturnData)
+	}
+
+	return DecodeAddress(words[0]), nil
+}
@@ -0,0 +1,31 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestReverted(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"revert code", &Error{Method: "eth_call", Code: 3, Message: "execution reverted: not a debt token"}, true},
+		{"revert message", &Error{Method: "eth_call", Code: -32000, Message: "execution reverted"}, true},
+		{"parity revert", &Error{Method: "eth_call", Code: -32015, Message: "VM execution error."}, true},
+		{"invalid opcode", &Error{Method: "eth_call", Code: -32000, Message: "invalid opcode: INVALID"}, true},
+		{"no return data", fmt.Errorf("isCToken(): %w", ErrNoReturnData), true},
+		{"missing state", &Error{Method: "eth_call", Code: -32000, Message: "missing trie node"}, false},
+		{"rate limited", &Error{Method: "eth_call", Code: -32005, Message: "daily request count exceeded"}, false},
+		{"connection failed", errors.New("dial tcp: connection refused"), false},
+		{"no error", nil, false},
+	}
+
+	for _, test := range tests {
+		if got := Reverted(test.err); got != test.want {
+			t.Errorf("%v: Reverted(%v) = %v, want %v", test.name, test.err, got, test.want)
+		}
+	}
+}
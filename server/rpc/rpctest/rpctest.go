@@ -0,0 +1,160 @@
+// Package rpctest provides a JSON-RPC node for tests of packages reading contracts.
+package rpctest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+// Answer to a call: a result, a JSON-RPC error, or an HTTP status for a node that fails to answer
+type reply struct {
+	result  interface{}
+	code    int
+	message string
+	status  int
+}
+
+// JSON-RPC node configured as a chain's endpoint for the duration of a test. Contract calls nobody set an answer for
+// revert, as calls to functions a contract does not have do.
+type Node struct {
+	URL string
+
+	mu      sync.Mutex
+	calls   map[string]reply
+	methods map[string]reply
+}
+
+// Starts a node and configures it as the chain's RPC endpoint until the test ends.
+func New(t *testing.T, chain string) *Node {
+	t.Helper()
+
+	node := &Node{calls: map[string]reply{}, methods: map[string]reply{}}
+
+	server := httptest.NewServer(http.HandlerFunc(node.serve))
+	t.Cleanup(server.Close)
+
+	node.URL = server.URL
+
+	saved, had := initialisers.RPCURLS[chain]
+	initialisers.RPCURLS[chain] = server.URL
+
+	t.Cleanup(func() {
+		if had {
+			initialisers.RPCURLS[chain] = saved
+		} else {
+			delete(initialisers.RPCURLS, chain)
+		}
+	})
+
+	return node
+}
+
+// Answers calls of the function on the contract with the words as return data. Arguments, when given, must match;
+// otherwise calls with any arguments are answered.
+func (n *Node) Return(contract, signature string, args []string, words ...string) {
+	n.set(contract, signature, args, reply{result: "0x" + strings.Join(words, "")})
+}
+
+// Makes calls of the function on the contract revert.
+func (n *Node) Revert(contract, signature string, args ...string) {
+	n.set(contract, signature, args, reply{code: 3, message: "execution reverted"})
+}
+
+// Makes calls of the function on the contract fail at the node, as when the provider is down.
+func (n *Node) Fail(contract, signature string, args ...string) {
+	n.set(contract, signature, args, reply{status: http.StatusServiceUnavailable, message: "service unavailable"})
+}
+
+// Answers every request for a method other than eth_call with the result.
+func (n *Node) Method(method string, result interface{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.methods[method] = reply{result: result}
+}
+
+func (n *Node) set(contract, signature string, args []string, answer reply) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.calls[strings.ToLower(contract)+" "+rpc.EncodeCall(signature, args...)] = answer
+}
+
+// Returns the answer to the eth_call: one set for its exact calldata, then one set for its function.
+func (n *Node) call(to, data string) reply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	to = strings.ToLower(to)
+
+	if answer, ok := n.calls[to+" "+data]; ok {
+		return answer
+	}
+
+	if len(data) >= 10 {
+		if answer, ok := n.calls[to+" "+data[:10]]; ok {
+			return answer
+		}
+	}
+
+	return reply{code: 3, message: "execution reverted"}
+}
+
+func (n *Node) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	var request struct {
+		ID     int               `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+
+	if err := json.Unmarshal(body, &request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var answer reply
+
+	if request.Method == "eth_call" && len(request.Params) > 0 {
+		var call struct {
+			To   string `json:"to"`
+			Data string `json:"data"`
+		}
+		json.Unmarshal(request.Params[0], &call)
+
+		answer = n.call(call.To, call.Data)
+	} else {
+		n.mu.Lock()
+		var ok bool
+		answer, ok = n.methods[request.Method]
+		n.mu.Unlock()
+
+		if !ok {
+			answer = reply{code: -32601, message: "the method " + request.Method + " does not exist"}
+		}
+	}
+
+	if answer.status != 0 {
+		w.WriteHeader(answer.status)
+		w.Write([]byte(answer.message))
+		return
+	}
+
+	response := map[string]interface{}{"jsonrpc": "2.0", "id": request.ID}
+	if answer.message != "" {
+		response["error"] = map[string]interface{}{"code": answer.code, "message": answer.message}
+	} else {
+		response["result"] = answer.result
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
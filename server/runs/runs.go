@@ -0,0 +1,136 @@
+package runs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/blocks"
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+)
+
+// Raw provider responses kept with a run
+const (
+	RawBlock         = "block"
+	RawNativeBalance = "native_balance"
+	RawTokenBalances = "erc20_balances"
+	RawExchanges     = "provider_exchanges"
+)
+
+// Run ids start with the UTC creation time so they sort chronologically
+const idTimeFormat = "20060102T150405Z"
+
+// Run ids and tenants are used in file paths, so they are limited to characters safe in paths
+var validID = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+// Returned when no run is stored with the id, or none the tenant can read
+var ErrNotFound = errors.New("run not found")
+
+// Proof of balance performed for one address and chain at the cut-off, stored so it can be exported and re-performed
+type Run struct {
+	ID string `json:"id"`
+	// Tenant the run was performed for, empty for runs made from the command line
+	Tenant    string                  `json:"tenant,omitempty"`
+	CreatedAt time.Time               `json:"created_at"`
+	Request   models.Request          `json:"request"`
+	Block     blocks.Block            `json:"block"`
+	Rows      []models.ClientResponse `json:"rows"`
+	// Moralis responses keyed by RawBlock, RawNativeBalance and RawTokenBalances. Balances are kept as received; a
+	// token list read across several pages is kept as an array of the pages. RawExchanges holds every provider
+	// request of the run and its response, redacted, from prices to contract reads.
+	Raw map[string]json.RawMessage `json:"raw_responses"`
+	// Id of the run this run re-performed
+	ReperformanceOf string `json:"reperformance_of,omitempty"`
+}
+
+// Returns a new run for the tenant's request with a unique, time ordered id.
+func New(tenant string, request models.Request) *Run {
+	now := time.Now().UTC()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	return &Run{
+		ID:        now.Format(idTimeFormat) + "-" + hex.EncodeToString(suffix),
+		Tenant:    tenant,
+		CreatedAt: now,
+		Request:   request,
+		Raw:       map[string]json.RawMessage{},
+	}
+}
+
+// Writes the run to the runs directory, in a directory of its own for each tenant.
+func Save(run *Run) error {
+	if run.Tenant != "" && !validID.MatchString(run.Tenant) {
+		return fmt.Errorf("invalid tenant %q", run.Tenant)
+	}
+
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Join(initialisers.RUNSDIR, run.Tenant), 0755)
+	if err != nil {
+		return fmt.Errorf("error creating runs directory: %v", err)
+	}
+
+	return os.WriteFile(path(run.Tenant, run.ID), data, 0644)
+}
+
+// Reads a stored run by id from any tenant, for the command line.
+func Load(id string) (*Run, error) {
+	if !validID.MatchString(id) {
+		return nil, fmt.Errorf("invalid run id %q", id)
+	}
+
+	run, err := LoadFor("", id)
+	if err == nil || !errors.Is(err, ErrNotFound) {
+		return run, err
+	}
+
+	tenants, _ := filepath.Glob(filepath.Join(initialisers.RUNSDIR, "*", id+".json"))
+	if len(tenants) == 0 {
+		return nil, err
+	}
+
+	return read(tenants[0], id)
+}
+
+// Reads a stored run by id from the tenant's runs only. Runs of other tenants are reported as not found.
+func LoadFor(tenant, id string) (*Run, error) {
+	if !validID.MatchString(id) || (tenant != "" && !validID.MatchString(tenant)) {
+		return nil, fmt.Errorf("invalid run id %q", id)
+	}
+
+	return read(path(tenant, id), id)
+}
+
+func read(file, id string) (*Run, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var run Run
+
+	err = json.Unmarshal(data, &run)
+	if err != nil {
+		return nil, fmt.Errorf("error reading run %v: %v", id, err)
+	}
+
+	return &run, nil
+}
+
+func path(tenant, id string) string {
+	return filepath.Join(initialisers.RUNSDIR, tenant, id+".json")
+}
@@ -0,0 +1,91 @@
+package runs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+)
+
+func TestNew(t *testing.T) {
+	first := New("acme", models.Request{Address: "0x57"})
+	second := New("acme", models.Request{Address: "0x57"})
+
+	if first.ID == second.ID {
+		t.Errorf("New() returned the same id twice: %v", first.ID)
+	}
+
+	if !validID.MatchString(first.ID) || !strings.HasPrefix(first.ID, first.CreatedAt.Format(idTimeFormat)+"-") {
+		t.Errorf("New() id = %v, want the creation time and a suffix", first.ID)
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	saved := initialisers.RUNSDIR
+	t.Cleanup(func() { initialisers.RUNSDIR = saved })
+	initialisers.RUNSDIR = t.TempDir()
+
+	tenant := New("acme", models.Request{Address: "0x57", Chain: "eth"})
+	local := New("", models.Request{Address: "0x58", Chain: "eth"})
+
+	for _, run := range []*Run{tenant, local} {
+		if err := Save(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Save(&Run{ID: "a", Tenant: "../other"}); err == nil {
+		t.Error("Save() with an invalid tenant succeeded")
+	}
+
+	tests := []struct {
+		name    string
+		load    func() (*Run, error)
+		want    *Run
+		missing bool
+		err     string
+	}{
+		{"tenant's run", func() (*Run, error) { return LoadFor("acme", tenant.ID) }, tenant, false, ""},
+		{"other tenant's run", func() (*Run, error) { return LoadFor("other", tenant.ID) }, nil, true, ""},
+		{"command line run", func() (*Run, error) { return LoadFor("", local.ID) }, local, false, ""},
+		{"unknown run", func() (*Run, error) { return LoadFor("acme", "20240101T000000Z-00000000") }, nil, true, ""},
+		{"invalid id", func() (*Run, error) { return LoadFor("acme", "../acme/"+tenant.ID) }, nil, false, "invalid run id"},
+		{"invalid tenant", func() (*Run, error) { return LoadFor("../acme", tenant.ID) }, nil, false, "invalid run id"},
+		{"any tenant", func() (*Run, error) { return Load(tenant.ID) }, tenant, false, ""},
+		{"any tenant without a tenant", func() (*Run, error) { return Load(local.ID) }, local, false, ""},
+		{"any tenant unknown", func() (*Run, error) { return Load("20240101T000000Z-00000000") }, nil, true, ""},
+		{"any tenant invalid id", func() (*Run, error) { return Load("*") }, nil, false, "invalid run id"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			run, err := test.load()
+
+			if test.missing {
+				if !errors.Is(err, ErrNotFound) {
+					t.Fatalf("error = %v, want %v", err, ErrNotFound)
+				}
+
+				return
+			}
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) || errors.Is(err, ErrNotFound) {
+					t.Fatalf("error = %v, want %q", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if run.ID != test.want.ID || run.Tenant != test.want.Tenant || run.Request.Address != test.want.Request.Address || !run.CreatedAt.Equal(test.want.CreatedAt) {
+				t.Errorf("loaded %+v, want %+v", run, test.want)
+			}
+		})
+	}
+}
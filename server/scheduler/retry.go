@@ -0,0 +1,196 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+)
+
+// Longest wait between attempts, whatever the backoff or a provider's Retry-After asks for
+const maxBackoff = time.Minute
+
+// Wait before the first retry, doubled for each one after it
+const baseBackoff = 500 * time.Millisecond
+
+// Returned while a provider's circuit is open, without sending the request
+var ErrCircuitOpen = errors.New("circuit open after repeated failures")
+
+// Failure of a provider request once retries are exhausted or while the provider's circuit is open
+type ProviderError struct {
+	Host string
+	// Status and body of the last response, zero and empty when no response was received
+	Status   int
+	Body     []byte
+	Attempts int
+	Err      error
+}
+
+func (e *ProviderError) Error() string {
+	switch {
+	case e.Err != nil && e.Attempts == 0:
+		return fmt.Sprintf("provider %v: %v", e.Host, e.Err)
+	case e.Err != nil:
+		return fmt.Sprintf("provider %v failed after %v attempts: %v", e.Host, e.Attempts, e.Err)
+	case len(e.Body) == 0:
+		return fmt.Sprintf("provider %v returned %v %v after %v attempts", e.Host, e.Status, http.StatusText(e.Status), e.Attempts)
+	default:
+		return fmt.Sprintf("provider %v returned %v %v after %v attempts: %s", e.Host, e.Status, http.StatusText(e.Status), e.Attempts, excerpt(e.Body))
+	}
+}
+
+// Returns the start of a response body, enough to show a provider's error message.
+func excerpt(body []byte) []byte {
+	const limit = 200
+
+	body = bytes.TrimSpace(body)
+	if len(body) > limit {
+		return append(body[:limit:limit], "..."...)
+	}
+
+	return body
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Phrases in provider messages saying the plan's quota or compute units are used up. These are not worth retrying
+// until the quota resets or the plan is upgraded, whatever status they come with.
+var planLimits = []string{"plan", "upgrade", "usage has been consumed", "compute units", "quota"}
+
+// Reports whether a provider's error message says the plan's quota is used up.
+func PlanLimited(message string) bool {
+	message = strings.ToLower(message)
+
+	for _, phrase := range planLimits {
+		if strings.Contains(message, phrase) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether the status is worth retrying: rate limiting and server errors.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// Returns the wait before the retry following the attempt. A Retry-After header takes precedence; otherwise the
+// wait doubles with each attempt and is jittered so concurrent callers do not retry together.
+func backoff(attempt int, header http.Header) time.Duration {
+	if wait, ok := retryAfter(header); ok {
+		if wait > maxBackoff {
+			return maxBackoff
+		}
+
+		return wait
+	}
+
+	wait := baseBackoff << attempt
+	if wait > maxBackoff || wait <= 0 {
+		wait = maxBackoff
+	}
+
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// Returns the wait a Retry-After header asks for, given in seconds or as an HTTP date.
+func retryAfter(header http.Header) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait, true
+		}
+
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// Waits for the duration, returning early with the context's error when it is cancelled.
+func sleep(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Circuit breaker for one provider. After the configured number of failed requests in a row the circuit opens and
+// requests fail immediately. Once the cooldown has passed a single request is let through: success closes the
+// circuit, failure opens it for another cooldown.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// Reports whether a request may be sent.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	threshold := initialisers.BREAKERTHRESHOLD
+	if threshold <= 0 || b.failures < threshold {
+		return true
+	}
+
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+
+	b.probing = true
+
+	return true
+}
+
+// Lets another request probe the provider after a probe ended without an outcome, such as when its caller gave up.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// Records the outcome of a request.
+func (b *breaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if ok {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+
+	if threshold := initialisers.BREAKERTHRESHOLD; threshold > 0 && b.failures >= threshold {
+		b.openUntil = time.Now().Add(initialisers.BREAKERCOOLDOWN)
+	}
+}
@@ -0,0 +1,212 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+)
+
+// Sets the breaker and retry configuration for the test, restoring it afterwards.
+func configure(t *testing.T, threshold, retries int, cooldown time.Duration) {
+	t.Helper()
+
+	saved := []interface{}{initialisers.BREAKERTHRESHOLD, initialisers.PROVIDERRETRIES, initialisers.BREAKERCOOLDOWN, initialisers.PROVIDERTIMEOUT}
+	initialisers.BREAKERTHRESHOLD, initialisers.PROVIDERRETRIES, initialisers.BREAKERCOOLDOWN = threshold, retries, cooldown
+	initialisers.PROVIDERTIMEOUT = 5 * time.Second
+
+	t.Cleanup(func() {
+		initialisers.BREAKERTHRESHOLD = saved[0].(int)
+		initialisers.PROVIDERRETRIES = saved[1].(int)
+		initialisers.BREAKERCOOLDOWN = saved[2].(time.Duration)
+		initialisers.PROVIDERTIMEOUT = saved[3].(time.Duration)
+	})
+}
+
+func TestBreaker(t *testing.T) {
+	const cooldown = 20 * time.Millisecond
+
+	// each step is an action and whether a request is allowed after it
+	type step struct {
+		action string
+		allow  bool
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"closed below threshold", []step{{"fail", true}, {"fail", true}}},
+		{"opens at threshold", []step{{"fail", true}, {"fail", true}, {"fail", false}}},
+		{"success resets failures", []step{{"fail", true}, {"fail", true}, {"succeed", true}, {"fail", true}, {"fail", true}}},
+		{"one probe after cooldown", []step{{"fail", true}, {"fail", true}, {"fail", false}, {"wait", true}, {"none", false}}},
+		{"probe success closes", []step{{"fail", true}, {"fail", true}, {"fail", false}, {"wait", true}, {"succeed", true}, {"none", true}}},
+		{"probe failure reopens", []step{{"fail", true}, {"fail", true}, {"fail", false}, {"wait", true}, {"fail", false}}},
+		{"released probe lets another through", []step{{"fail", true}, {"fail", true}, {"fail", false}, {"wait", true}, {"release", true}, {"none", false}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configure(t, 3, 0, cooldown)
+
+			var b breaker
+
+			for i, s := range test.steps {
+				switch s.action {
+				case "fail":
+					b.record(false)
+				case "succeed":
+					b.record(true)
+				case "release":
+					b.release()
+				case "wait":
+					time.Sleep(2 * cooldown)
+				}
+
+				if got := b.allow(); got != s.allow {
+					t.Fatalf("step %v (%v): allow() = %v, want %v", i, s.action, got, s.allow)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	configure(t, 0, 0, time.Minute)
+
+	var b breaker
+
+	for i := 0; i < 10; i++ {
+		b.record(false)
+	}
+
+	if !b.allow() {
+		t.Fatal("allow() = false with the breaker turned off")
+	}
+}
+
+func TestCancelledProbeReleasesCircuit(t *testing.T) {
+	configure(t, 1, 0, time.Millisecond)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	tr := &transport{next: http.DefaultTransport}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	p := tr.provider(req.URL.Hostname())
+
+	// open the circuit and let the cooldown pass, so the next request is the probe
+	p.breaker.record(false)
+	time.Sleep(5 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, _, _, err := tr.send(req.WithContext(ctx), nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("send() error = %v, want the caller's deadline", err)
+	}
+
+	if !p.breaker.allow() {
+		t.Fatal("circuit stayed open after the probe's caller gave up")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		header map[string]string
+		hits   int32
+	}{
+		{"server error retried", http.StatusBadGateway, "", nil, 3},
+		{"rate limit retried", http.StatusTooManyRequests, `{"message":"Rate limit exceeded"}`, nil, 3},
+		{"plan limit not retried", http.StatusTooManyRequests, `{"message":"Your plan's compute units have been used up"}`, nil, 1},
+		{"plan limit with Retry-After retried", http.StatusTooManyRequests, `{"message":"Your plan's compute units have been used up"}`, map[string]string{"Retry-After": "0"}, 3},
+		{"client error not retried", http.StatusBadRequest, "", nil, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configure(t, 0, 2, time.Minute)
+
+			var hits int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+				for name, value := range test.header {
+					w.Header().Set(name, value)
+				}
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			tr := &transport{next: http.DefaultTransport}
+			req, _ := http.NewRequest("GET", server.URL, nil)
+
+			tr.send(req, nil)
+
+			if hits := atomic.LoadInt32(&hits); hits != test.hits {
+				t.Fatalf("requests = %v, want %v", hits, test.hits)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		wait  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, true},
+	}
+
+	for _, test := range tests {
+		header := http.Header{}
+		if test.value != "" {
+			header.Set("Retry-After", test.value)
+		}
+
+		wait, ok := retryAfter(header)
+		if wait != test.wait || ok != test.ok {
+			t.Errorf("retryAfter(%q) = %v, %v, want %v, %v", test.value, wait, ok, test.wait, test.ok)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		want := baseBackoff << attempt
+		if want > maxBackoff {
+			want = maxBackoff
+		}
+
+		wait := backoff(attempt, http.Header{})
+		if wait < want/2 || wait > want {
+			t.Errorf("backoff(%v) = %v, want between %v and %v", attempt, wait, want/2, want)
+		}
+	}
+
+	header := http.Header{"Retry-After": {"3600"}}
+	if wait := backoff(0, header); wait != maxBackoff {
+		t.Errorf("backoff with Retry-After of an hour = %v, want %v", wait, maxBackoff)
+	}
+}
@@ -0,0 +1,402 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+)
+
+// Host of the Moralis API, which has its own limits and compute unit budget
+const MoralisHost = "deep-index.moralis.io"
+
+// Compute units Moralis charges for each endpoint, keyed by the last segment of the path
+var computeUnits = map[string]float64{
+	"dateToBlock": 1,
+	"balance":     10,
+	"erc20":       100,
+	"price":       50,
+	"nft":         50,
+	"transfers":   50,
+}
+
+// Request headers that change a provider's answer, so requests differing in them are different requests
+var IdentityHeaders = []string{"X-Cosmos-Block-Height"}
+
+// Returns the request headers in IdentityHeaders that are set, one "name: value" line each.
+func Identity(header http.Header) string {
+	var lines string
+
+	for _, name := range IdentityHeaders {
+		if value := header.Get(name); value != "" {
+			lines += "\n" + name + ": " + value
+		}
+	}
+
+	return lines
+}
+
+// Compute units assumed for Moralis endpoints not listed above
+const defaultComputeUnits = 10
+
+// HTTP client for provider requests, limited per provider host and retried when the provider is struggling
+var Client = &http.Client{Transport: Transport}
+
+// Transport applying the provider limits. Identical requests already in flight share one response.
+var Transport http.RoundTripper = &transport{next: http.DefaultTransport}
+
+type transport struct {
+	next http.RoundTripper
+
+	mu        sync.Mutex
+	providers map[string]*provider
+	flights   map[string]*flight
+}
+
+// Concurrency and rate limits of one provider host
+type provider struct {
+	slots    chan struct{}
+	requests *bucket
+	units    *bucket
+	breaker  breaker
+}
+
+// Request in flight, which identical requests wait for instead of being sent again. It is sent on a context of its
+// own, so one caller giving up does not fail the others, and is cancelled once every caller has given up.
+type flight struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	status  int
+	header  http.Header
+	body    []byte
+	err     error
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var payload []byte
+
+	if req.Body != nil {
+		var err error
+
+		payload, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		req.Body = io.NopCloser(bytes.NewReader(payload))
+	}
+
+	// every provider call is a read, so identical requests get identical answers
+	key := req.Method + " " + req.URL.String() + Identity(req.Header) + "\n" + string(payload)
+
+	t.mu.Lock()
+
+	if t.flights == nil {
+		t.flights = map[string]*flight{}
+	}
+
+	current, ok := t.flights[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(detached{req.Context()})
+
+		current = &flight{done: make(chan struct{}), cancel: cancel}
+		t.flights[key] = current
+
+		go t.fly(key, current, req.Clone(ctx), payload)
+	}
+
+	current.waiters++
+
+	t.mu.Unlock()
+
+	select {
+	case <-current.done:
+		return current.response(req)
+	case <-req.Context().Done():
+		t.leave(key, current)
+		return nil, req.Context().Err()
+	}
+}
+
+// Sends the flight's request and hands the response to everyone waiting for it.
+func (t *transport) fly(key string, current *flight, req *http.Request, payload []byte) {
+	current.status, current.header, current.body, current.err = t.send(req, payload)
+
+	t.mu.Lock()
+	if t.flights[key] == current {
+		delete(t.flights, key)
+	}
+	t.mu.Unlock()
+
+	current.cancel()
+	close(current.done)
+}
+
+// Stops waiting for the flight, cancelling it when no one else is waiting. A later identical request is sent again.
+func (t *transport) leave(key string, current *flight) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current.waiters--
+	if current.waiters > 0 {
+		return
+	}
+
+	if t.flights[key] == current {
+		delete(t.flights, key)
+	}
+
+	current.cancel()
+}
+
+// Context keeping the values of its parent but not its deadline or cancellation
+type detached struct {
+	parent context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
+
+func (d detached) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+// Sends the request, retrying rate limiting, server errors and failed connections with backoff. Requests fail
+// without being sent while the provider's circuit is open.
+func (t *transport) send(req *http.Request, payload []byte) (int, http.Header, []byte, error) {
+	host := req.URL.Hostname()
+	p := t.provider(host)
+
+	if !p.breaker.allow() {
+		return 0, nil, nil, &ProviderError{Host: host, Err: ErrCircuitOpen}
+	}
+
+	attempts := 0
+
+	for {
+		status, header, body, err := t.attempt(p, req, payload)
+		attempts++
+
+		if err == nil && !retryable(status) {
+			p.breaker.record(true)
+			return status, header, body, nil
+		}
+
+		// the caller gave up, which says nothing about the provider
+		if req.Context().Err() != nil {
+			p.breaker.release()
+			return 0, nil, nil, req.Context().Err()
+		}
+
+		// a used up plan is not worth retrying unless the provider says when to
+		exhausted := status == http.StatusTooManyRequests && PlanLimited(string(body)) && header.Get("Retry-After") == ""
+
+		if attempts > initialisers.PROVIDERRETRIES || exhausted {
+			p.breaker.record(false)
+			return 0, nil, nil, &ProviderError{Host: host, Status: status, Body: body, Attempts: attempts, Err: err}
+		}
+
+		if err := sleep(req.Context(), backoff(attempts-1, header)); err != nil {
+			p.breaker.release()
+			return 0, nil, nil, err
+		}
+	}
+}
+
+// Sends the request once the provider has a free slot and budget, and reads the whole response within the timeout.
+func (t *transport) attempt(p *provider, req *http.Request, payload []byte) (int, http.Header, []byte, error) {
+	ctx := req.Context()
+
+	if p.slots != nil {
+		select {
+		case p.slots <- struct{}{}:
+			defer func() { <-p.slots }()
+		case <-ctx.Done():
+			return 0, nil, nil, ctx.Err()
+		}
+	}
+
+	if err := p.requests.take(ctx, 1); err != nil {
+		return 0, nil, nil, err
+	}
+
+	if err := p.units.take(ctx, cost(req)); err != nil {
+		return 0, nil, nil, err
+	}
+
+	if initialisers.PROVIDERTIMEOUT > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, initialisers.PROVIDERTIMEOUT)
+		defer cancel()
+	}
+
+	out := req.Clone(ctx)
+	if req.Body != nil {
+		out.Body = io.NopCloser(bytes.NewReader(payload))
+	}
+
+	resp, err := t.next.RoundTrip(out)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	return resp.StatusCode, resp.Header, body, nil
+}
+
+// Returns the limits for the host, created from the configuration on first use.
+func (t *transport) provider(host string) *provider {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.providers == nil {
+		t.providers = map[string]*provider{}
+	}
+
+	if p, ok := t.providers[host]; ok {
+		return p
+	}
+
+	concurrency, rps, cups := initialisers.PROVIDERCONCURRENCY, initialisers.PROVIDERRPS, 0.0
+	if host == MoralisHost {
+		concurrency, rps, cups = initialisers.MORALISCONCURRENCY, initialisers.MORALISRPS, initialisers.MORALISCUPS
+	}
+
+	p := &provider{requests: newBucket(rps), units: newBucket(cups)}
+	if concurrency > 0 {
+		p.slots = make(chan struct{}, concurrency)
+	}
+
+	t.providers[host] = p
+
+	return p
+}
+
+// Returns the compute units the request costs. Only Moralis charges them.
+func cost(req *http.Request) float64 {
+	if req.URL.Hostname() != MoralisHost {
+		return 0
+	}
+
+	if units, ok := computeUnits[path.Base(req.URL.Path)]; ok {
+		return units
+	}
+
+	return defaultComputeUnits
+}
+
+func (f *flight) response(req *http.Request) (*http.Response, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.status, http.StatusText(f.status)),
+		StatusCode:    f.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        f.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(f.body)),
+		ContentLength: int64(len(f.body)),
+		Request:       req,
+	}, nil
+}
+
+// Token bucket refilled at rate per second and holding at most a second's worth. A nil bucket never waits.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &bucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// Waits until the cost can be spent, or until the context is done. A cost larger than the bucket waits for a full
+// bucket and leaves it in debt, so the requests after it wait for the budget to recover.
+func (b *bucket) take(ctx context.Context, cost float64) error {
+	if b == nil || cost <= 0 {
+		return nil
+	}
+
+	for {
+		b.mu.Lock()
+
+		now := time.Now()
+		b.tokens = math.Min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+
+		needed := math.Min(cost, b.rate)
+		if b.tokens >= needed {
+			b.tokens -= cost
+			b.mu.Unlock()
+			return nil
+		}
+
+		wait := time.Duration((needed - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Runs fn for each index from 0 to n-1 with at most workers running at once, and waits for them all.
+func Each(n, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+
+	slots := make(chan struct{}, workers)
+
+	for i := 0; i < n; i++ {
+		slots <- struct{}{}
+		wg.Add(1)
+
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,204 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Serves "ok" once release is closed, counting the requests received.
+func held(t *testing.T, release chan struct{}, requests *int32) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+
+		select {
+		case <-release:
+			w.Write([]byte("ok"))
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+// Waits until the number of callers waiting for requests in flight reaches waiters.
+func waitFor(t *testing.T, tr *transport, waiters int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		tr.mu.Lock()
+		var joined int
+		for _, current := range tr.flights {
+			joined += current.waiters
+		}
+		tr.mu.Unlock()
+
+		if joined == waiters {
+			return
+		}
+	}
+
+	t.Fatalf("callers did not join one flight")
+}
+
+func TestSharedRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		// whether each caller gives up before the response arrives
+		cancelled []bool
+		requests  int32
+	}{
+		{"all wait", []bool{false, false, false}, 1},
+		{"first caller gives up", []bool{true, false}, 1},
+		{"later caller gives up", []bool{false, true}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configure(t, 0, 0, time.Minute)
+
+			var requests int32
+			release := make(chan struct{})
+			url := held(t, release, &requests)
+
+			tr := &transport{next: http.DefaultTransport}
+
+			type result struct {
+				body string
+				err  error
+			}
+
+			results := make([]chan result, len(test.cancelled))
+			cancels := make([]context.CancelFunc, len(test.cancelled))
+
+			for i := range test.cancelled {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				results[i] = make(chan result, 1)
+				cancels[i] = cancel
+
+				req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+
+				go func(out chan result) {
+					resp, err := tr.RoundTrip(req)
+					if err != nil {
+						out <- result{err: err}
+						return
+					}
+
+					body, _ := io.ReadAll(resp.Body)
+					out <- result{body: string(body)}
+				}(results[i])
+			}
+
+			waitFor(t, tr, len(test.cancelled))
+
+			for i, cancelled := range test.cancelled {
+				if cancelled {
+					cancels[i]()
+
+					if got := <-results[i]; !errors.Is(got.err, context.Canceled) {
+						t.Fatalf("caller %v: RoundTrip() error = %v, want its own cancellation", i, got.err)
+					}
+				}
+			}
+
+			close(release)
+
+			for i, cancelled := range test.cancelled {
+				if cancelled {
+					continue
+				}
+
+				if got := <-results[i]; got.err != nil || got.body != "ok" {
+					t.Errorf("caller %v: RoundTrip() = %q, %v, want the shared response", i, got.body, got.err)
+				}
+			}
+
+			if got := atomic.LoadInt32(&requests); got != test.requests {
+				t.Errorf("provider received %v requests, want %v", got, test.requests)
+			}
+		})
+	}
+}
+
+func TestAbandonedRequestIsCancelled(t *testing.T) {
+	configure(t, 0, 0, time.Minute)
+
+	var requests int32
+	release := make(chan struct{})
+	defer close(release)
+
+	url := held(t, release, &requests)
+	tr := &transport{next: http.DefaultTransport}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+
+	if _, err := tr.RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("RoundTrip() error = %v, want the caller's deadline", err)
+	}
+
+	tr.mu.Lock()
+	flights := len(tr.flights)
+	tr.mu.Unlock()
+
+	if flights != 0 {
+		t.Errorf("%v flights kept after every caller gave up", flights)
+	}
+}
+
+func TestBucketTake(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		tokens  float64
+		cost    float64
+		timeout time.Duration
+		err     error
+	}{
+		{"unlimited", 0, 0, 100, time.Millisecond, nil},
+		{"within budget", 10, 10, 5, time.Millisecond, nil},
+		{"waits past the caller's deadline", 1, 0, 5, 20 * time.Millisecond, context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newBucket(test.rate)
+			if b != nil {
+				b.tokens = test.tokens
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+			defer cancel()
+
+			if err := b.take(ctx, test.cost); !errors.Is(err, test.err) {
+				t.Errorf("take() error = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestDetachedContext(t *testing.T) {
+	type key struct{}
+
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "tenant"))
+	cancel()
+
+	ctx := detached{parent}
+
+	if ctx.Err() != nil || ctx.Done() != nil || ctx.Value(key{}) != "tenant" {
+		t.Errorf("detached context = %v, %v, %v, want the parent's values without its cancellation", ctx.Err(), ctx.Done(), ctx.Value(key{}))
+	}
+}
@@ -0,0 +1,180 @@
+package spam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/moralis"
+	"github.com/harrisandtrotter/proof-of-balance/server/prices"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+// Maximum number of inflow transactions looked up when deciding whether a token was airdropped
+const maxInflowLookups = 10
+
+// Functions whose presence in a token contract lets the owner block or tax transfers
+var restrictionSignatures = []string{
+	"isBlacklisted(address)",
+	"blacklist(address)",
+	"addToBlacklist(address)",
+	"setBlacklist(address,bool)",
+	"addBots(address[])",
+	"setBots(address[])",
+	"isBot(address)",
+	"setMaxTxAmount(uint256)",
+	"setMaxWalletSize(uint256)",
+	"setTradingEnabled(bool)",
+	"enableTrading()",
+	"openTrading()",
+	"setSellFee(uint256)",
+	"setTaxFeePercent(uint256)",
+}
+
+// ERC20 transfer as returned by the Moralis transfers endpoint
+type transfer struct {
+	TransactionHash string `json:"transaction_hash"`
+	FromAddress     string `json:"from_address"`
+	ToAddress       string `json:"to_address"`
+}
+
+// A signal that could not be collected, named for the error it is recorded under
+type Failure struct {
+	Signal string
+	Err    error
+}
+
+// Gathers the network-backed signals for a token held by the address. Signals that cannot be collected are left unset
+// and returned as failures.
+func Collect(ctx context.Context, address, chain string, block int, token models.TokenBalance) (Signals, []Failure) {
+	signals := Signals{Token: token, Chain: chain}
+
+	var failures []Failure
+
+	airdropOnly, err := airdropOnly(ctx, address, chain, block, token.TokenAddress)
+	if err != nil {
+		failures = append(failures, Failure{"inflows", err})
+	} else {
+		signals.AirdropOnly = &airdropOnly
+	}
+
+	var price prices.Price
+
+	hasLiquidity, err := price.HasLiquidity(ctx, token.TokenAddress, chain, block)
+	if err != nil {
+		failures = append(failures, Failure{"liquidity", err})
+	} else {
+		signals.HasLiquidity = &hasLiquidity
+	}
+
+	restrictions, err := transferRestrictions(ctx, chain, token.TokenAddress, block)
+	if err != nil {
+		failures = append(failures, Failure{"contract code", err})
+	} else {
+		signals.Restrictions = restrictions
+	}
+
+	return signals, failures
+}
+
+// Reports whether the wallet only ever received the token, in transactions sent by someone else.
+// Without an rpc endpoint, a token the wallet has never sent is treated as airdropped.
+func airdropOnly(ctx context.Context, address, chain string, block int, tokenAddress string) (bool, error) {
+	endpoint := fmt.Sprintf("%v/%v/erc20/transfers?chain=%v&to_block=%v&contract_addresses%%5B0%%5D=%v", moralis.API, address, chain, block, tokenAddress)
+
+	var inflows []transfer
+
+	sent := false
+
+	// a transfer out of the wallet may be on any page, so pages are read until one is found
+	_, err := moralis.Pages(ctx, endpoint, func(items []json.RawMessage) (bool, error) {
+		for _, item := range items {
+			var t transfer
+
+			err := json.Unmarshal(item, &t)
+			if err != nil {
+				return false, faults.New(faults.ProviderInvalidResponse, "", err)
+			}
+
+			if !moralis.IsAddress(t.FromAddress) || !moralis.IsAddress(t.ToAddress) {
+				return false, faults.Newf(faults.ProviderInvalidResponse, "", "transfer %v has invalid addresses", t.TransactionHash)
+			}
+
+			if strings.EqualFold(t.FromAddress, address) {
+				sent = true
+				return false, nil
+			}
+
+			if strings.EqualFold(t.ToAddress, address) {
+				inflows = append(inflows, t)
+			}
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if sent {
+		return false, nil
+	}
+
+	if len(inflows) == 0 {
+		return false, nil
+	}
+
+	if _, err := rpc.Endpoint(chain); err != nil {
+		return true, nil
+	}
+
+	for i, t := range inflows {
+		if i == maxInflowLookups {
+			break
+		}
+
+		raw, err := rpc.Call(ctx, chain, "eth_getTransactionByHash", t.TransactionHash)
+		if err != nil {
+			return false, err
+		}
+
+		var tx struct {
+			From string `json:"from"`
+		}
+
+		err = json.Unmarshal(raw, &tx)
+		if err != nil {
+			return false, err
+		}
+
+		if strings.EqualFold(tx.From, address) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// Returns the restriction functions found in the token's bytecode at the block.
+func transferRestrictions(ctx context.Context, chain, tokenAddress string, block int) ([]string, error) {
+	code, err := rpc.GetCode(ctx, chain, tokenAddress, block)
+	if err != nil {
+		return nil, err
+	}
+
+	code = strings.ToLower(code)
+
+	var found []string
+
+	for _, signature := range restrictionSignatures {
+		// PUSH4 <selector> as emitted by the solidity function dispatcher
+		if strings.Contains(code, "63"+strings.TrimPrefix(rpc.Selector(signature), "0x")) {
+			found = append(found, signature)
+		}
+	}
+
+	return found, nil
+}
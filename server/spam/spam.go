@@ -0,0 +1,202 @@
+package spam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+)
+
+const (
+	// Score at or above which a token is treated as spam
+	Threshold = 50
+
+	// Maximum score
+	MaxScore = 100
+)
+
+// Weights applied per heuristic. The provider flag alone meets the threshold so tokens Moralis flags remain flagged.
+const (
+	providerFlagWeight    = 50
+	missingMetadataWeight = 20
+	noLogoWeight          = 5
+	phishingNameWeight    = 40
+	homoglyphWeight       = 15
+	airdropOnlyWeight     = 25
+	noLiquidityWeight     = 25
+	restrictionsWeight    = 25
+)
+
+// Name and symbol patterns used by phishing tokens to lure holders to a claim site
+var phishingPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)https?://`),
+	regexp.MustCompile(`(?i)www\.`),
+	regexp.MustCompile(`(?i)\.(com|io|org|net|xyz|app|finance|claim|site|online|pro|top|gift|live|cc)\b`),
+	regexp.MustCompile(`(?i)\b(claim|visit|redeem|voucher|reward|airdrop|eligible|bonus)\b`),
+	regexp.MustCompile(`(?i)t\.me/`),
+}
+
+// Signals gathered for one token. Nil pointers mean the signal was not collected.
+type Signals struct {
+	Token        models.TokenBalance
+	Chain        string
+	AirdropOnly  *bool
+	HasLiquidity *bool
+	Restrictions []string
+}
+
+// Spam score and the reasons behind it
+type Result struct {
+	Score   int      `json:"spam_score"`
+	Spam    bool     `json:"spam"`
+	Reasons []string `json:"spam_reasons"`
+}
+
+// Local allow or deny list entry
+type ListEntry struct {
+	Chain        string `json:"chain"`
+	TokenAddress string `json:"token_address"`
+	Reason       string `json:"reason"`
+}
+
+// Local allow and deny lists, read from the spam list file
+type Lists struct {
+	Allow []ListEntry `json:"allow"`
+	Deny  []ListEntry `json:"deny"`
+}
+
+// Reads the allow and deny lists. A missing file means empty lists.
+func LoadLists() (Lists, error) {
+	var lists Lists
+
+	body, err := os.ReadFile(initialisers.SPAMLISTFILE)
+	if errors.Is(err, os.ErrNotExist) {
+		return lists, nil
+	}
+	if err != nil {
+		return lists, fmt.Errorf("error reading spam list file: %v", err)
+	}
+
+	err = json.Unmarshal(body, &lists)
+	if err != nil {
+		return lists, fmt.Errorf("error parsing spam list file: %v", err)
+	}
+
+	return lists, nil
+}
+
+// Returns the matching entry for the token on the chain, if any.
+func (l Lists) find(entries []ListEntry, chain, tokenAddress string) (ListEntry, bool) {
+	for _, entry := range entries {
+		if !strings.EqualFold(entry.TokenAddress, tokenAddress) {
+			continue
+		}
+
+		if entry.Chain == "" {
+			return entry, true
+		}
+
+		blockchain, err := models.DetermineChain(entry.Chain)
+		if err == nil && blockchain == chain {
+			return entry, true
+		}
+	}
+
+	return ListEntry{}, false
+}
+
+// Scores the token from the collected signals. Allow and deny list entries override the heuristics.
+func Classify(signals Signals, lists Lists) Result {
+	token := signals.Token
+
+	if entry, ok := lists.find(lists.Allow, signals.Chain, token.TokenAddress); ok {
+		return Result{Score: 0, Spam: false, Reasons: []string{listReason("allow-listed", entry)}}
+	}
+
+	if entry, ok := lists.find(lists.Deny, signals.Chain, token.TokenAddress); ok {
+		return Result{Score: MaxScore, Spam: true, Reasons: []string{listReason("deny-listed", entry)}}
+	}
+
+	result := Result{Reasons: []string{}}
+
+	if token.PossibleSpam {
+		result.add(providerFlagWeight, "flagged as possible spam by Moralis")
+	}
+
+	if strings.TrimSpace(token.Name) == "" || strings.TrimSpace(token.Symbol) == "" {
+		result.add(missingMetadataWeight, "token name or symbol is missing")
+	}
+
+	if token.Logo == "" && token.Thumbnail == "" {
+		result.add(noLogoWeight, "no logo registered with the data provider")
+	}
+
+	for _, pattern := range phishingPatterns {
+		if pattern.MatchString(token.Name) || pattern.MatchString(token.Symbol) {
+			result.add(phishingNameWeight, "name or symbol contains a url or phishing call to action")
+			break
+		}
+	}
+
+	if hasHomoglyphs(token.Symbol) {
+		result.add(homoglyphWeight, "symbol contains non-ascii characters imitating a known ticker")
+	}
+
+	if signals.AirdropOnly != nil && *signals.AirdropOnly {
+		result.add(airdropOnlyWeight, "only ever received in transactions the wallet did not send (unsolicited airdrop)")
+	}
+
+	if signals.HasLiquidity != nil && !*signals.HasLiquidity {
+		result.add(noLiquidityWeight, "no pool with enough liquidity to price the token")
+	}
+
+	if len(signals.Restrictions) > 0 {
+		result.add(restrictionsWeight, fmt.Sprintf("contract exposes transfer restrictions typical of honeypots: %v", strings.Join(signals.Restrictions, ", ")))
+	}
+
+	result.Spam = result.Score >= Threshold
+
+	return result
+}
+
+func (r *Result) add(weight int, reason string) {
+	r.Score += weight
+	if r.Score > MaxScore {
+		r.Score = MaxScore
+	}
+
+	r.Reasons = append(r.Reasons, reason)
+}
+
+func listReason(list string, entry ListEntry) string {
+	if entry.Reason == "" {
+		return list
+	}
+
+	return fmt.Sprintf("%v: %v", list, entry.Reason)
+}
+
+// Reports whether the symbol mixes in non-ascii letters, e.g. a Cyrillic "Т" in "USDТ".
+func hasHomoglyphs(symbol string) bool {
+	var ascii, other bool
+
+	for _, r := range symbol {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+
+		if r < unicode.MaxASCII {
+			ascii = true
+		} else {
+			other = true
+		}
+	}
+
+	return ascii && other
+}
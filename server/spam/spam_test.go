@@ -0,0 +1,68 @@
+package spam
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+)
+
+func TestClassify(t *testing.T) {
+	yes, no := true, false
+
+	listed := "0x00000000000000000000000000000000000000aa"
+
+	// a token with metadata and a logo, so only the signals under test add to its score
+	token := func(change func(b *models.TokenBalance)) models.TokenBalance {
+		b := models.TokenBalance{TokenAddress: "0x00000000000000000000000000000000000000bb", Name: "USD Coin", Symbol: "USDC", Logo: "logo.png"}
+		change(&b)
+		return b
+	}
+
+	lists := Lists{
+		Allow: []ListEntry{{Chain: "eth", TokenAddress: listed, Reason: "client's own token"}},
+		Deny:  []ListEntry{{TokenAddress: "0x00000000000000000000000000000000000000CC"}},
+	}
+
+	tests := []struct {
+		name    string
+		signals Signals
+		score   int
+		spam    bool
+		reasons []string
+	}{
+		{"clean", Signals{Token: token(func(b *models.TokenBalance) {})}, 0, false, []string{}},
+		{"provider flag alone", Signals{Token: token(func(b *models.TokenBalance) { b.PossibleSpam = true })}, 50, true, []string{"flagged as possible spam by Moralis"}},
+		{"missing metadata and logo", Signals{Token: token(func(b *models.TokenBalance) { b.Symbol, b.Logo = " ", "" })}, 25, false, []string{"token name or symbol is missing", "no logo registered with the data provider"}},
+		{"phishing name", Signals{Token: token(func(b *models.TokenBalance) { b.Name = "Visit claim-usdc.com" })}, 40, false, []string{"name or symbol contains a url or phishing call to action"}},
+		{"homoglyph symbol", Signals{Token: token(func(b *models.TokenBalance) { b.Symbol = "USDС" })}, 15, false, []string{"symbol contains non-ascii characters imitating a known ticker"}},
+		{"non-latin symbol", Signals{Token: token(func(b *models.TokenBalance) { b.Symbol = "ДОГ" })}, 0, false, []string{}},
+		{"airdrop without liquidity", Signals{Token: token(func(b *models.TokenBalance) {}), AirdropOnly: &yes, HasLiquidity: &no}, 50, true, []string{
+			"only ever received in transactions the wallet did not send (unsolicited airdrop)",
+			"no pool with enough liquidity to price the token",
+		}},
+		{"uncollected signals", Signals{Token: token(func(b *models.TokenBalance) {}), AirdropOnly: nil, HasLiquidity: nil}, 0, false, []string{}},
+		{"restrictions", Signals{Token: token(func(b *models.TokenBalance) {}), Restrictions: []string{"blacklist(address)", "openTrading()"}}, 25, false, []string{
+			"contract exposes transfer restrictions typical of honeypots: blacklist(address), openTrading()",
+		}},
+		{"score capped", Signals{Token: token(func(b *models.TokenBalance) { b.PossibleSpam, b.Name = true, "airdrop" }), AirdropOnly: &yes, HasLiquidity: &no}, MaxScore, true, []string{
+			"flagged as possible spam by Moralis",
+			"name or symbol contains a url or phishing call to action",
+			"only ever received in transactions the wallet did not send (unsolicited airdrop)",
+			"no pool with enough liquidity to price the token",
+		}},
+		{"allow list wins", Signals{Chain: "eth", Token: token(func(b *models.TokenBalance) { b.TokenAddress, b.PossibleSpam = listed, true })}, 0, false, []string{"allow-listed: client's own token"}},
+		{"allow list for another chain", Signals{Chain: "bsc", Token: token(func(b *models.TokenBalance) { b.TokenAddress = listed })}, 0, false, []string{}},
+		{"deny list on any chain", Signals{Chain: "polygon", Token: token(func(b *models.TokenBalance) { b.TokenAddress = "0x00000000000000000000000000000000000000cc" })}, MaxScore, true, []string{"deny-listed"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Classify(test.signals, lists)
+
+			if result.Score != test.score || result.Spam != test.spam || !reflect.DeepEqual(result.Reasons, test.reasons) {
+				t.Errorf("Classify() = %+v, want score %v, spam %v, reasons %q", result, test.score, test.spam, test.reasons)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package staking
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/blocks"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+const (
+	// BNB Chain StakeHub system contract (native staking since the Beacon Chain fusion)
+	bscStakeHub = "0x0000000000000000000000000000000000002002"
+
+	// Polygon StakeManager. Polygon stake is held on Ethereum, not on the Polygon chain.
+	polygonStakeManager = "0x5e3ef299fddf15eaa0432e6e66473ace8c13d908"
+
+	// Fantom Special Fee Contract
+	fantomSFC = "0xfc00face00000000000000000000000000000000"
+
+	// Validators read per StakeHub getValidators page
+	bscPageSize = 100
+)
+
+// BNB Chain delegations. Each validator has a credit contract whose shares are redeemable for pooled BNB;
+// rewards compound into the pooled amount so there is nothing separately claimable.
+type bsc struct{}
+
+func (bsc) Delegations(ctx context.Context, address, stakingAddress string, cutOff time.Time, block int) ([]Delegation, error) {
+	delegator := rpc.EncodeAddress(address)
+	delegations := []Delegation{}
+
+	for offset := int64(0); ; offset += bscPageSize {
+		data := rpc.EncodeCall("getValidators(uint256,uint256)", rpc.EncodeUint(big.NewInt(offset)), rpc.EncodeUint(big.NewInt(bscPageSize)))
+
+		result, err := rpc.EthCall(ctx, "bsc", bscStakeHub, data, block)
+		if err != nil {
+			return nil, err
+		}
+
+		operators, err := rpc.DecodeAddressArray(result, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		credits, err := rpc.DecodeAddressArray(result, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		total, err := rpc.DecodeUintAt(result, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, credit := range credits {
+			pooled, err := rpc.CallUint(ctx, "bsc", credit, "getPooledBNB(address)", block, delegator)
+			if err != nil {
+				return nil, err
+			}
+
+			if pooled.Sign() == 0 {
+				continue
+			}
+
+			delegations = append(delegations, Delegation{
+				Chain:           "bsc",
+				StakedOn:        "bsc",
+				Asset:           "BNB",
+				Validator:       operators[i],
+				StakingContract: credit,
+				Principal:       rpc.ToDecimal(pooled, 18),
+				BlockNumber:     block,
+				Note:            "rewards compound into the pooled amount",
+				VerifyCommand:   rpc.VerifyCall("bsc", credit, "getPooledBNB(address)", block, delegator),
+			})
+		}
+
+		if offset+bscPageSize >= total.Int64() {
+			return delegations, nil
+		}
+	}
+}
+
+// Polygon delegations to ValidatorShare contracts registered with the StakeManager on Ethereum.
+// The Ethereum block at the cut-off is resolved separately as the Polygon block does not apply.
+type polygon struct{}
+
+func (polygon) Delegations(ctx context.Context, address, stakingAddress string, cutOff time.Time, block int) ([]Delegation, error) {
+	var b blocks.Block
+
+	ethBlock, err := b.BlockNumber(ctx, "eth", cutOff.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve the ethereum block at %v: %v", cutOff, err)
+	}
+
+	counter, err := rpc.CallUint(ctx, "eth", polygonStakeManager, "NFTCounter()", ethBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	delegator := rpc.EncodeAddress(address)
+	delegations := []Delegation{}
+
+	for id := int64(1); id < counter.Int64(); id++ {
+		share, err := rpc.CallAddress(ctx, "eth", polygonStakeManager, "getValidatorContract(uint256)", ethBlock, rpc.EncodeUint(big.NewInt(id)))
+		if err != nil {
+			return nil, err
+		}
+
+		if share == "0x0000000000000000000000000000000000000000" {
+			continue
+		}
+
+		stake, err := rpc.CallUint(ctx, "eth", share, "getTotalStake(address)", ethBlock, delegator)
+		if err != nil {
+			return nil, err
+		}
+
+		rewards, err := rpc.CallUint(ctx, "eth", share, "getLiquidRewards(address)", ethBlock, delegator)
+		if err != nil {
+			return nil, err
+		}
+
+		if stake.Sign() == 0 && rewards.Sign() == 0 {
+			continue
+		}
+
+		delegations = append(delegations, Delegation{
+			Chain:            "polygon",
+			StakedOn:         "eth",
+			Asset:            "MATIC",
+			Validator:        strconv.FormatInt(id, 10),
+			StakingContract:  share,
+			Principal:        rpc.ToDecimal(stake, 18),
+			UnclaimedRewards: rpc.ToDecimal(rewards, 18),
+			BlockNumber:      ethBlock,
+			Note:             "staked through the Polygon StakeManager on Ethereum",
+			VerifyCommand:    rpc.VerifyCall("eth", share, "getTotalStake(address)", ethBlock, delegator),
+		})
+	}
+
+	return delegations, nil
+}
+
+// Fantom delegations recorded in the SFC, per validator id.
+type fantom struct{}
+
+func (fantom) Delegations(ctx context.Context, address, stakingAddress string, cutOff time.Time, block int) ([]Delegation, error) {
+	last, err := rpc.CallUint(ctx, "fantom", fantomSFC, "lastValidatorID()", block)
+	if err != nil {
+		return nil, err
+	}
+
+	delegator := rpc.EncodeAddress(address)
+	delegations := []Delegation{}
+
+	for id := int64(1); id <= last.Int64(); id++ {
+		validator := rpc.EncodeUint(big.NewInt(id))
+
+		stake, err := rpc.CallUint(ctx, "fantom", fantomSFC, "getStake(address,uint256)", block, delegator, validator)
+		if err != nil {
+			return nil, err
+		}
+
+		rewards, err := rpc.CallUint(ctx, "fantom", fantomSFC, "pendingRewards(address,uint256)", block, delegator, validator)
+		if err != nil {
+			return nil, err
+		}
+
+		if stake.Sign() == 0 && rewards.Sign() == 0 {
+			continue
+		}
+
+		delegations = append(delegations, Delegation{
+			Chain:            "fantom",
+			StakedOn:         "fantom",
+			Asset:            "FTM",
+			Validator:        strconv.FormatInt(id, 10),
+			StakingContract:  fantomSFC,
+			Principal:        rpc.ToDecimal(stake, 18),
+			UnclaimedRewards: rpc.ToDecimal(rewards, 18),
+			BlockNumber:      block,
+			VerifyCommand:    rpc.VerifyCall("fantom", fantomSFC, "getStake(address,uint256)", block, delegator, validator),
+		})
+	}
+
+	return delegations, nil
+}
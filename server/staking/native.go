@@ -0,0 +1,279 @@
+package staking
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/recorder"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+// Avalanche P-chain stake. The P-chain API has no historical state, so stake is read at the latest height and labelled as such.
+type avalanche struct{}
+
+func (avalanche) Delegations(ctx context.Context, address, stakingAddress string, cutOff time.Time, block int) ([]Delegation, error) {
+	if stakingAddress == "" {
+		return []Delegation{}, nil
+	}
+
+	if initialisers.AVALANCHEPCHAINURL == "" {
+		return nil, errors.New("no avalanche p-chain api configured (AVALANCHE_P_CHAIN_URL)")
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "platform.getStake",
+		"params":  map[string]interface{}{"addresses": []string{stakingAddress}, "validatorsOnly": false},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Result struct {
+			Staked string `json:"staked"`
+		} `json:"result"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	body, err := post(ctx, initialisers.AVALANCHEPCHAINURL, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Error != nil {
+		return nil, fmt.Errorf("platform.getStake failed: %v", response.Error.Message)
+	}
+
+	// amounts are in nAVAX
+	staked, ok := new(big.Int).SetString(response.Result.Staked, 10)
+	if !ok || staked.Sign() == 0 {
+		return []Delegation{}, nil
+	}
+
+	return []Delegation{{
+		Chain:           "avalanche",
+		StakedOn:        "avalanche p-chain",
+		Asset:           "AVAX",
+		Validator:       stakingAddress,
+		StakingContract: "P-chain",
+		Principal:       rpc.ToDecimal(staked, 9),
+		ReadAt:          "latest",
+		Note:            "p-chain stake read at the latest height; rewards are paid when the staking period ends",
+		VerifyCommand:   fmt.Sprintf(`curl -s -X POST -H "Content-Type: application/json" --data '%s' "$AVALANCHE_P_CHAIN_URL"`, payload),
+	}}, nil
+}
+
+// Cronos POS chain delegations, read from the Cosmos REST API at the last height at or before the cut-off.
+type cronos struct{}
+
+// CRO on the POS chain has 8 decimals (basecro)
+const basecroDecimals = 8
+
+func (cronos) Delegations(ctx context.Context, address, stakingAddress string, cutOff time.Time, block int) ([]Delegation, error) {
+	if stakingAddress == "" {
+		return []Delegation{}, nil
+	}
+
+	if initialisers.CRONOSPOSURL == "" {
+		return nil, errors.New("no cronos pos api configured (CRONOS_POS_LCD_URL)")
+	}
+
+	height, err := cosmosHeightAt(ctx, cutOff)
+	if err != nil {
+		return nil, err
+	}
+
+	var delegations struct {
+		DelegationResponses []struct {
+			Delegation struct {
+				ValidatorAddress string `json:"validator_address"`
+			} `json:"delegation"`
+			Balance struct {
+				Amount string `json:"amount"`
+			} `json:"balance"`
+		} `json:"delegation_responses"`
+	}
+
+	err = cosmosGet(ctx, fmt.Sprintf("/cosmos/staking/v1beta1/delegations/%v", stakingAddress), height, &delegations)
+	if err != nil {
+		return nil, err
+	}
+
+	var rewards struct {
+		Rewards []struct {
+			ValidatorAddress string `json:"validator_address"`
+			Reward           []struct {
+				Denom  string `json:"denom"`
+				Amount string `json:"amount"`
+			} `json:"reward"`
+		} `json:"rewards"`
+	}
+
+	err = cosmosGet(ctx, fmt.Sprintf("/cosmos/distribution/v1beta1/delegators/%v/rewards", stakingAddress), height, &rewards)
+	if err != nil {
+		return nil, err
+	}
+
+	unclaimed := map[string]float64{}
+	for _, r := range rewards.Rewards {
+		for _, coin := range r.Reward {
+			if coin.Denom != "basecro" {
+				continue
+			}
+
+			// reward amounts are decimal coins with 18 places of precision
+			amount, err := strconv.ParseFloat(coin.Amount, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			unclaimed[r.ValidatorAddress] += amount / 1e8
+		}
+	}
+
+	results := []Delegation{}
+
+	for _, d := range delegations.DelegationResponses {
+		amount, ok := new(big.Int).SetString(d.Balance.Amount, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid delegation amount %q", d.Balance.Amount)
+		}
+
+		results = append(results, Delegation{
+			Chain:            "cronos",
+			StakedOn:         "cronos pos",
+			Asset:            "CRO",
+			Validator:        d.Delegation.ValidatorAddress,
+			StakingContract:  "staking module",
+			Principal:        rpc.ToDecimal(amount, basecroDecimals),
+			UnclaimedRewards: unclaimed[d.Delegation.ValidatorAddress],
+			BlockNumber:      height,
+			VerifyCommand:    fmt.Sprintf(`curl -s -H "x-cosmos-block-height: %v" "$CRONOS_POS_LCD_URL/cosmos/staking/v1beta1/delegations/%v"`, height, stakingAddress),
+		})
+	}
+
+	return results, nil
+}
+
+// Binary searches the Cosmos chain for the last height whose block time is at or before the cut-off.
+func cosmosHeightAt(ctx context.Context, cutOff time.Time) (int, error) {
+	latestHeight, latestTime, err := cosmosBlock(ctx, "latest")
+	if err != nil {
+		return 0, err
+	}
+
+	if !latestTime.After(cutOff) {
+		return latestHeight, nil
+	}
+
+	low, high := 1, latestHeight
+	for low < high {
+		mid := (low + high + 1) / 2
+
+		_, blockTime, err := cosmosBlock(ctx, strconv.Itoa(mid))
+		if err != nil {
+			return 0, err
+		}
+
+		if blockTime.After(cutOff) {
+			high = mid - 1
+		} else {
+			low = mid
+		}
+	}
+
+	return low, nil
+}
+
+// Returns the height and time of a block ("latest" or a height).
+func cosmosBlock(ctx context.Context, height string) (int, time.Time, error) {
+	var block struct {
+		Block struct {
+			Header struct {
+				Height string    `json:"height"`
+				Time   time.Time `json:"time"`
+			} `json:"header"`
+		} `json:"block"`
+	}
+
+	err := cosmosGet(ctx, "/cosmos/base/tendermint/v1beta1/blocks/"+height, 0, &block)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	h, err := strconv.Atoi(block.Block.Header.Height)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return h, block.Block.Header.Time, nil
+}
+
+// Performs a GET against the Cosmos REST API, at a historical height when height is non-zero.
+func cosmosGet(ctx context.Context, path string, height int, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimRight(initialisers.CRONOSPOSURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Accept", "application/json")
+	if height > 0 {
+		req.Header.Add("x-cosmos-block-height", strconv.Itoa(height))
+	}
+
+	resp, err := recorder.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return faults.Response("cronos pos api", resp.StatusCode, body)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+func post(ctx context.Context, url string, payload []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := recorder.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
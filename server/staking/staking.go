@@ -0,0 +1,49 @@
+package staking
+
+import (
+	"context"
+	"time"
+)
+
+// Stake delegated to a validator at the cut-off
+type Delegation struct {
+	// Chain whose native asset is staked, and the chain or layer the stake is recorded on
+	Chain            string  `json:"chain"`
+	StakedOn         string  `json:"staked_on"`
+	Asset            string  `json:"asset_symbol"`
+	Validator        string  `json:"validator"`
+	StakingContract  string  `json:"staking_contract"`
+	Principal        float64 `json:"principal"`
+	UnclaimedRewards float64 `json:"unclaimed_rewards"`
+	// Block or height the stake was read at, or "latest" in ReadAt for chains without historical state
+	BlockNumber int    `json:"block_number"`
+	ReadAt      string `json:"read_at,omitempty"`
+	Note        string `json:"note,omitempty"`
+	// Command reproducing the read of the principal against the chain's own API
+	VerifyCommand string `json:"verify_command,omitempty"`
+}
+
+// Reads delegations for one chain. StakingAddress is the delegator's address on chains where staking
+// is not done from the EVM address (Avalanche P-chain, Cronos POS), and may be empty otherwise.
+type Adapter interface {
+	Delegations(ctx context.Context, address, stakingAddress string, cutOff time.Time, block int) ([]Delegation, error)
+}
+
+// Adapters keyed by Moralis chain name
+var adapters = map[string]Adapter{
+	"bsc":       bsc{},
+	"polygon":   polygon{},
+	"fantom":    fantom{},
+	"avalanche": avalanche{},
+	"cronos":    cronos{},
+}
+
+// Returns the delegations for the address on the chain at the cut-off. Chains without native delegation return none.
+func Delegations(ctx context.Context, chain, address, stakingAddress string, cutOff time.Time, block int) ([]Delegation, error) {
+	adapter, ok := adapters[chain]
+	if !ok {
+		return []Delegation{}, nil
+	}
+
+	return adapter.Delegations(ctx, address, stakingAddress, cutOff, block)
+}
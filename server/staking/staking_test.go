@@ -0,0 +1,171 @@
+package staking
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/initialisers"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc/rpctest"
+)
+
+func word(n int64) string {
+	return rpc.EncodeUint(big.NewInt(n))
+}
+
+func TestDelegations(t *testing.T) {
+	holder, operator, credit := "0x"+strings.Repeat("1", 40), "0x"+strings.Repeat("2", 40), "0x"+strings.Repeat("3", 40)
+	cutOff := time.Date(2024, 3, 31, 23, 59, 59, 0, time.UTC)
+
+	// one StakeHub validator, with 1.5 BNB pooled for the holder
+	stakeHub := func(node *rpctest.Node) {
+		node.Return(bscStakeHub, "getValidators(uint256,uint256)", nil,
+			word(96), word(160), word(1), // offsets of both arrays, then the total
+			word(1), rpc.EncodeAddress(operator),
+			word(1), rpc.EncodeAddress(credit),
+		)
+		node.Return(credit, "getPooledBNB(address)", nil, word(1.5e18))
+	}
+
+	// two SFC validators, the holder delegating 2 FTM with 0.5 pending to the second
+	sfc := func(node *rpctest.Node) {
+		node.Return(fantomSFC, "lastValidatorID()", nil, word(2))
+		node.Return(fantomSFC, "getStake(address,uint256)", []string{rpc.EncodeAddress(holder), word(1)}, word(0))
+		node.Return(fantomSFC, "pendingRewards(address,uint256)", []string{rpc.EncodeAddress(holder), word(1)}, word(0))
+		node.Return(fantomSFC, "getStake(address,uint256)", []string{rpc.EncodeAddress(holder), word(2)}, word(2e18))
+		node.Return(fantomSFC, "pendingRewards(address,uint256)", []string{rpc.EncodeAddress(holder), word(2)}, word(0.5e18))
+	}
+
+	tests := []struct {
+		name  string
+		chain string
+		setup func(node *rpctest.Node)
+		// principal and rewards of the one delegation expected, or no delegation when validator is empty
+		validator          string
+		principal, rewards float64
+		err                bool
+	}{
+		{"bsc pooled stake", "bsc", stakeHub, operator, 1.5, 0, false},
+		{"bsc nothing pooled", "bsc", func(node *rpctest.Node) {
+			stakeHub(node)
+			node.Return(credit, "getPooledBNB(address)", nil, word(0))
+		}, "", 0, 0, false},
+		{"bsc credit contract reverts", "bsc", func(node *rpctest.Node) {
+			stakeHub(node)
+			node.Revert(credit, "getPooledBNB(address)")
+		}, "", 0, 0, true},
+		{"bsc credit contract fails at the node", "bsc", func(node *rpctest.Node) {
+			stakeHub(node)
+			node.Fail(credit, "getPooledBNB(address)")
+		}, "", 0, 0, true},
+		{"fantom delegation", "fantom", sfc, "2", 2, 0.5, false},
+		{"fantom rewards fail at the node", "fantom", func(node *rpctest.Node) {
+			sfc(node)
+			node.Fail(fantomSFC, "pendingRewards(address,uint256)", rpc.EncodeAddress(holder), word(2))
+		}, "", 0, 0, true},
+		{"chain without delegation", "eth", func(node *rpctest.Node) {}, "", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := rpctest.New(t, test.chain)
+			test.setup(node)
+
+			delegations, err := Delegations(context.Background(), test.chain, holder, "", cutOff, 100)
+
+			if test.err {
+				if err == nil {
+					t.Fatalf("Delegations() = %+v, want an error", delegations)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.validator == "" {
+				if len(delegations) != 0 {
+					t.Fatalf("Delegations() = %+v, want none", delegations)
+				}
+
+				return
+			}
+
+			if len(delegations) != 1 {
+				t.Fatalf("Delegations() = %+v, want one", delegations)
+			}
+
+			delegation := delegations[0]
+
+			if delegation.Validator != test.validator || delegation.Principal != test.principal || delegation.UnclaimedRewards != test.rewards || delegation.BlockNumber != 100 || delegation.VerifyCommand == "" {
+				t.Errorf("Delegations() = %+v, want %v staked with %v and %v in rewards", delegation, test.principal, test.validator, test.rewards)
+			}
+		})
+	}
+}
+
+func TestAvalancheDelegations(t *testing.T) {
+	saved := initialisers.AVALANCHEPCHAINURL
+	t.Cleanup(func() { initialisers.AVALANCHEPCHAINURL = saved })
+
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		stakedBy string
+		// principal of the one delegation expected, or none when zero
+		principal float64
+		err       bool
+	}{
+		{"stake", http.StatusOK, `{"jsonrpc":"2.0","id":1,"result":{"staked":"2500000000"}}`, "P-avax1abc", 2.5, false},
+		{"no stake", http.StatusOK, `{"jsonrpc":"2.0","id":1,"result":{"staked":"0"}}`, "P-avax1abc", 0, false},
+		{"no staking address", http.StatusOK, `{}`, "", 0, false},
+		{"api error", http.StatusOK, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"invalid address"}}`, "P-avax1abc", 0, true},
+		{"api fails", http.StatusServiceUnavailable, `service unavailable`, "P-avax1abc", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			initialisers.AVALANCHEPCHAINURL = server.URL
+
+			delegations, err := Delegations(context.Background(), "avalanche", "0x"+strings.Repeat("1", 40), test.stakedBy, time.Now(), 100)
+
+			if test.err {
+				if err == nil {
+					t.Fatalf("Delegations() = %+v, want an error", delegations)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.principal == 0 {
+				if len(delegations) != 0 {
+					t.Fatalf("Delegations() = %+v, want none", delegations)
+				}
+
+				return
+			}
+
+			if len(delegations) != 1 || delegations[0].Principal != test.principal || delegations[0].ReadAt != "latest" {
+				t.Errorf("Delegations() = %+v, want %v AVAX read at the latest height", delegations, test.principal)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package vesting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+// Token locker queried by beneficiary
+type locker struct {
+	protocol string
+	contract string
+	find     func(ctx context.Context, contract, address, chain string, cutOff time.Time, block int) ([]Schedule, error)
+}
+
+// Locker deployments keyed by chain
+var lockers = map[string][]locker{
+	"eth": {
+		{protocol: "team.finance", contract: "0xe2fe530c047f2d85298b07d9333c05737f1435fb", find: teamFinance},
+		{protocol: "unicrypt", contract: "0xdba68f07d1b7ca219f78ae8582c213d975c25caf", find: unicrypt},
+	},
+}
+
+// Team.Finance locks. Each deposit unlocks in full at its unlock time.
+func teamFinance(ctx context.Context, contract, address, chain string, cutOff time.Time, block int) ([]Schedule, error) {
+	result, err := rpc.EthCall(ctx, chain, contract, rpc.EncodeCall("getDepositsByWithdrawalAddress(address)", rpc.EncodeAddress(address)), block)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := rpc.DecodeUintArray(result, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := []Schedule{}
+
+	for _, id := range ids {
+		result, err := rpc.EthCall(ctx, chain, contract, rpc.EncodeCall("lockedToken(uint256)", rpc.EncodeUint(id)), block)
+		if err != nil {
+			return nil, err
+		}
+
+		// (tokenAddress, withdrawalAddress, tokenAmount, unlockTime, withdrawn)
+		words := rpc.Words(result)
+		if len(words) < 5 {
+			continue
+		}
+
+		withdrawn, _ := rpc.DecodeUint(words[4])
+		if withdrawn != nil && withdrawn.Sign() > 0 {
+			continue
+		}
+
+		amount, err := rpc.DecodeUint(words[2])
+		if err != nil {
+			return nil, err
+		}
+
+		unlockTime, err := rpc.DecodeUint(words[3])
+		if err != nil {
+			return nil, err
+		}
+
+		raw := amounts{token: rpc.DecodeAddress(words[0]), id: id.String(), total: amount, vested: big.NewInt(0), claimable: big.NewInt(0)}
+		if unlockTime.Int64() <= cutOff.Unix() {
+			raw.vested = amount
+			raw.claimable = amount
+		}
+
+		found, err := schedule(ctx, "team.finance", chain, contract, raw, block)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules = append(schedules, *found)
+	}
+
+	return schedules, nil
+}
+
+// Unicrypt (UNCX) token vesting. Locks are held as shares of the locker's token balance.
+func unicrypt(ctx context.Context, contract, address, chain string, cutOff time.Time, block int) ([]Schedule, error) {
+	user := rpc.EncodeAddress(address)
+
+	count, err := rpc.CallUint(ctx, chain, contract, "getUserLockedTokensLength(address)", block, user)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := []Schedule{}
+
+	for i := int64(0); i < count.Int64(); i++ {
+		token, err := rpc.CallAddress(ctx, chain, contract, "getUserLockedTokenAtIndex(address,uint256)", block, user, rpc.EncodeUint(big.NewInt(i)))
+		if err != nil {
+			return nil, err
+		}
+
+		locks, err := rpc.CallUint(ctx, chain, contract, "getUserLocksForTokenLength(address,address)", block, user, rpc.EncodeAddress(token))
+		if err != nil {
+			return nil, err
+		}
+
+		// tokens per share = locker's token balance / total shares for the token
+		held, err := rpc.CallUint(ctx, chain, token, "balanceOf(address)", block, rpc.EncodeAddress(contract))
+		if err != nil {
+			return nil, err
+		}
+
+		totalShares, err := rpc.CallUint(ctx, chain, contract, "SHARES(address)", block, rpc.EncodeAddress(token))
+		if err != nil {
+			return nil, err
+		}
+
+		for j := int64(0); j < locks.Int64(); j++ {
+			lockID, err := rpc.CallUint(ctx, chain, contract, "getUserLockIDForTokenAtIndex(address,address,uint256)", block, user, rpc.EncodeAddress(token), rpc.EncodeUint(big.NewInt(j)))
+			if err != nil {
+				return nil, err
+			}
+
+			// (lockID, tokenAddress, sharesDeposited, sharesWithdrawn, startEmission, endEmission, owner, condition)
+			result, err := rpc.EthCall(ctx, chain, contract, rpc.EncodeCall("getLock(uint256)", rpc.EncodeUint(lockID)), block)
+			if err != nil {
+				return nil, err
+			}
+
+			words := rpc.Words(result)
+			if len(words) < 6 {
+				continue
+			}
+
+			deposited, _ := rpc.DecodeUint(words[2])
+			withdrawn, _ := rpc.DecodeUint(words[3])
+			if deposited == nil || withdrawn == nil {
+				continue
+			}
+
+			remaining := rpc.Share(new(big.Int).Sub(deposited, withdrawn), held, totalShares)
+
+			claimable, err := rpc.CallUint(ctx, chain, contract, "getWithdrawableTokens(uint256)", block, rpc.EncodeUint(lockID))
+			if err != nil {
+				return nil, err
+			}
+
+			found, err := schedule(ctx, "unicrypt", chain, contract, amounts{
+				token:     token,
+				id:        lockID.String(),
+				total:     remaining,
+				vested:    claimable,
+				claimable: claimable,
+				claimed:   rpc.Share(withdrawn, held, totalShares),
+			}, block)
+			if err != nil {
+				return nil, err
+			}
+
+			schedules = append(schedules, *found)
+		}
+	}
+
+	return schedules, nil
+}
+
+// Reads a custom vesting contract if the address is its beneficiary: OpenZeppelin TokenVesting (1.x and 2.x),
+// VestingWallet (4.x), or a contract with the same functions. The vested token is the one given, or the contract's
+// token() when none is. Total is the unreleased balance the contract holds, of which vested is the part releasable.
+func custom(ctx context.Context, address, chain, contract, token string, cutOff time.Time, block int) (*Schedule, error) {
+	beneficiary, err := rpc.CallAddress(ctx, chain, contract, "beneficiary()", block)
+	if err != nil {
+		return nil, fmt.Errorf("no beneficiary(): %v", err)
+	}
+
+	if !strings.EqualFold(beneficiary, address) {
+		return nil, nil
+	}
+
+	if token == "" {
+		token, err = rpc.CallAddress(ctx, chain, contract, "token()", block)
+		if err != nil {
+			return nil, fmt.Errorf("no token(), give the vested token as contract:token: %v", err)
+		}
+	}
+
+	asset := rpc.EncodeAddress(token)
+
+	held, err := rpc.CallUint(ctx, chain, token, "balanceOf(address)", block, rpc.EncodeAddress(contract))
+	if err != nil {
+		return nil, err
+	}
+
+	// every OpenZeppelin version takes the token; contracts vesting a single token may take nothing
+	released, err := rpc.CallUint(ctx, chain, contract, "released(address)", block, asset)
+	if err != nil {
+		released, err = rpc.CallUint(ctx, chain, contract, "released()", block)
+		if err != nil {
+			return nil, fmt.Errorf("no released(address) or released(): %v", err)
+		}
+	}
+
+	releasable, err := releasableAmount(ctx, chain, contract, asset, held, released, cutOff, block)
+	if err != nil {
+		return nil, err
+	}
+
+	return schedule(ctx, "token vesting", chain, contract, amounts{
+		token:     token,
+		id:        contract,
+		total:     held,
+		vested:    releasable,
+		claimable: releasable,
+		claimed:   released,
+		note:      "total is the unreleased balance held by the contract",
+	}, block)
+}
+
+// Returns the vested amount not yet released: from VestingWallet's releasable(token), OpenZeppelin 1.x's
+// releasableAmount(ctx, token), or for OpenZeppelin 2.x, which keeps it private, from the public schedule at the cut-off.
+func releasableAmount(ctx context.Context, chain, contract, asset string, held, released *big.Int, cutOff time.Time, block int) (*big.Int, error) {
+	for _, signature := range []string{"releasable(address)", "releasableAmount(ctx, address)"} {
+		if releasable, err := rpc.CallUint(ctx, chain, contract, signature, block, asset); err == nil {
+			return releasable, nil
+		}
+	}
+
+	if releasable, err := rpc.CallUint(ctx, chain, contract, "releasableAmount(ctx)", block); err == nil {
+		return releasable, nil
+	}
+
+	start, err := rpc.CallUint(ctx, chain, contract, "start()", block)
+	if err != nil {
+		return nil, fmt.Errorf("no releasable(address), releasableAmount or start(): %v", err)
+	}
+
+	cliff, errCliff := rpc.CallUint(ctx, chain, contract, "cliff()", block)
+	duration, errDuration := rpc.CallUint(ctx, chain, contract, "duration()", block)
+	if errCliff != nil || errDuration != nil {
+		return nil, errors.New("no cliff() or duration() to compute the vested amount from")
+	}
+
+	total := new(big.Int).Add(held, released)
+	now := big.NewInt(cutOff.Unix())
+
+	var vested *big.Int
+
+	revoked, err := rpc.CallUint(ctx, chain, contract, "revoked(address)", block, asset)
+
+	switch {
+	case now.Cmp(cliff) < 0:
+		vested = big.NewInt(0)
+	case now.Cmp(new(big.Int).Add(start, duration)) >= 0 || (err == nil && revoked.Sign() > 0):
+		vested = total
+	default:
+		vested = rpc.Share(total, new(big.Int).Sub(now, start), duration)
+	}
+
+	releasable := new(big.Int).Sub(vested, released)
+	if releasable.Sign() < 0 {
+		releasable = big.NewInt(0)
+	}
+
+	return releasable, nil
+}
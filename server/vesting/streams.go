@@ -0,0 +1,128 @@
+package vesting
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+// Sablier V2 lockup and Hedgey plan deployments keyed by chain and then by contract, as published by the protocols.
+// NFTs of any other contract are not probed, so a spam NFT answering the same calls cannot add a schedule.
+var streams = map[string]map[string]string{
+	"eth": {
+		// Sablier V2.0, V2.1 and V2.2 lockup linear, dynamic and tranched
+		"0xb10daee1fcf62243ae27776d7a92d39dc8740f95": "sablier",
+		"0x39efdc3dbb57b2388ccc4bb40ac4cb1226bc9e44": "sablier",
+		"0xafb979d9afad1ad27c5eff4e27226e3ab9e5dcc9": "sablier",
+		"0x7cc7e125d83a581ff438608490cc0f7bdff79127": "sablier",
+		"0x3962f6585946823440d274ad7c719b02b49de51e": "sablier",
+		"0x9deabf7815b42bf4e9a03eec35a486ff74ee7459": "sablier",
+		"0xf86b359035208e4529686a1825f2d5bee38c28a8": "sablier",
+		// Hedgey token vesting, token lockup and their voting variants
+		"0x2cde9919e81b20b4b33dd562a48a84b54c48f00c": "hedgey",
+		"0x1961a23409ca59eedca6a99c97e4087dad752486": "hedgey",
+		"0x1bb64af7fe05fc69c740609267d2abe3e119ef82": "hedgey",
+		"0x73cd8626b3cd47b009e68380720cfe6679a3ec3d": "hedgey",
+	},
+}
+
+// Reads a Sablier V2 lockup stream. Each stream is an NFT owned by the recipient.
+func sablier(ctx context.Context, chain, contract string, id *big.Int, block int) (amounts, error) {
+	stream := rpc.EncodeUint(id)
+
+	token, err := rpc.CallAddress(ctx, chain, contract, "getAsset(uint256)", block, stream)
+	if err != nil {
+		return amounts{}, err
+	}
+
+	deposited, err := rpc.CallUint(ctx, chain, contract, "getDepositedAmount(uint256)", block, stream)
+	if err != nil {
+		return amounts{}, err
+	}
+
+	streamed, err := rpc.CallUint(ctx, chain, contract, "streamedAmountOf(uint256)", block, stream)
+	if err != nil {
+		return amounts{}, err
+	}
+
+	withdrawn, err := rpc.CallUint(ctx, chain, contract, "getWithdrawnAmount(uint256)", block, stream)
+	if err != nil {
+		return amounts{}, err
+	}
+
+	withdrawable, err := rpc.CallUint(ctx, chain, contract, "withdrawableAmountOf(uint256)", block, stream)
+	if err != nil {
+		return amounts{}, err
+	}
+
+	total := deposited
+	note := ""
+
+	// cancelled streams refund the unstreamed amount to the sender
+	refunded, err := rpc.CallUint(ctx, chain, contract, "getRefundedAmount(uint256)", block, stream)
+	if err != nil && !rpc.Reverted(err) {
+		return amounts{}, err
+	}
+
+	if err == nil && refunded.Sign() > 0 {
+		total = new(big.Int).Sub(deposited, refunded)
+		note = "stream cancelled, unstreamed amount refunded to sender"
+	}
+
+	return amounts{
+		token:     token,
+		id:        id.String(),
+		total:     total,
+		vested:    streamed,
+		claimable: withdrawable,
+		claimed:   withdrawn,
+		note:      note,
+	}, nil
+}
+
+// Reads a Hedgey vesting or lockup plan. Each plan is an NFT owned by the beneficiary.
+// Redeemed tokens leave the plan, so the total is what remains in it at the block.
+func hedgey(ctx context.Context, chain, contract string, id *big.Int, cutOff time.Time, block int) (amounts, error) {
+	plan := rpc.EncodeUint(id)
+
+	result, err := rpc.EthCall(ctx, chain, contract, rpc.EncodeCall("plans(uint256)", plan), block)
+	if err != nil {
+		return amounts{}, err
+	}
+
+	words := rpc.Words(result)
+	if len(words) < 6 {
+		return amounts{}, fmt.Errorf("plans(uint256) returned %v words, wanted 6", len(words))
+	}
+
+	token := rpc.DecodeAddress(words[0])
+
+	total, err := rpc.DecodeUint(words[1])
+	if err != nil {
+		return amounts{}, err
+	}
+
+	timestamp := rpc.EncodeUint(big.NewInt(cutOff.Unix()))
+
+	result, err = rpc.EthCall(ctx, chain, contract, rpc.EncodeCall("planBalanceOf(uint256,uint256,uint256)", plan, timestamp, timestamp), block)
+	if err != nil {
+		return amounts{}, err
+	}
+
+	balance, err := rpc.DecodeUintAt(result, 0)
+	if err != nil {
+		return amounts{}, err
+	}
+
+	return amounts{
+		token:     token,
+		id:        id.String(),
+		total:     total,
+		vested:    balance,
+		claimable: balance,
+		note:      "amounts redeemed before the block are no longer held by the plan",
+	}, nil
+}
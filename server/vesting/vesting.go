@@ -0,0 +1,182 @@
+package vesting
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/nfts"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+)
+
+// Vesting schedule or lock with the address as beneficiary, valued at the cut-off block
+type Schedule struct {
+	Protocol   string  `json:"protocol"`
+	Contract   string  `json:"contract_address"`
+	ScheduleID string  `json:"schedule_id"`
+	Token      string  `json:"token_address"`
+	Symbol     string  `json:"asset_symbol"`
+	Decimals   int     `json:"decimals"`
+	Total      float64 `json:"total"`
+	Vested     float64 `json:"vested"`
+	Unvested   float64 `json:"unvested"`
+	Claimable  float64 `json:"claimable"`
+	Claimed    float64 `json:"claimed"`
+	Note       string  `json:"note,omitempty"`
+	// Why the stream, plan or custom contract could not be read, in which case the amounts are not reported
+	Error *faults.Error `json:"error,omitempty"`
+}
+
+// Raw amounts of a schedule before conversion with the token's decimals
+type amounts struct {
+	token     string
+	id        string
+	total     *big.Int
+	vested    *big.Int
+	claimable *big.Int
+	claimed   *big.Int
+	note      string
+}
+
+// Finds vesting schedules and token locks for the address at the block. Streams and plans represented by NFTs
+// (Sablier, Hedgey) are found from the address's NFTs, lockers (Team.Finance, Unicrypt) are queried by beneficiary,
+// and contracts lists custom vesting contracts to check, each as "contract" or "contract:token".
+func Find(ctx context.Context, address, chain string, cutOff time.Time, block int, contracts []string) ([]Schedule, error) {
+	if _, err := rpc.Endpoint(chain); err != nil {
+		return nil, err
+	}
+
+	schedules := []Schedule{}
+
+	// only chains with known stream deployments are worth listing the address's NFTs for
+	var held []models.NFTBalance
+
+	if len(streams[chain]) > 0 {
+		var err error
+
+		held, err = nfts.List(ctx, address, chain, block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// a stream or plan that cannot be read is reported on its own entry, as for custom contracts below
+	for _, nft := range held {
+		found, err := nftSchedule(ctx, address, chain, cutOff, block, nft)
+		if err != nil {
+			contract := strings.ToLower(nft.TokenAddress)
+			failure := faults.Wrap(faults.ProviderFailed, faults.StepVesting, fmt.Errorf("error reading %v schedule %v of %v: %w", streams[chain][contract], nft.TokenID, contract, err)).For(address, chain)
+			faults.Log(failure)
+			schedules = append(schedules, Schedule{Protocol: streams[chain][contract], Contract: contract, ScheduleID: nft.TokenID, Error: failure})
+
+			continue
+		}
+
+		if found != nil {
+			schedules = append(schedules, *found)
+		}
+	}
+
+	for _, locker := range lockers[chain] {
+		found, err := locker.find(ctx, locker.contract, address, chain, cutOff, block)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %v locks: %v", locker.protocol, err)
+		}
+
+		schedules = append(schedules, found...)
+	}
+
+	// a contract that cannot be read is reported on its own entry rather than failing the others
+	for _, entry := range contracts {
+		contract, token, _ := strings.Cut(strings.ToLower(strings.TrimSpace(entry)), ":")
+
+		found, err := custom(ctx, address, chain, contract, token, cutOff, block)
+		if err != nil {
+			failure := faults.Wrap(faults.ProviderFailed, faults.StepVesting, fmt.Errorf("error reading vesting contract %v: %w", contract, err)).For(address, chain)
+			faults.Log(failure)
+			schedules = append(schedules, Schedule{Protocol: "token vesting", Contract: contract, Token: token, Error: failure})
+
+			continue
+		}
+
+		if found != nil {
+			schedules = append(schedules, *found)
+		}
+	}
+
+	return schedules, nil
+}
+
+// Reads the stream or plan an NFT held by the address represents, when the NFT is from a known Sablier or Hedgey
+// deployment.
+func nftSchedule(ctx context.Context, address, chain string, cutOff time.Time, block int, nft models.NFTBalance) (*Schedule, error) {
+	id, ok := new(big.Int).SetString(nft.TokenID, 10)
+	if !ok {
+		return nil, nil
+	}
+
+	contract := strings.ToLower(nft.TokenAddress)
+
+	var raw amounts
+	var err error
+
+	protocol := streams[chain][contract]
+
+	switch protocol {
+	case "sablier":
+		raw, err = sablier(ctx, chain, contract, id, block)
+	case "hedgey":
+		raw, err = hedgey(ctx, chain, contract, id, cutOff, block)
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return schedule(ctx, protocol, chain, contract, raw, block)
+}
+
+// Converts raw amounts into a schedule using the vested token's decimals and symbol.
+func schedule(ctx context.Context, protocol, chain, contract string, raw amounts, block int) (*Schedule, error) {
+	decimals, err := rpc.CallUint(ctx, chain, raw.token, "decimals()", block)
+	if err != nil {
+		return nil, fmt.Errorf("error reading decimals of %v: %v", raw.token, err)
+	}
+
+	var symbol string
+	if result, err := rpc.EthCall(ctx, chain, raw.token, rpc.EncodeCall("symbol()"), block); err == nil {
+		symbol = rpc.DecodeString(result)
+	}
+
+	d := int(decimals.Int64())
+	unvested := new(big.Int).Sub(raw.total, raw.vested)
+	if unvested.Sign() < 0 {
+		unvested = big.NewInt(0)
+	}
+
+	claimed := raw.claimed
+	if claimed == nil {
+		claimed = big.NewInt(0)
+	}
+
+	return &Schedule{
+		Protocol:   protocol,
+		Contract:   contract,
+		ScheduleID: raw.id,
+		Token:      raw.token,
+		Symbol:     symbol,
+		Decimals:   d,
+		Total:      rpc.ToDecimal(raw.total, d),
+		Vested:     rpc.ToDecimal(raw.vested, d),
+		Unvested:   rpc.ToDecimal(unvested, d),
+		Claimable:  rpc.ToDecimal(raw.claimable, d),
+		Claimed:    rpc.ToDecimal(claimed, d),
+		Note:       raw.note,
+	}, nil
+}
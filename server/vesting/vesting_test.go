@@ -0,0 +1,157 @@
+package vesting
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harrisandtrotter/proof-of-balance/server/faults"
+	"github.com/harrisandtrotter/proof-of-balance/server/models"
+	"github.com/harrisandtrotter/proof-of-balance/server/moralis"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc"
+	"github.com/harrisandtrotter/proof-of-balance/server/rpc/rpctest"
+)
+
+func word(n int64) string {
+	return rpc.EncodeUint(big.NewInt(n))
+}
+
+// Sets the decimals and symbol of an ERC-20 on the node.
+func erc20(node *rpctest.Node, token, symbol string, decimals int64) {
+	node.Return(token, "decimals()", nil, word(decimals))
+	node.Return(token, "symbol()", nil, word(32), word(int64(len(symbol))), hex.EncodeToString([]byte(symbol))+strings.Repeat("0", 64-2*len(symbol)))
+}
+
+func TestNFTSchedule(t *testing.T) {
+	const (
+		sablierContract = "0xb10daee1fcf62243ae27776d7a92d39dc8740f95"
+		hedgeyContract  = "0x2cde9919e81b20b4b33dd562a48a84b54c48f00c"
+	)
+
+	holder, token := "0x"+strings.Repeat("1", 40), "0x"+strings.Repeat("2", 40)
+	cutOff := time.Date(2024, 3, 31, 23, 59, 59, 0, time.UTC)
+
+	stream := func(node *rpctest.Node) {
+		node.Return(sablierContract, "getAsset(uint256)", nil, rpc.EncodeAddress(token))
+		node.Return(sablierContract, "getDepositedAmount(uint256)", nil, word(1000e6))
+		node.Return(sablierContract, "streamedAmountOf(uint256)", nil, word(400e6))
+		node.Return(sablierContract, "getWithdrawnAmount(uint256)", nil, word(100e6))
+		node.Return(sablierContract, "withdrawableAmountOf(uint256)", nil, word(300e6))
+		node.Return(sablierContract, "getRefundedAmount(uint256)", nil, word(0))
+		erc20(node, token, "UNI", 6)
+	}
+
+	plan := func(node *rpctest.Node) {
+		node.Return(hedgeyContract, "plans(uint256)", nil, rpc.EncodeAddress(token), word(500e6), word(0), word(0), word(0), word(0))
+		node.Return(hedgeyContract, "planBalanceOf(uint256,uint256,uint256)", nil, word(200e6), word(300e6), word(0))
+		erc20(node, token, "UNI", 6)
+	}
+
+	tests := []struct {
+		name     string
+		contract string
+		setup    func(node *rpctest.Node)
+		// total, vested and claimable expected, or no schedule when found is false
+		found                    bool
+		total, vested, claimable float64
+		note                     string
+		err                      bool
+	}{
+		{"sablier stream", sablierContract, stream, true, 1000, 400, 300, "", false},
+		{"cancelled sablier stream", sablierContract, func(node *rpctest.Node) {
+			stream(node)
+			node.Return(sablierContract, "getRefundedAmount(uint256)", nil, word(600e6))
+		}, true, 400, 400, 300, "stream cancelled, unstreamed amount refunded to sender", false},
+		{"sablier version without refunds", sablierContract, func(node *rpctest.Node) {
+			stream(node)
+			node.Revert(sablierContract, "getRefundedAmount(uint256)")
+		}, true, 1000, 400, 300, "", false},
+		{"sablier read fails at the node", sablierContract, func(node *rpctest.Node) {
+			stream(node)
+			node.Fail(sablierContract, "streamedAmountOf(uint256)")
+		}, false, 0, 0, 0, "", true},
+		{"sablier stream reverts", sablierContract, func(node *rpctest.Node) {
+			stream(node)
+			node.Revert(sablierContract, "getDepositedAmount(uint256)")
+		}, false, 0, 0, 0, "", true},
+		{"hedgey plan", hedgeyContract, plan, true, 500, 200, 200, "amounts redeemed before the block are no longer held by the plan", false},
+		{"hedgey plan fails at the node", hedgeyContract, func(node *rpctest.Node) {
+			plan(node)
+			node.Fail(hedgeyContract, "planBalanceOf(uint256,uint256,uint256)")
+		}, false, 0, 0, 0, "", true},
+		{"hedgey plan too short", hedgeyContract, func(node *rpctest.Node) {
+			plan(node)
+			node.Return(hedgeyContract, "plans(uint256)", nil, rpc.EncodeAddress(token), word(500e6))
+		}, false, 0, 0, 0, "", true},
+		{"other contract", "0x" + strings.Repeat("3", 40), func(node *rpctest.Node) {}, false, 0, 0, 0, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := rpctest.New(t, "eth")
+			test.setup(node)
+
+			found, err := nftSchedule(context.Background(), holder, "eth", cutOff, 100, models.NFTBalance{TokenAddress: test.contract, TokenID: "7"})
+
+			if test.err {
+				if err == nil {
+					t.Fatalf("nftSchedule() = %+v, want an error", found)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !test.found {
+				if found != nil {
+					t.Fatalf("nftSchedule() = %+v, want no schedule", found)
+				}
+
+				return
+			}
+
+			if found == nil || found.ScheduleID != "7" || found.Symbol != "UNI" || found.Total != test.total || found.Vested != test.vested || found.Claimable != test.claimable || found.Unvested != test.total-test.vested || found.Note != test.note {
+				t.Errorf("nftSchedule() = %+v", found)
+			}
+		})
+	}
+}
+
+func TestFindReportsUnreadableStreams(t *testing.T) {
+	const sablierContract = "0xb10daee1fcf62243ae27776d7a92d39dc8740f95"
+
+	holder := "0x" + strings.Repeat("1", 40)
+
+	saved := moralis.API
+	t.Cleanup(func() { moralis.API = saved })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"cursor":null,"result":[{"token_address":"` + sablierContract + `","token_id":"7","amount":"1","contract_type":"ERC721"}]}`))
+	}))
+	defer server.Close()
+	moralis.API = server.URL
+
+	node := rpctest.New(t, "eth")
+	node.Fail(sablierContract, "getAsset(uint256)")
+
+	// the address has no locks
+	node.Return("0xe2fe530c047f2d85298b07d9333c05737f1435fb", "getDepositsByWithdrawalAddress(address)", nil, word(32), word(0))
+	node.Return("0xdba68f07d1b7ca219f78ae8582c213d975c25caf", "getUserLockedTokensLength(address)", nil, word(0))
+
+	schedules, err := Find(context.Background(), holder, "eth", time.Date(2024, 3, 31, 23, 59, 59, 0, time.UTC), 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(schedules) != 1 || schedules[0].Error == nil || schedules[0].Protocol != "sablier" || schedules[0].ScheduleID != "7" || schedules[0].Error.Step != faults.StepVesting {
+		t.Fatalf("Find() = %+v, want the stream reported with its error", schedules)
+	}
+}